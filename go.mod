@@ -20,6 +20,7 @@ require (
 	github.com/dustin/go-humanize v1.0.1
 	github.com/emicklei/go-restful-openapi/v2 v2.11.0
 	github.com/emicklei/go-restful/v3 v3.12.2
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gammazero/nexus/v3 v3.2.2
 	github.com/getlantern/systray v1.2.2
 	github.com/go-openapi/spec v0.21.0
@@ -47,6 +48,7 @@ require (
 	github.com/nleeper/goment v1.4.4
 	github.com/peterbourgon/diskv v2.0.1+incompatible
 	github.com/pkg/errors v0.9.1
+	github.com/pkg/sftp v1.13.6
 	github.com/putdotio/go-putio v1.7.2
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/rs/cors v1.11.1
@@ -69,6 +71,7 @@ require (
 	github.com/RoaringBitmap/roaring/v2 v2.4.5 // indirect
 	github.com/blevesearch/go-faiss v1.0.26 // indirect
 	github.com/blevesearch/zapx/v16 v16.2.8 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/nlnwa/whatwg-url v0.6.1 // indirect
 	github.com/robertkrimen/otto v0.5.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
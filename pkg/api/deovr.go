@@ -59,6 +59,7 @@ type DeoScene struct {
 	ScreenType       string               `json:"screenType"`
 	StereoMode       string               `json:"stereoMode"`
 	VideoLength      int                  `json:"videoLength"`
+	ResumePosition   float64              `json:"resumePosition,omitempty"`
 	VideoThumbnail   string               `json:"videoThumbnail"`
 	VideoPreview     string               `json:"videoPreview,omitempty"`
 	Encodings        []DeoSceneEncoding   `json:"encodings"`
@@ -141,6 +142,7 @@ type DeoScenePassthrough struct {
 	ScreenType       string               `json:"screenType"`
 	StereoMode       string               `json:"stereoMode"`
 	VideoLength      int                  `json:"videoLength"`
+	ResumePosition   float64              `json:"resumePosition,omitempty"`
 	VideoThumbnail   string               `json:"videoThumbnail"`
 	VideoPreview     string               `json:"videoPreview,omitempty"`
 	Encodings        []DeoSceneEncoding   `json:"encodings"`
@@ -190,21 +192,36 @@ func setDeoPlayerHost(req *restful.Request) {
 	}
 }
 
+// playerUser checks the login of a player. The DeoVR login of the options is the default user, other users log
+// in with their own password. The state is 1 when logged in, -1 when the login failed and 0 without a login.
+func playerUser(username string, password string) (models.User, int) {
+	if username == "" || password == "" {
+		return models.User{}, 0
+	}
+	if config.Config.Interfaces.DeoVR.Username != "" && username == config.Config.Interfaces.DeoVR.Username {
+		if bcrypt.CompareHashAndPassword([]byte(config.Config.Interfaces.DeoVR.Password), []byte(password)) == nil {
+			return models.GetDefaultUser(), 1
+		}
+		return models.User{}, -1
+	}
+	if user, err := models.AuthenticateUser(username, password); err == nil {
+		return user, 1
+	}
+	return models.User{}, -1
+}
+
 func restfulAuthFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
-	if isDeoAuthEnabled() {
-		authState := "0"
+	username, _ := req.BodyParameter("login")
+	password, _ := req.BodyParameter("password")
 
-		username, _ := req.BodyParameter("login")
-		password, _ := req.BodyParameter("password")
+	user, state := playerUser(username, password)
+	if state == 1 {
+		req.SetAttribute("user", user)
+		session.RememberUser(requestAddr(req), "deovr", user.Username)
+	}
 
-		if username != "" && password != "" {
-			cmpErr := bcrypt.CompareHashAndPassword([]byte(config.Config.Interfaces.DeoVR.Password), []byte(password))
-			if username == config.Config.Interfaces.DeoVR.Username && cmpErr == nil {
-				authState = "1"
-			} else {
-				authState = "-1"
-			}
-		}
+	if isDeoAuthEnabled() {
+		authState := strconv.Itoa(state)
 
 		if authState != "1" {
 			msg := "Login Required"
@@ -303,16 +320,22 @@ func (i DeoVRResource) getDeoFile(req *restful.Request, resp *restful.Response)
 		},
 	})
 
+	var resumePosition float64
+	if _, position, ok := models.ResumeFile(requestUser(req).ID, []models.File{file}); ok {
+		resumePosition = position
+	}
+
 	deoScene := DeoScene{
-		ID:          999900000 + file.ID,
-		Authorized:  1,
-		Description: file.Filename,
-		Title:       file.Filename,
-		Date:        file.CreatedTime.Unix(),
-		IsFavorite:  false,
-		Is3D:        true,
-		Encodings:   sources,
-		VideoLength: int(file.VideoDuration),
+		ID:             999900000 + file.ID,
+		Authorized:     1,
+		Description:    file.Filename,
+		Title:          file.Filename,
+		Date:           file.CreatedTime.Unix(),
+		IsFavorite:     false,
+		Is3D:           true,
+		Encodings:      sources,
+		VideoLength:    int(file.VideoDuration),
+		ResumePosition: resumePosition,
 	}
 
 	resp.WriteHeaderAndEntity(http.StatusOK, deoScene)
@@ -354,6 +377,7 @@ func (i DeoVRResource) getDeoScene(req *restful.Request, resp *restful.Response)
 			Preload("Files").
 			Where("id = ?", sceneID).First(&scene)
 	}
+	scene.ApplyUser(requestUser(req).ID)
 
 	var stereoMode string = ""
 	var screenType string = ""
@@ -531,6 +555,11 @@ func (i DeoVRResource) getDeoScene(req *restful.Request, resp *restful.Response)
 		chromaKey = gjson.Parse(`{"enabled":true,"hasAlpha":true,"h":0,"opacity":0,"s":0,"threshold":0,"v":0}`)
 	}
 
+	var resumePosition float64
+	if _, position, ok := models.ResumeFile(requestUser(req).ID, videoFiles); ok {
+		resumePosition = position
+	}
+
 	// set date to EPOCH in case it is missing or 0001-01-01
 	finalDate := scene.ReleaseDate.Unix()
 	if finalDate < 0 {
@@ -558,6 +587,7 @@ func (i DeoVRResource) getDeoScene(req *restful.Request, resp *restful.Response)
 		Encodings:        sources,
 		EncodingsSpatial: sourcesSpatial,
 		VideoLength:      int(videoLength),
+		ResumePosition:   resumePosition,
 		Timestamps:       cuepoints,
 		Categories:       categories,
 		Fleshlight:       deoScriptFiles,
@@ -590,6 +620,7 @@ func (i DeoVRResource) getDeoScene(req *restful.Request, resp *restful.Response)
 			Encodings:        sources,
 			EncodingsSpatial: sourcesSpatial,
 			VideoLength:      int(videoLength),
+			ResumePosition:   resumePosition,
 			Timestamps:       cuepoints,
 			Categories:       categories,
 			Fleshlight:       deoScriptFiles,
@@ -633,6 +664,7 @@ func (i DeoVRResource) getDeoLibrary(req *restful.Request, resp *restful.Respons
 		if err := json.Unmarshal([]byte(savedPlaylists[i].SearchParams), &r); err == nil {
 			r.IsAccessible = optional.NewBool(true)
 			r.IsAvailable = optional.NewBool(true)
+			r.UserID = requestUser(req).ID
 
 			summaries := models.QuerySceneSummaries(r)
 			sceneLists = append(sceneLists, DeoListScenes{
@@ -688,7 +720,7 @@ func filesToDeoList(req *restful.Request, files []models.File) []DeoListItem {
 
 	list := make([]DeoListItem, 0)
 	for i := range files {
-		if files[i].Volume.Type == "local" {
+		if files[i].Volume.Type == "local" || files[i].Volume.IsRemote() {
 			if !files[i].Volume.IsAvailable {
 				continue
 			}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	restfulspec "github.com/emicklei/go-restful-openapi/v2"
 	"github.com/emicklei/go-restful/v3"
@@ -14,6 +15,7 @@ import (
 	"github.com/xbapps/xbvr/pkg/common"
 	"github.com/xbapps/xbvr/pkg/models"
 	"github.com/xbapps/xbvr/pkg/session"
+	"github.com/xbapps/xbvr/pkg/storage"
 )
 
 type DMSResource struct{}
@@ -47,9 +49,46 @@ func (i DMSResource) WebService() *restful.WebService {
 		ContentEncodingEnabled(false).
 		Metadata(restfulspec.KeyOpenAPITags, tags))
 
+	ws.Route(ws.GET("/sessions").To(i.getSessions).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes([]session.Session{}))
+
 	return ws
 }
 
+// sessionClient tells the watch sessions of different headsets and players apart
+func requestAddr(req *restful.Request) string {
+	addr := req.Request.RemoteAddr
+	if lastColon := strings.LastIndex(addr, ":"); lastColon != -1 {
+		addr = addr[:lastColon]
+	}
+	return addr
+}
+
+// sessionClient identifies the player of a request. Only a user whose credentials UserFilter checked is taken,
+// streams without credentials belong to whoever logged in from the player, otherwise to the default user.
+func sessionClient(req *restful.Request) session.Client {
+	addr := requestAddr(req)
+	user := ""
+	if u, ok := req.Attribute("user").(models.User); ok {
+		user = u.Username
+	}
+
+	player := "other"
+	ua := strings.ToLower(req.Request.UserAgent())
+	switch {
+	case strings.Contains(ua, "deovr"):
+		player = "deovr"
+	case strings.Contains(ua, "heresphere"):
+		player = "heresphere"
+	}
+	return session.Client{RemoteAddr: addr, Player: player, User: user}
+}
+
+func (i DMSResource) getSessions(req *restful.Request, resp *restful.Response) {
+	resp.WriteHeaderAndEntity(http.StatusOK, session.ActiveSessions())
+}
+
 func (i DMSResource) getPreview(req *restful.Request, resp *restful.Response) {
 	sceneID := req.PathParameter("scene-id")
 	http.ServeFile(resp.ResponseWriter, req.Request, filepath.Join(common.VideoPreviewDir, fmt.Sprintf("%v.mp4", sceneID)))
@@ -79,7 +118,7 @@ func (i DMSResource) getFile(req *restful.Request, resp *restful.Response) {
 	case "local":
 		// Track current session
 		setDeoPlayerHost(req)
-		session.TrackSessionFromFile(f, doNotTrack)
+		session.TrackSessionFromFile(sessionClient(req), f, doNotTrack)
 
 		if err == gorm.ErrRecordNotFound {
 			resp.WriteHeader(http.StatusNotFound)
@@ -90,7 +129,28 @@ func (i DMSResource) getFile(req *restful.Request, resp *restful.Response) {
 		http.ServeFile(resp.ResponseWriter, req.Request, f.GetPath())
 		select {
 		case <-ctx.Done():
-			session.FinishTrackingFromFile(doNotTrack)
+			session.FinishTrackingFromFile(sessionClient(req), doNotTrack)
+			return
+		default:
+		}
+	case storage.TypeSFTP, storage.TypeWebDAV, storage.TypeS3:
+		setDeoPlayerHost(req)
+		session.TrackSessionFromFile(sessionClient(req), f, doNotTrack)
+
+		if err == gorm.ErrRecordNotFound {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		ctx := req.Request.Context()
+		if err := f.ServeRemote(resp.ResponseWriter, req.Request); err != nil {
+			log.Errorf("Can't stream %v from %v: %v", f.GetPath(), f.Volume.Path, err)
+			resp.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			session.FinishTrackingFromFile(sessionClient(req), doNotTrack)
 			return
 		default:
 		}
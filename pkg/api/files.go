@@ -16,6 +16,8 @@ import (
 	"github.com/emicklei/go-restful/v3"
 	"github.com/markphelps/optional"
 	"github.com/xbapps/xbvr/pkg/models"
+	"github.com/xbapps/xbvr/pkg/storage"
+	"github.com/xbapps/xbvr/pkg/tasks"
 )
 
 type RequestMatchFile struct {
@@ -27,6 +29,14 @@ type RequestUnmatchFile struct {
 	FileID uint `json:"file_id"`
 }
 
+type RequestFileProjection struct {
+	Projection string `json:"projection"`
+}
+
+type RequestDeleteDuplicates struct {
+	FileIDs []uint `json:"file_ids"`
+}
+
 type RequestFileList struct {
 	State       optional.String   `json:"state"`
 	CreatedDate []optional.String `json:"createdDate"`
@@ -65,6 +75,14 @@ func (i FilesResource) WebService() *restful.WebService {
 	ws.Route(ws.DELETE("/file/{file-id}").To(i.removeFile).
 		Metadata(restfulspec.KeyOpenAPITags, tags))
 
+	ws.Route(ws.PUT("/file/{file-id}/projection").To(i.setProjection).
+		Param(ws.PathParameter("file-id", "File ID").DataType("int")).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(models.File{}))
+
+	ws.Route(ws.POST("/duplicates/delete").To(i.removeDuplicates).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
 	return ws
 }
 
@@ -334,6 +352,89 @@ func (i FilesResource) removeFile(req *restful.Request, resp *restful.Response)
 	scene := removeFileByFileId(uint(fileId))
 	resp.WriteHeaderAndEntity(http.StatusOK, scene)
 }
+
+// setProjection overrides the detected projection of a video, an empty projection goes back to detection
+func (i FilesResource) setProjection(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.Atoi(req.PathParameter("file-id"))
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	var r RequestFileProjection
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var file models.File
+	if err := db.Preload("Volume").Where(&models.File{ID: uint(id)}).First(&file).Error; err != nil {
+		APIError(req, resp, http.StatusNotFound, err)
+		return
+	}
+	if file.Type != "video" {
+		APIError(req, resp, http.StatusBadRequest, errors.New("file is not a video"))
+		return
+	}
+
+	if r.Projection == "" {
+		err = tasks.ResetFileProjection(&file)
+	} else {
+		valid := false
+		for _, p := range models.VideoProjections {
+			if p == r.Projection {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			APIError(req, resp, http.StatusBadRequest, errors.New("unknown projection "+r.Projection))
+			return
+		}
+		err = db.Model(&file).Updates(map[string]interface{}{
+			"video_projection":      r.Projection,
+			"projection_confidence": 1,
+			"projection_source":     models.ProjectionSourceUser,
+		}).Error
+	}
+	if err != nil {
+		APIError(req, resp, http.StatusInternalServerError, err)
+		return
+	}
+
+	file.GetIfExistByPK(file.ID)
+	resp.WriteHeaderAndEntity(http.StatusOK, file)
+}
+
+func (i FilesResource) removeDuplicates(req *restful.Request, resp *restful.Response) {
+	var r RequestDeleteDuplicates
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := tasks.CheckDuplicateDeletion(r.FileIDs)
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+
+	var deleted []uint
+	var failed []uint
+	for _, id := range r.FileIDs {
+		removeFileByFileId(id)
+		var file models.File
+		if file.GetIfExistByPK(id) == nil {
+			failed = append(failed, id)
+		} else {
+			deleted = append(deleted, id)
+		}
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, map[string]interface{}{"deleted": deleted, "failed": failed})
+}
 func removeFileByFileId(fileId uint) models.Scene {
 
 	var scene models.Scene
@@ -354,6 +455,16 @@ func removeFileByFileId(fileId uint) models.Scene {
 			} else {
 				log.Errorf("error deleting file: %v", err)
 			}
+		case storage.TypeSFTP, storage.TypeWebDAV, storage.TypeS3:
+			backend, err := file.Volume.GetBackend()
+			if err == nil {
+				err = backend.Remove(context.Background(), file.GetPath())
+			}
+			if err == nil {
+				deleted = true
+			} else {
+				log.Errorf("error deleting file: %v", err)
+			}
 		case "putio":
 			id, err := strconv.ParseInt(file.Path, 10, 64)
 			if err != nil {
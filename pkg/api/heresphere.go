@@ -1,8 +1,12 @@
 package api
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -19,13 +23,13 @@ import (
 	"github.com/emicklei/go-restful/v3"
 	"github.com/markphelps/optional"
 	"github.com/tidwall/gjson"
-	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/text/language"
 	"golang.org/x/text/language/display"
 
 	"github.com/xbapps/xbvr/pkg/config"
 	"github.com/xbapps/xbvr/pkg/models"
 	"github.com/xbapps/xbvr/pkg/scrape"
+	"github.com/xbapps/xbvr/pkg/session"
 	"github.com/xbapps/xbvr/pkg/tasks"
 )
 
@@ -48,6 +52,8 @@ type HeresphereVideo struct {
 	DateReleased         string                         `json:"dateReleased"`
 	DateAdded            string                         `json:"dateAdded"`
 	DurationMilliseconds uint                           `json:"duration"`
+	ResumeMilliseconds   uint                           `json:"resumePosition,omitempty"`
+	EventServer          string                         `json:"eventServer,omitempty"`
 	Rating               float64                        `json:"rating,omitempty"`
 	IsFavorite           bool                           `json:"isFavorite"`
 	Projection           string                         `json:"projection"`
@@ -114,25 +120,33 @@ type HereSphereAuthRequest struct {
 	NeedsMediaSource optional.Bool    `json:"needsMediaSource"`
 }
 
+type HeresphereEvent struct {
+	ID            string  `json:"id"`
+	Title         string  `json:"title"`
+	Event         int     `json:"event"`
+	Time          float64 `json:"time"`
+	Speed         float64 `json:"speed"`
+	Utc           float64 `json:"utc"`
+	ConnectionKey string  `json:"connectionKey"`
+}
+
 var RequestBody []byte
 
 func HeresphereAuthFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
 	RequestBody, _ = io.ReadAll(req.Request.Body)
-	if isDeoAuthEnabled() {
-		authState := 0
-		var requestData HereSphereAuthRequest
-
-		if err := json.Unmarshal(RequestBody, &requestData); err == nil {
-			if requestData.Username != "" && requestData.Password != "" {
-				cmpErr := bcrypt.CompareHashAndPassword([]byte(config.Config.Interfaces.DeoVR.Password), []byte(requestData.Password))
-				if requestData.Username == config.Config.Interfaces.DeoVR.Username && cmpErr == nil {
-					authState = 1
-				} else {
-					authState = -1
-				}
-			}
+
+	authState := 0
+	var requestData HereSphereAuthRequest
+	if err := json.Unmarshal(RequestBody, &requestData); err == nil {
+		var user models.User
+		user, authState = playerUser(requestData.Username, requestData.Password)
+		if authState == 1 {
+			req.SetAttribute("user", user)
+			session.RememberUser(requestAddr(req), "heresphere", user.Username)
 		}
+	}
 
+	if isDeoAuthEnabled() {
 		if authState != 1 {
 			msg := "Login Required"
 			if authState == -1 {
@@ -193,9 +207,60 @@ func (i HeresphereResource) WebService() *restful.WebService {
 	ws.Route(ws.POST("file/{file-id}").Filter(HeresphereAuthFilter).To(i.getHeresphereFile).
 		Metadata(restfulspec.KeyOpenAPITags, tags).
 		Writes(DeoScene{}))
+
+	// HereSphere doesn't send credentials with events, the event server URL is signed for the user instead
+	ws.Route(ws.POST("/event/{file-id}").To(i.heresphereEvent).
+		Param(ws.PathParameter("file-id", "File ID").DataType("int")).
+		Param(ws.QueryParameter("user", "User ID").DataType("int")).
+		Param(ws.QueryParameter("sig", "Signature of the event server URL")).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(HeresphereEvent{}))
 	return ws
 }
 
+// heresphereEventServer is where HereSphere reports playback of a file, the scene's first video when it has several.
+// The URL is signed for the user that asked for the file, events are tracked for that user.
+func heresphereEventServer(req *restful.Request, fileID uint) string {
+	if fileID == 0 || !config.Config.Interfaces.DeoVR.TrackWatchTime {
+		return ""
+	}
+	user := requestUser(req)
+	return fmt.Sprintf("%v://%v/heresphere/event/%v?user=%v&sig=%v", getProto(req), req.Request.Host, fileID, user.ID, heresphereEventSignature(user, fileID))
+}
+
+// heresphereEventSignature ties an event server URL to a file and a user, it changes along with the user's token
+func heresphereEventSignature(user models.User, fileID uint) string {
+	mac := hmac.New(sha256.New, []byte(user.Token))
+	fmt.Fprintf(mac, "heresphere-event/%v/%v", user.ID, fileID)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (i HeresphereResource) heresphereEvent(req *restful.Request, resp *restful.Response) {
+	fileID, err := strconv.Atoi(req.PathParameter("file-id"))
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, _ := strconv.Atoi(req.QueryParameter("user"))
+	user, err := models.GetUserByID(uint(userID))
+	if err != nil || user.Token == "" || !hmac.Equal([]byte(req.QueryParameter("sig")), []byte(heresphereEventSignature(user, uint(fileID)))) {
+		APIError(req, resp, http.StatusUnauthorized, errors.New("invalid event server signature"))
+		return
+	}
+
+	var event HeresphereEvent
+	if err := req.ReadEntity(&event); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	c := session.Client{RemoteAddr: requestAddr(req), Player: "heresphere", User: user.Username}
+	session.TrackSessionFromHeresphere(c, uint(fileID), event.Event, event.Time/1000)
+
+	resp.WriteHeader(http.StatusOK)
+}
+
 func (i HeresphereResource) getHeresphereFile(req *restful.Request, resp *restful.Response) {
 	if !config.Config.Interfaces.DeoVR.Enabled {
 		return
@@ -222,6 +287,11 @@ func (i HeresphereResource) getHeresphereFile(req *restful.Request, resp *restfu
 	var file models.File
 	db.Where(&models.File{ID: uint(fileId)}).First(&file)
 
+	var resumePosition float64
+	if _, position, ok := models.ResumeFile(requestUser(req).ID, []models.File{file}); ok {
+		resumePosition = position
+	}
+
 	resolution := strconv.Itoa(file.VideoHeight)
 	height := file.VideoHeight
 	width := file.VideoWidth
@@ -251,6 +321,8 @@ func (i HeresphereResource) getHeresphereFile(req *restful.Request, resp *restfu
 		DateReleased:         file.CreatedTime.Format("2006-01-02"),
 		DateAdded:            file.CreatedTime.Format("2006-01-02"),
 		DurationMilliseconds: uint(file.VideoDuration * 1000),
+		ResumeMilliseconds:   uint(resumePosition * 1000),
+		EventServer:          heresphereEventServer(req, file.ID),
 		Media:                media,
 	}
 	if requestData.DeleteFiles != nil && config.Config.Interfaces.Heresphere.AllowFileDeletes {
@@ -310,11 +382,13 @@ func (i HeresphereResource) getHeresphereScene(req *restful.Request, resp *restf
 		return
 	}
 
+	userID := requestUser(req).ID
 	if len(videoFiles) == 0 {
-		ProcessHeresphereUpdates(&scene, requestData, models.File{})
+		ProcessHeresphereUpdates(&scene, requestData, models.File{}, userID)
 	} else {
-		ProcessHeresphereUpdates(&scene, requestData, videoFiles[0])
+		ProcessHeresphereUpdates(&scene, requestData, videoFiles[0], userID)
 	}
+	scene.ApplyUser(userID)
 
 	features := make(map[string]bool, 30)
 	addFeatureTag := func(feature string) {
@@ -629,6 +703,11 @@ func (i HeresphereResource) getHeresphereScene(req *restful.Request, resp *restf
 		hspUrl = fmt.Sprintf("%v://%v/api/dms/file/%v", getProto(req), req.Request.Host, hspFiles[0].ID)
 	}
 
+	var resumePosition float64
+	if _, position, ok := models.ResumeFile(requestUser(req).ID, videoFiles); ok {
+		resumePosition = position
+	}
+
 	var projection string = "equirectangular"
 	var stereo string = "sbs"
 	fov := 180.0
@@ -743,6 +822,8 @@ func (i HeresphereResource) getHeresphereScene(req *restful.Request, resp *restf
 		DateReleased:         scene.ReleaseDate.Format("2006-01-02"),
 		DateAdded:            scene.AddedDate.Format("2006-01-02"),
 		DurationMilliseconds: uint(videoLength * 1000),
+		ResumeMilliseconds:   uint(resumePosition * 1000),
+		EventServer:          heresphereEventServer(req, videoFiles[0].ID),
 		Rating:               scene.StarRating,
 		IsFavorite:           scene.Favourite,
 		Projection:           projection,
@@ -785,17 +866,16 @@ func copyVideoSourceResponse(sources models.VideoSourceResponse, media []Heresph
 
 var lockHeresphereUpdates sync.Mutex
 
-func ProcessHeresphereUpdates(scene *models.Scene, requestData HereSphereAuthRequest, videoFile models.File) {
+func ProcessHeresphereUpdates(scene *models.Scene, requestData HereSphereAuthRequest, videoFile models.File, userID uint) {
 	db, _ := models.GetDB()
 	defer db.Close()
 
-	if requestData.IsFavorite != nil && *requestData.IsFavorite != scene.Favourite && config.Config.Interfaces.Heresphere.AllowFavoriteUpdates {
-		scene.Favourite = *requestData.IsFavorite
-		scene.Save()
+	userState := models.GetUserScene(userID, scene.ID)
+	if requestData.IsFavorite != nil && *requestData.IsFavorite != userState.Favourite && config.Config.Interfaces.Heresphere.AllowFavoriteUpdates {
+		scene.SetUserState(userID, "favourite", *requestData.IsFavorite)
 	}
-	if requestData.Rating != nil && *requestData.Rating != scene.StarRating && config.Config.Interfaces.Heresphere.AllowRatingUpdates {
-		scene.StarRating = *requestData.Rating
-		scene.Save()
+	if requestData.Rating != nil && *requestData.Rating != userState.StarRating && config.Config.Interfaces.Heresphere.AllowRatingUpdates {
+		scene.SetUserState(userID, "star_rating", *requestData.Rating)
 	}
 
 	if requestData.Tags != nil && (config.Config.Interfaces.Heresphere.AllowTagUpdates || config.Config.Interfaces.Heresphere.AllowCuepointUpdates || config.Config.Interfaces.Heresphere.AllowWatchlistUpdates || config.Config.Web.SceneTrailerlist) {
@@ -838,9 +918,8 @@ func ProcessHeresphereUpdates(scene *models.Scene, requestData HereSphereAuthReq
 				trailerlist = true
 			}
 		}
-		if scene.Watchlist != watchlist && config.Config.Interfaces.Heresphere.AllowWatchlistUpdates {
-			scene.Watchlist = watchlist
-			scene.Save()
+		if userState.Watchlist != watchlist && config.Config.Interfaces.Heresphere.AllowWatchlistUpdates {
+			scene.SetUserState(userID, "watchlist", watchlist)
 		}
 		if scene.Trailerlist != trailerlist && config.Config.Web.SceneTrailerlist {
 			scene.Trailerlist = trailerlist
@@ -998,6 +1077,7 @@ func (i HeresphereResource) getHeresphereLibrary(req *restful.Request, resp *res
 		if err := json.Unmarshal([]byte(savedPlaylists[i].SearchParams), &r); err == nil {
 			r.IsAccessible = optional.NewBool(true)
 			r.IsAvailable = optional.NewBool(true)
+			r.UserID = requestUser(req).ID
 
 			list := models.QuerySceneIDs(r)
 
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func TestHeresphereEventSignature(t *testing.T) {
+	alice := models.User{ID: 2, Token: "alice-token"}
+	sig := heresphereEventSignature(alice, 3)
+
+	if heresphereEventSignature(alice, 3) != sig {
+		t.Fatal("expected the signature to be stable")
+	}
+	if heresphereEventSignature(alice, 4) == sig {
+		t.Fatal("expected the signature of another file to differ")
+	}
+	if heresphereEventSignature(models.User{ID: 5, Token: "alice-token"}, 3) == sig {
+		t.Fatal("expected the signature of another user to differ")
+	}
+	if heresphereEventSignature(models.User{ID: 2, Token: "new-token"}, 3) == sig {
+		t.Fatal("expected a new token to invalidate the signature")
+	}
+}
@@ -28,13 +28,32 @@ import (
 	"github.com/xbapps/xbvr/pkg/config"
 	"github.com/xbapps/xbvr/pkg/models"
 	"github.com/xbapps/xbvr/pkg/scrape"
+	"github.com/xbapps/xbvr/pkg/storage"
 	"github.com/xbapps/xbvr/pkg/tasks"
 )
 
 type NewVolumeRequest struct {
-	Type  string `json:"type"`
-	Path  string `json:"path"`
-	Token string `json:"token"`
+	Type            string                 `json:"type"`
+	Path            string                 `json:"path"`
+	Token           string                 `json:"token"`
+	ScanConcurrency int                    `json:"scan_concurrency"`
+	ScanRules       models.VolumeScanRules `json:"scan_rules"`
+	ContentType     string                 `json:"content_type"`
+	Remote          storage.Config         `json:"remote"`
+}
+
+type RequestUpdateVolume struct {
+	ScanConcurrency *int                    `json:"scan_concurrency"`
+	ScanRules       *models.VolumeScanRules `json:"scan_rules"`
+	ContentType     *string                 `json:"content_type"`
+}
+
+func validVolumeContentType(contentType string) bool {
+	switch contentType {
+	case "", models.VolumeContentVR, models.VolumeContentPMV, models.VolumeContentFlat:
+		return true
+	}
+	return false
 }
 
 type VersionCheckResponse struct {
@@ -90,6 +109,7 @@ type RequestSaveOptionsDLNA struct {
 	ServiceName  string   `json:"name"`
 	ServiceImage string   `json:"image"`
 	AllowedIP    []string `json:"allowedIp"`
+	User         string   `json:"user"`
 }
 
 type RequestSaveOptionsDeoVR struct {
@@ -213,15 +233,36 @@ type RequestSCustomSiteCreate struct {
 }
 
 type GetStorageResponse struct {
-	Volumes           []models.Volume `json:"volumes"`
-	MatchOhash        bool            `json:"match_ohash"`
-	VideoExt          []string        `json:"video_ext"`
-	ForbiddenVideoExt []string        `json:"forbidden_video_ext"`
-	DefaultVideoExt   []string        `json:"default_video_ext"`
+	Volumes               []models.Volume `json:"volumes"`
+	MatchOhash            bool            `json:"match_ohash"`
+	WatchVolumes          bool            `json:"watch_volumes"`
+	WatchDebounce         int             `json:"watch_debounce"`
+	ScanConcurrency       int             `json:"scan_concurrency"`
+	AllowDuplicateDeletes bool            `json:"allow_duplicate_deletes"`
+	AnalyseProjection     bool            `json:"analyse_projection"`
+	VideoExt              []string        `json:"video_ext"`
+	ForbiddenVideoExt     []string        `json:"forbidden_video_ext"`
+	DefaultVideoExt       []string        `json:"default_video_ext"`
 }
 type RequestSaveOptionsStorage struct {
-	MatchOhash bool     `json:"match_ohash"`
-	VideoExt   []string `json:"video_ext"`
+	MatchOhash            bool     `json:"match_ohash"`
+	WatchVolumes          *bool    `json:"watch_volumes"`
+	WatchDebounce         int      `json:"watch_debounce"`
+	ScanConcurrency       int      `json:"scan_concurrency"`
+	AllowDuplicateDeletes *bool    `json:"allow_duplicate_deletes"`
+	AnalyseProjection     *bool    `json:"analyse_projection"`
+	VideoExt              []string `json:"video_ext"`
+}
+
+type RequestSaveOptionsPMVMatch struct {
+	VisualMatching    *bool     `json:"visualMatching"`
+	KeyframeCount     *int      `json:"keyframeCount"`
+	VisualWeight      *float64  `json:"visualWeight"`
+	AudioMatching     *bool     `json:"audioMatching"`
+	AudioWeight       *float64  `json:"audioWeight"`
+	MinConfidence     *float64  `json:"minConfidence"`
+	MinMargin         *float64  `json:"minMargin"`
+	DisabledProviders *[]string `json:"disabledProviders"`
 }
 
 type RequestSaveCollectorConfig struct {
@@ -290,9 +331,17 @@ func (i ConfigResource) WebService() *restful.WebService {
 		Param(ws.PathParameter("storage-id", "Storage ID").DataType("int")).
 		Metadata(restfulspec.KeyOpenAPITags, tags))
 
+	ws.Route(ws.PUT("/storage/{storage-id}").To(i.updateStorage).
+		Param(ws.PathParameter("storage-id", "Storage ID").DataType("int")).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
 	ws.Route(ws.PUT("/storage").To(i.saveOptionsStorage).
 		Metadata(restfulspec.KeyOpenAPITags, tags))
 
+	// "PMV matching" section endpoints
+	ws.Route(ws.PUT("/pmv-match").To(i.saveOptionsPMVMatch).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
 	// "DLNA" section endpoints
 	ws.Route(ws.PUT("/interface/dlna").To(i.saveOptionsDLNA).
 		Metadata(restfulspec.KeyOpenAPITags, tags))
@@ -607,15 +656,23 @@ func (i ConfigResource) listStorage(req *restful.Request, resp *restful.Response
 	defer db.Close()
 
 	var vol []models.Volume
-	db.Raw(`select id, path, last_scan,is_available, is_enabled, type,
+	db.Raw(`select id, path, last_scan,is_available, is_enabled, type, scan_concurrency, scan_rules, content_type, identity, offline_since,
        	(select count(*) from files where files.volume_id = volumes.id) as file_count,
 		(select count(*) from files where files.volume_id = volumes.id and files.scene_id = 0) as unmatched_count,
        	(select sum(files.size) from files where files.volume_id = volumes.id) as total_size
 		from volumes order by last_scan desc;`).Scan(&vol)
+	for i := range vol {
+		vol[i].Rules = vol[i].GetScanRules()
+	}
 
 	var out GetStorageResponse
 	out.Volumes = vol
 	out.MatchOhash = config.Config.Storage.MatchOhash
+	out.WatchVolumes = config.Config.Storage.WatchVolumes
+	out.WatchDebounce = config.Config.Storage.WatchDebounce
+	out.ScanConcurrency = config.Config.Storage.ScanConcurrency
+	out.AllowDuplicateDeletes = config.Config.Storage.AllowDuplicateDeletes
+	out.AnalyseProjection = config.Config.Storage.AnalyseProjection
 
 	// Fallback to default video extensions if none are set
 	if len(config.Config.Storage.VideoExt) == 0 {
@@ -637,6 +694,14 @@ func (i ConfigResource) addStorage(req *restful.Request, resp *restful.Response)
 		APIError(req, resp, http.StatusInternalServerError, err)
 		return
 	}
+	if !validVolumeContentType(r.ContentType) {
+		APIError(req, resp, 400, fmt.Errorf("unknown content type %v", r.ContentType))
+		return
+	}
+	if err := tasks.CheckScanRules(r.ScanRules); err != nil {
+		APIError(req, resp, 400, err)
+		return
+	}
 
 	db, _ := models.GetDB()
 	defer db.Close()
@@ -660,10 +725,13 @@ func (i ConfigResource) addStorage(req *restful.Request, resp *restful.Response)
 			return
 		}
 
-		nv := models.Volume{Path: path, IsEnabled: true, IsAvailable: true, Type: r.Type}
+		nv := models.Volume{Path: path, IsEnabled: true, IsAvailable: true, Type: r.Type, ScanConcurrency: r.ScanConcurrency, ContentType: r.ContentType}
+		nv.SetScanRules(r.ScanRules)
 		nv.Save()
+		nv.EnsureIdentity()
 
 		tlog.Info("Added new storage folder ", path)
+		go tasks.RefreshVolumeWatchers()
 
 	case "putio":
 		tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: r.Token})
@@ -686,10 +754,53 @@ func (i ConfigResource) addStorage(req *restful.Request, resp *restful.Response)
 			return
 		}
 
-		nv := models.Volume{Path: "Put.io (" + acct.Username + ")", IsEnabled: true, IsAvailable: true, Metadata: r.Token, Type: r.Type}
+		nv := models.Volume{Path: "Put.io (" + acct.Username + ")", IsEnabled: true, IsAvailable: true, Metadata: r.Token, Type: r.Type, ContentType: r.ContentType}
 		nv.Save()
 
 		tlog.Info("Added new cloud storage ", nv.Path)
+
+	case storage.TypeSFTP, storage.TypeWebDAV, storage.TypeS3:
+		if r.Type == storage.TypeSFTP && r.Remote.HostKey == "" {
+			// Trust the key seen now and refuse any other one later
+			hostKey, err := storage.ScanSFTPHostKey(r.Remote)
+			if err != nil {
+				tlog.Errorf("Can't reach remote storage: %v", err)
+				APIError(req, resp, 400, fmt.Errorf("Can't reach remote storage: %v", err))
+				return
+			}
+			r.Remote.HostKey = hostKey
+			tlog.Infof("Pinned host key %v", hostKey)
+		}
+		backend, err := storage.New(r.Type, r.Remote)
+		if err != nil {
+			APIError(req, resp, 400, err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		_, err = backend.Stat(ctx, storage.RootPath(r.Remote))
+		cancel()
+		backend.Close()
+		if err != nil {
+			tlog.Errorf("Can't reach remote storage: %v", err)
+			APIError(req, resp, 400, fmt.Errorf("Can't reach remote storage: %v", err))
+			return
+		}
+
+		name := storage.DisplayName(r.Type, r.Remote)
+		var vol []models.Volume
+		db.Where(&models.Volume{Path: name, Type: r.Type}).Find(&vol)
+
+		if len(vol) > 0 {
+			tlog.Error("Remote storage already exists")
+			APIError(req, resp, 400, errors.New("Remote storage already exists"))
+			return
+		}
+
+		nv := models.Volume{Path: name, IsEnabled: true, IsAvailable: true, Metadata: r.Remote.JSON(), Type: r.Type, ScanConcurrency: r.ScanConcurrency, ContentType: r.ContentType}
+		nv.SetScanRules(r.ScanRules)
+		nv.Save()
+
+		tlog.Info("Added new remote storage ", nv.Path)
 	}
 
 	// Inform UI about state change
@@ -698,6 +809,61 @@ func (i ConfigResource) addStorage(req *restful.Request, resp *restful.Response)
 	resp.WriteHeader(http.StatusOK)
 }
 
+func (i ConfigResource) updateStorage(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.Atoi(req.PathParameter("storage-id"))
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var r RequestUpdateVolume
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+	if r.ScanConcurrency != nil && *r.ScanConcurrency < 0 {
+		APIError(req, resp, http.StatusBadRequest, errors.New("scan concurrency can't be negative"))
+		return
+	}
+	if r.ContentType != nil && !validVolumeContentType(*r.ContentType) {
+		APIError(req, resp, http.StatusBadRequest, fmt.Errorf("unknown content type %v", *r.ContentType))
+		return
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	vol := models.Volume{}
+	if err := db.First(&vol, id).Error; err == gorm.ErrRecordNotFound {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.ScanConcurrency != nil {
+		vol.ScanConcurrency = *r.ScanConcurrency
+	}
+	if r.ContentType != nil {
+		vol.ContentType = *r.ContentType
+	}
+	if r.ScanRules != nil {
+		if err := tasks.CheckScanRules(*r.ScanRules); err != nil {
+			APIError(req, resp, http.StatusBadRequest, err)
+			return
+		}
+		if err := vol.SetScanRules(*r.ScanRules); err != nil {
+			APIError(req, resp, http.StatusBadRequest, err)
+			return
+		}
+	}
+	vol.Save()
+	vol.Rules = vol.GetScanRules()
+
+	// Inform UI about state change
+	common.PublishWS("state.change.optionsStorage", nil)
+
+	resp.WriteHeaderAndEntity(http.StatusOK, vol)
+}
+
 func (i ConfigResource) removeStorage(req *restful.Request, resp *restful.Response) {
 	id, err := strconv.Atoi(req.PathParameter("storage-id"))
 	if err != nil {
@@ -718,6 +884,7 @@ func (i ConfigResource) removeStorage(req *restful.Request, resp *restful.Respon
 
 	db.Where("volume_id = ?", id).Delete(models.File{})
 	db.Delete(&vol)
+	models.CloseVolumeBackend(vol.ID)
 
 	// Inform UI about state change
 	common.PublishWS("state.change.optionsStorage", nil)
@@ -862,6 +1029,7 @@ func (i ConfigResource) saveOptionsDLNA(req *restful.Request, resp *restful.Resp
 	config.Config.Interfaces.DLNA.ServiceName = r.ServiceName
 	config.Config.Interfaces.DLNA.ServiceImage = r.ServiceImage
 	config.Config.Interfaces.DLNA.AllowedIP = r.AllowedIP
+	config.Config.Interfaces.DLNA.User = r.User
 	config.SaveConfig()
 
 	if tasks.IsDMSStarted() {
@@ -1099,6 +1267,7 @@ func (i ConfigResource) createCustomSite(req *restful.Request, resp *restful.Res
 	scrapers["vrphub"] = scraperConfig.CustomScrapers.VrphubScrapers
 	scrapers["vrporn"] = scraperConfig.CustomScrapers.VrpornScrapers
 	scrapers["realvr"] = scraperConfig.CustomScrapers.RealVRScrapers
+	scrapers["pmvhaven"] = scraperConfig.CustomScrapers.PmvhavenScrapers
 
 	exists := false
 	for key, group := range scrapers {
@@ -1128,6 +1297,8 @@ func (i ConfigResource) createCustomSite(req *restful.Request, resp *restful.Res
 			scrapers["vrporn"] = append(scrapers["vrporn"], scraper)
 		case "realvr":
 			scrapers["realvr"] = append(scrapers["realvr"], scraper)
+		case "pmvhaven":
+			scrapers["pmvhaven"] = append(scrapers["pmvhaven"], scraper)
 		}
 	}
 	scraperConfig.CustomScrapers.PovrScrapers = scrapers["povr"]
@@ -1136,6 +1307,7 @@ func (i ConfigResource) createCustomSite(req *restful.Request, resp *restful.Res
 	scraperConfig.CustomScrapers.VrphubScrapers = scrapers["vrphub"]
 	scraperConfig.CustomScrapers.VrpornScrapers = scrapers["vrporn"]
 	scraperConfig.CustomScrapers.RealVRScrapers = scrapers["realvr"]
+	scraperConfig.CustomScrapers.PmvhavenScrapers = scrapers["pmvhaven"]
 	fName := filepath.Join(common.AppDir, "scrapers.json")
 	list, _ := json.MarshalIndent(scraperConfig, "", "  ")
 	os.WriteFile(fName, list, 0644)
@@ -1151,6 +1323,21 @@ func (i ConfigResource) saveOptionsStorage(req *restful.Request, resp *restful.R
 	}
 
 	config.Config.Storage.MatchOhash = r.MatchOhash
+	if r.WatchVolumes != nil {
+		config.Config.Storage.WatchVolumes = *r.WatchVolumes
+	}
+	if r.WatchDebounce > 0 {
+		config.Config.Storage.WatchDebounce = r.WatchDebounce
+	}
+	if r.ScanConcurrency > 0 {
+		config.Config.Storage.ScanConcurrency = r.ScanConcurrency
+	}
+	if r.AllowDuplicateDeletes != nil {
+		config.Config.Storage.AllowDuplicateDeletes = *r.AllowDuplicateDeletes
+	}
+	if r.AnalyseProjection != nil {
+		config.Config.Storage.AnalyseProjection = *r.AnalyseProjection
+	}
 
 	// Filter, normalize, and deduplicate extensions
 	var allowedExt []string
@@ -1189,9 +1376,56 @@ func (i ConfigResource) saveOptionsStorage(req *restful.Request, resp *restful.R
 	config.Config.Storage.VideoExt = allowedExt
 	config.SaveConfig()
 
+	go tasks.RefreshVolumeWatchers()
+
 	resp.WriteHeaderAndEntity(http.StatusOK, r)
 }
 
+func (i ConfigResource) saveOptionsPMVMatch(req *restful.Request, resp *restful.Response) {
+	var r RequestSaveOptionsPMVMatch
+	err := req.ReadEntity(&r)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	// Only the options that were sent are changed, weights and thresholds out of range are ignored
+	if r.VisualMatching != nil {
+		config.Config.PMVMatch.VisualMatching = *r.VisualMatching
+	}
+	if r.KeyframeCount != nil {
+		count := *r.KeyframeCount
+		if count < 1 {
+			count = 1
+		}
+		if count > 32 {
+			count = 32
+		}
+		config.Config.PMVMatch.KeyframeCount = count
+	}
+	if r.VisualWeight != nil && *r.VisualWeight >= 0 && *r.VisualWeight <= 1 {
+		config.Config.PMVMatch.VisualWeight = *r.VisualWeight
+	}
+	if r.AudioMatching != nil {
+		config.Config.PMVMatch.AudioMatching = *r.AudioMatching
+	}
+	if r.AudioWeight != nil && *r.AudioWeight >= 0 && *r.AudioWeight <= 1 {
+		config.Config.PMVMatch.AudioWeight = *r.AudioWeight
+	}
+	if r.MinConfidence != nil && *r.MinConfidence >= 0 && *r.MinConfidence <= 1 {
+		config.Config.PMVMatch.MinConfidence = *r.MinConfidence
+	}
+	if r.MinMargin != nil && *r.MinMargin >= 0 && *r.MinMargin <= 1 {
+		config.Config.PMVMatch.MinMargin = *r.MinMargin
+	}
+	if r.DisabledProviders != nil {
+		config.Config.PMVMatch.DisabledProviders = *r.DisabledProviders
+	}
+	config.SaveConfig()
+
+	resp.WriteHeaderAndEntity(http.StatusOK, config.Config.PMVMatch)
+}
+
 func (i ConfigResource) getCollectorConfigs(req *restful.Request, resp *restful.Response) {
 	list := scrape.GetAllScrapeHttpConfigs()
 	resp.WriteHeaderAndEntity(http.StatusOK, &list)
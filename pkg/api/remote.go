@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/xbapps/xbvr/pkg/session"
+)
+
+type RequestRemotePlay struct {
+	SceneID uint `json:"scene_id"`
+	FileID  uint `json:"file_id"`
+}
+
+type RequestRemoteSeek struct {
+	Position float64 `json:"position"`
+}
+
+type RequestRemotePlaylist struct {
+	PlaylistID uint   `json:"playlist_id"`
+	SceneIDs   []uint `json:"scene_ids"`
+}
+
+type ResponseRemoteCommand struct {
+	Queued int `json:"queued"`
+}
+
+type RemoteResource struct{}
+
+func (i RemoteResource) WebService() *restful.WebService {
+	tags := []string{"Remote"}
+
+	ws := new(restful.WebService)
+
+	ws.Path("/api/remote").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.POST("/play").To(i.play).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(RequestRemotePlay{}).
+		Writes(ResponseRemoteCommand{}))
+
+	ws.Route(ws.POST("/seek").To(i.seek).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(RequestRemoteSeek{}).
+		Writes(ResponseRemoteCommand{}))
+
+	ws.Route(ws.POST("/pause").To(i.pause).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(ResponseRemoteCommand{}))
+
+	ws.Route(ws.POST("/resume").To(i.resume).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(ResponseRemoteCommand{}))
+
+	ws.Route(ws.POST("/playlist").To(i.playlist).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(RequestRemotePlaylist{}).
+		Writes(ResponseRemoteCommand{}))
+
+	return ws
+}
+
+func writeRemoteResult(req *restful.Request, resp *restful.Response, queued int, statusCode int, err error) {
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, ResponseRemoteCommand{Queued: queued})
+}
+
+func (i RemoteResource) play(req *restful.Request, resp *restful.Response) {
+	var r RequestRemotePlay
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	var statusCode int
+	var err error
+	if r.FileID != 0 {
+		statusCode, err = session.RemotePlayFile(r.FileID)
+	} else {
+		statusCode, err = session.RemotePlayScene(r.SceneID)
+	}
+	writeRemoteResult(req, resp, 1, statusCode, err)
+}
+
+func (i RemoteResource) seek(req *restful.Request, resp *restful.Response) {
+	var r RequestRemoteSeek
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := session.RemoteSeek(r.Position)
+	writeRemoteResult(req, resp, 1, statusCode, err)
+}
+
+func (i RemoteResource) pause(req *restful.Request, resp *restful.Response) {
+	statusCode, err := session.RemotePause()
+	writeRemoteResult(req, resp, 1, statusCode, err)
+}
+
+func (i RemoteResource) resume(req *restful.Request, resp *restful.Response) {
+	statusCode, err := session.RemoteResume()
+	writeRemoteResult(req, resp, 1, statusCode, err)
+}
+
+func (i RemoteResource) playlist(req *restful.Request, resp *restful.Response) {
+	var r RequestRemotePlaylist
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	queued, statusCode, err := session.RemotePlayPlaylist(r.PlaylistID, r.SceneIDs)
+	writeRemoteResult(req, resp, queued, statusCode, err)
+}
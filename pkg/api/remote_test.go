@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+)
+
+func TestRemoteCommandStatus(t *testing.T) {
+	container := restful.NewContainer()
+	container.Add(RemoteResource{}.WebService())
+
+	cases := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/api/remote/seek", `{"position": -1}`, http.StatusBadRequest},
+		{"/api/remote/seek", `not json`, http.StatusBadRequest},
+		// Nothing is queued while DeoVR is not connected
+		{"/api/remote/seek", `{"position": 30}`, http.StatusServiceUnavailable},
+		{"/api/remote/pause", ``, http.StatusServiceUnavailable},
+		{"/api/remote/resume", ``, http.StatusServiceUnavailable},
+		{"/api/remote/playlist", `{"scene_ids": []}`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", restful.MIME_JSON)
+		rec := httptest.NewRecorder()
+		container.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Fatalf("%s %s: expected %d, got %d %s", c.path, c.body, c.status, rec.Code, rec.Body.String())
+		}
+	}
+}
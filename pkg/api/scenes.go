@@ -487,6 +487,7 @@ func (i SceneResource) getScene(req *restful.Request, resp *restful.Response) {
 	}
 	db.Close()
 
+	scene.ApplyUser(requestUser(req).ID)
 	resp.WriteHeaderAndEntity(http.StatusOK, scene)
 }
 
@@ -498,7 +499,10 @@ func (i SceneResource) getScenes(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
+	user := requestUser(req)
+	r.UserID = user.ID
 	out := models.QueryScenes(r, true)
+	models.ApplyUserToScenes(user.ID, out.Scenes)
 	resp.WriteHeaderAndEntity(http.StatusOK, out)
 }
 
@@ -524,26 +528,26 @@ func (i SceneResource) toggleList(req *restful.Request, resp *restful.Response)
 		return
 	}
 
-	if r.List == "watchlist" {
-		scene.Watchlist = !scene.Watchlist
+	// Lists of the user are kept apart from the scene
+	userColumns := map[string]string{"watchlist": "watchlist", "favourite": "favourite", "watched": "is_watched"}
+	if column, ok := userColumns[r.List]; ok {
+		userID := requestUser(req).ID
+		us := models.GetUserScene(userID, scene.ID)
+		current := map[string]bool{"watchlist": us.Watchlist, "favourite": us.Favourite, "is_watched": us.IsWatched}
+		if err := scene.SetUserState(userID, column, !current[column]); err != nil {
+			log.Error(err)
+		}
+		return
 	}
 
 	if r.List == "trailerlist" {
 		scene.Trailerlist = !scene.Trailerlist
 	}
 
-	if r.List == "favourite" {
-		scene.Favourite = !scene.Favourite
-	}
-
 	if r.List == "needs_update" {
 		scene.NeedsUpdate = !scene.NeedsUpdate
 	}
 
-	if r.List == "watched" {
-		scene.IsWatched = !scene.IsWatched
-	}
-
 	if r.List == "is_hidden" {
 		scene.IsHidden = !scene.IsHidden
 	}
@@ -794,14 +798,15 @@ func (i SceneResource) rateScene(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
+	user := requestUser(req)
 	var scene models.Scene
-	db, _ := models.GetDB()
 	err = scene.GetIfExistByPK(uint(sceneId))
 	if err == nil {
-		scene.StarRating = r.Rating
-		scene.Save()
+		if err := scene.SetUserState(user.ID, "star_rating", r.Rating); err != nil {
+			log.Error(err)
+		}
+		scene.ApplyUser(user.ID)
 	}
-	db.Close()
 
 	resp.WriteHeaderAndEntity(http.StatusOK, scene)
 }
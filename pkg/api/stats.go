@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/tasks"
+)
+
+type ResponseMetric struct {
+	Name   string               `json:"name"`
+	From   time.Time            `json:"from"`
+	Until  time.Time            `json:"until"`
+	Step   int                  `json:"step"`
+	Points []common.MetricPoint `json:"points"`
+}
+
+type StatsResource struct{}
+
+func (i StatsResource) WebService() *restful.WebService {
+	tags := []string{"Stats"}
+
+	ws := new(restful.WebService)
+
+	ws.Path("/api/stats").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("/storage").To(i.storageStats).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(tasks.StorageStats{}))
+
+	ws.Route(ws.GET("/metrics").To(i.listMetrics).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes([]string{}))
+
+	ws.Route(ws.GET("/metrics/{metric-name}").To(i.getMetric).
+		Param(ws.PathParameter("metric-name", "Metric name, e.g. local_files_size").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(ResponseMetric{}))
+
+	return ws
+}
+
+func (i StatsResource) storageStats(req *restful.Request, resp *restful.Response) {
+	limit, _ := strconv.Atoi(req.QueryParameter("limit"))
+	stats, statusCode, err := tasks.GetStorageStats(limit)
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, stats)
+}
+
+func (i StatsResource) listMetrics(req *restful.Request, resp *restful.Response) {
+	resp.WriteHeaderAndEntity(http.StatusOK, common.ListMetrics())
+}
+
+func (i StatsResource) getMetric(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("metric-name")
+	from, until, err := parseMetricRange(req.QueryParameter("range"), req.QueryParameter("from"), req.QueryParameter("until"), time.Now())
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	points, step, err := common.FetchMetric(name, from, until)
+	if err != nil {
+		APIError(req, resp, http.StatusNotFound, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, ResponseMetric{Name: name, From: from, Until: until, Step: step, Points: points})
+}
+
+// parseMetricRange defaults to the last 30 days, range takes hours, days, weeks or years and until defaults to now
+func parseMetricRange(rangeParam string, fromParam string, untilParam string, now time.Time) (time.Time, time.Time, error) {
+	until := now
+	if untilParam != "" {
+		t, err := parseMetricTime(untilParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		until = t
+	}
+
+	from := until.AddDate(0, 0, -30)
+	switch {
+	case fromParam != "":
+		t, err := parseMetricTime(fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		from = t
+	case rangeParam != "":
+		n, err := strconv.Atoi(rangeParam[:len(rangeParam)-1])
+		if err != nil || n <= 0 {
+			return time.Time{}, time.Time{}, errors.New("invalid range " + rangeParam)
+		}
+		switch strings.ToLower(rangeParam[len(rangeParam)-1:]) {
+		case "h":
+			from = until.Add(-time.Duration(n) * time.Hour)
+		case "d":
+			from = until.AddDate(0, 0, -n)
+		case "w":
+			from = until.AddDate(0, 0, -7*n)
+		case "y":
+			from = until.AddDate(-n, 0, 0)
+		default:
+			return time.Time{}, time.Time{}, errors.New("invalid range " + rangeParam)
+		}
+	}
+
+	if !from.Before(until) {
+		return time.Time{}, time.Time{}, errors.New("from has to be before until")
+	}
+	return from, until, nil
+}
+
+func parseMetricTime(s string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -50,6 +51,25 @@ type RequestPMVMatchBatch struct {
 	PathPrefix  string `json:"path_prefix"`
 }
 
+type RequestPMVReviewAccept struct {
+	Rank int `json:"rank"`
+}
+
+type RequestPMVAudioSong struct {
+	FileID uint    `json:"file_id"`
+	Artist string  `json:"artist"`
+	Title  string  `json:"title"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+}
+
+type RequestPMVTokenWeight struct {
+	VolumeID   uint    `json:"volume_id"`
+	PathPrefix string  `json:"path_prefix"`
+	Token      string  `json:"token"`
+	Weight     float64 `json:"weight"`
+}
+
 type ResponseBackupBundle struct {
 	Response string `json:"status"`
 }
@@ -128,6 +148,70 @@ func (i TaskResource) WebService() *restful.WebService {
 	ws.Route(ws.GET("/pmv-match-unmatched").To(i.pmvMatchUnmatchedTask).
 		Metadata(restfulspec.KeyOpenAPITags, tags))
 
+	ws.Route(ws.POST("/pmv-match-unmatched/cancel").To(i.pmvMatchUnmatchedCancel).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.GET("/pmv-match-report").To(i.pmvMatchReport).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(tasks.PMVMatchBatchResult{}))
+
+	ws.Route(ws.GET("/pmv-review").To(i.pmvReviewList).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes([]tasks.PMVReviewQueueItem{}))
+
+	ws.Route(ws.POST("/pmv-review/{review-id}/accept").To(i.pmvReviewAccept).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(tasks.PMVMatchResult{}))
+
+	ws.Route(ws.POST("/pmv-review/{review-id}/reject").To(i.pmvReviewReject).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.POST("/pmv-review/{review-id}/requery").To(i.pmvReviewRequery).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(tasks.PMVMatchResult{}))
+
+	ws.Route(ws.GET("/projection-analysis").To(i.projectionAnalysis).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.GET("/duplicates").To(i.duplicates).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.GET("/duplicates-report").To(i.duplicatesReport).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(tasks.DuplicateReport{}))
+
+	ws.Route(ws.GET("/doctor").To(i.doctor).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.GET("/doctor-report").To(i.doctorReport).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(tasks.DoctorReport{}))
+
+	ws.Route(ws.GET("/pmv-audio").To(i.pmvAudioList).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes([]models.AudioFingerprint{}))
+
+	ws.Route(ws.POST("/pmv-audio/songs").To(i.pmvAudioAddSong).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(models.AudioFingerprint{}))
+
+	ws.Route(ws.POST("/pmv-audio/populate").To(i.pmvAudioPopulate).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.DELETE("/pmv-audio/{fingerprint-id}").To(i.pmvAudioDelete).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	ws.Route(ws.GET("/pmv-tokens").To(i.pmvTokenList).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes([]models.PMVTokenWeight{}))
+
+	ws.Route(ws.PUT("/pmv-tokens").To(i.pmvTokenSave).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(models.PMVTokenWeight{}))
+
+	ws.Route(ws.DELETE("/pmv-tokens/{token-id}").To(i.pmvTokenDelete).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
 	return ws
 }
 
@@ -311,3 +395,219 @@ func (i TaskResource) pmvMatchUnmatchedTask(req *restful.Request, resp *restful.
 		PathPrefix:  pathPrefix,
 	})
 }
+
+func (i TaskResource) pmvMatchUnmatchedCancel(req *restful.Request, resp *restful.Response) {
+	cancelled := tasks.CancelPMVMatchBatch()
+	resp.WriteHeaderAndEntity(http.StatusOK, map[string]interface{}{"cancelled_runs": cancelled})
+}
+
+func (i TaskResource) pmvMatchReport(req *restful.Request, resp *restful.Response) {
+	report, statusCode, err := tasks.GetLastPMVBatchReport()
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, report)
+}
+
+func (i TaskResource) pmvReviewList(req *restful.Request, resp *restful.Response) {
+	items, statusCode, err := tasks.ListPMVReviewQueue(strings.TrimSpace(req.QueryParameter("status")))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, items)
+}
+
+func (i TaskResource) pmvReviewAccept(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.ParseUint(req.PathParameter("review-id"), 10, 64)
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	// Without a body the top candidate is accepted
+	var r RequestPMVReviewAccept
+	if req.Request.ContentLength != 0 {
+		if err := req.ReadEntity(&r); err != nil && err != io.EOF {
+			APIError(req, resp, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if r.Rank == 0 {
+		r.Rank = 1
+	}
+
+	result, statusCode, err := tasks.AcceptPMVReviewItem(uint(id), r.Rank)
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, result)
+}
+
+func (i TaskResource) pmvReviewReject(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.ParseUint(req.PathParameter("review-id"), 10, 64)
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := tasks.RejectPMVReviewItem(uint(id))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeader(statusCode)
+}
+
+func (i TaskResource) pmvReviewRequery(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.ParseUint(req.PathParameter("review-id"), 10, 64)
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	result, statusCode, err := tasks.RequeryPMVReviewItem(uint(id))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, result)
+}
+
+func (i TaskResource) projectionAnalysis(req *restful.Request, resp *restful.Response) {
+	go tasks.AnalyseProjections()
+}
+
+func (i TaskResource) duplicates(req *restful.Request, resp *restful.Response) {
+	go tasks.DuplicateDetection()
+}
+
+func (i TaskResource) duplicatesReport(req *restful.Request, resp *restful.Response) {
+	report, statusCode, err := tasks.GetLastDuplicateReport()
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, report)
+}
+
+func (i TaskResource) doctor(req *restful.Request, resp *restful.Response) {
+	var repair []string
+	for _, c := range strings.Split(req.QueryParameter("repair"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			repair = append(repair, c)
+		}
+	}
+	go tasks.LibraryDoctor(repair)
+}
+
+func (i TaskResource) doctorReport(req *restful.Request, resp *restful.Response) {
+	report, statusCode, err := tasks.GetLastDoctorReport()
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, report)
+}
+
+func (i TaskResource) pmvAudioList(req *restful.Request, resp *restful.Response) {
+	entries, statusCode, err := tasks.ListPMVAudioFingerprints(strings.TrimSpace(req.QueryParameter("kind")))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, entries)
+}
+
+func (i TaskResource) pmvAudioAddSong(req *restful.Request, resp *restful.Response) {
+	var r RequestPMVAudioSong
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	entry, statusCode, err := tasks.AddPMVAudioSong(tasks.PMVAudioSongRequest{
+		FileID: r.FileID,
+		Artist: r.Artist,
+		Title:  r.Title,
+		Start:  r.Start,
+		End:    r.End,
+	})
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, entry)
+}
+
+func (i TaskResource) pmvAudioPopulate(req *restful.Request, resp *restful.Response) {
+	limit, _ := strconv.Atoi(req.QueryParameter("limit"))
+
+	if err := tasks.StartPMVAudioFingerprints(limit); err != nil {
+		APIError(req, resp, http.StatusConflict, err)
+		return
+	}
+}
+
+func (i TaskResource) pmvAudioDelete(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.ParseUint(req.PathParameter("fingerprint-id"), 10, 64)
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := tasks.DeletePMVAudioFingerprint(uint(id))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeader(statusCode)
+}
+
+func (i TaskResource) pmvTokenList(req *restful.Request, resp *restful.Response) {
+	volumeID64, _ := strconv.ParseUint(req.QueryParameter("volume_id"), 10, 64)
+
+	rows, statusCode, err := tasks.ListPMVTokenWeights(uint(volumeID64), req.QueryParameter("path_prefix"), strings.TrimSpace(req.QueryParameter("token")))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, rows)
+}
+
+func (i TaskResource) pmvTokenSave(req *restful.Request, resp *restful.Response) {
+	var r RequestPMVTokenWeight
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	row, statusCode, err := tasks.SavePMVTokenWeight(tasks.PMVTokenWeightRequest{
+		VolumeID:   r.VolumeID,
+		PathPrefix: r.PathPrefix,
+		Token:      r.Token,
+		Weight:     r.Weight,
+	})
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeaderAndEntity(statusCode, row)
+}
+
+func (i TaskResource) pmvTokenDelete(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.ParseUint(req.PathParameter("token-id"), 10, 64)
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	statusCode, err := tasks.DeletePMVTokenWeight(uint(id))
+	if err != nil {
+		APIError(req, resp, statusCode, err)
+		return
+	}
+	resp.WriteHeader(statusCode)
+}
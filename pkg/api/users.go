@@ -0,0 +1,303 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restfulspec "github.com/emicklei/go-restful-openapi/v2"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+const userCookie = "xbvr-user"
+
+type RequestUser struct {
+	Username string  `json:"username"`
+	Password *string `json:"password"`
+}
+
+type RequestLogin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserFilter works out who a web request comes from: basic auth credentials, then the login cookie of the web
+// UI, otherwise the default user. The player interfaces replace it with the user that logged in there.
+func UserFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	var user models.User
+	found := false
+
+	if username, password, ok := req.Request.BasicAuth(); ok {
+		if u, err := models.GetUserByName(username); err == nil && u.CheckPassword(password) {
+			user, found = u, true
+		}
+	}
+	if !found {
+		if cookie, err := req.Request.Cookie(userCookie); err == nil {
+			if u, err := models.GetUserByToken(cookie.Value); err == nil {
+				user, found = u, true
+			}
+		}
+	}
+	if found {
+		req.SetAttribute("user", user)
+	}
+	chain.ProcessFilter(req, resp)
+}
+
+// requestUser returns the user a request acts for
+func requestUser(req *restful.Request) models.User {
+	if user, ok := req.Attribute("user").(models.User); ok {
+		return user
+	}
+	return models.GetDefaultUser()
+}
+
+type ResponseCurrentUser struct {
+	models.User
+	IsAdmin bool `json:"is_admin"`
+}
+
+// accountsConfigured tells whether users have been set up, that is the default user has a password or there are
+// other users. Until then the server is used without logging in and any request may set them up.
+func accountsConfigured(users []models.User) bool {
+	for _, u := range users {
+		if !u.IsDefault || u.HasPassword {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIsAdmin tells whether a request may manage users, which takes logging in as the default user once
+// accounts are configured. Requests without credentials only may before that.
+func requestIsAdmin(req *restful.Request) bool {
+	if user, ok := req.Attribute("user").(models.User); ok {
+		if !user.IsDefault {
+			// There is another user, so accounts are configured
+			return false
+		}
+		if user.HasPassword {
+			return true
+		}
+	}
+	return !accountsConfigured(models.GetUsers())
+}
+
+// requestMayEditUser tells whether a request may change an account, users may change their own
+func requestMayEditUser(req *restful.Request, id uint) bool {
+	if user, ok := req.Attribute("user").(models.User); ok && user.ID == id {
+		return true
+	}
+	return requestIsAdmin(req)
+}
+
+var errNotAdmin = errors.New("only the default user can manage users, log in as the default user")
+
+type UserResource struct{}
+
+func (i UserResource) WebService() *restful.WebService {
+	tags := []string{"Users"}
+
+	ws := new(restful.WebService)
+
+	ws.Path("/api/users").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	// Listing and managing users takes the default user, see requestIsAdmin
+	ws.Route(ws.GET("").To(i.listUsers).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes([]models.User{}))
+
+	ws.Route(ws.POST("").To(i.createUser).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(RequestUser{}).
+		Writes(models.User{}))
+
+	ws.Route(ws.GET("/me").To(i.currentUser).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(ResponseCurrentUser{}))
+
+	ws.Route(ws.POST("/login").To(i.login).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(RequestLogin{}).
+		Writes(models.User{}))
+
+	ws.Route(ws.POST("/logout").To(i.logout).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Writes(models.User{}))
+
+	ws.Route(ws.PUT("/{user-id}").To(i.updateUser).
+		Param(ws.PathParameter("user-id", "User ID").DataType("int")).
+		Metadata(restfulspec.KeyOpenAPITags, tags).
+		Reads(RequestUser{}).
+		Writes(models.User{}))
+
+	ws.Route(ws.DELETE("/{user-id}").To(i.removeUser).
+		Param(ws.PathParameter("user-id", "User ID").DataType("int")).
+		Metadata(restfulspec.KeyOpenAPITags, tags))
+
+	return ws
+}
+
+func (i UserResource) listUsers(req *restful.Request, resp *restful.Response) {
+	if !requestIsAdmin(req) {
+		APIError(req, resp, http.StatusForbidden, errNotAdmin)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, models.GetUsers())
+}
+
+func (i UserResource) currentUser(req *restful.Request, resp *restful.Response) {
+	resp.WriteHeaderAndEntity(http.StatusOK, ResponseCurrentUser{User: requestUser(req), IsAdmin: requestIsAdmin(req)})
+}
+
+func (i UserResource) createUser(req *restful.Request, resp *restful.Response) {
+	if !requestIsAdmin(req) {
+		APIError(req, resp, http.StatusForbidden, errNotAdmin)
+		return
+	}
+
+	var r RequestUser
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	r.Username = strings.TrimSpace(r.Username)
+	if r.Username == "" {
+		APIError(req, resp, http.StatusBadRequest, errors.New("username is required"))
+		return
+	}
+	if _, err := models.GetUserByName(r.Username); err == nil {
+		APIError(req, resp, http.StatusConflict, errors.New("user "+r.Username+" already exists"))
+		return
+	}
+	// Without a password on the default user anyone could log in as it and manage the others
+	if !models.GetDefaultUser().HasPassword {
+		APIError(req, resp, http.StatusBadRequest, errors.New("set a password for the default user before adding users"))
+		return
+	}
+
+	user := models.User{Username: r.Username}
+	if r.Password != nil {
+		if err := user.SetPassword(*r.Password); err != nil {
+			APIError(req, resp, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := user.Save(); err != nil {
+		APIError(req, resp, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(http.StatusOK, user)
+}
+
+func (i UserResource) updateUser(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.Atoi(req.PathParameter("user-id"))
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+	if !requestMayEditUser(req, uint(id)) {
+		APIError(req, resp, http.StatusForbidden, errNotAdmin)
+		return
+	}
+
+	var r RequestUser
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := models.GetUserByID(uint(id))
+	if err != nil {
+		APIError(req, resp, http.StatusNotFound, err)
+		return
+	}
+
+	r.Username = strings.TrimSpace(r.Username)
+	if r.Username != "" && r.Username != user.Username {
+		if _, err := models.GetUserByName(r.Username); err == nil {
+			APIError(req, resp, http.StatusConflict, errors.New("user "+r.Username+" already exists"))
+			return
+		}
+		user.Username = r.Username
+	}
+	if r.Password != nil {
+		if *r.Password == "" && user.IsDefault && len(models.GetUsers()) > 1 {
+			APIError(req, resp, http.StatusBadRequest, errors.New("the default user needs a password while there are other users"))
+			return
+		}
+		if err := user.SetPassword(*r.Password); err != nil {
+			APIError(req, resp, http.StatusInternalServerError, err)
+			return
+		}
+		// Log out the web sessions of the user
+		user.Token = models.NewUserToken()
+	}
+	if err := user.Save(); err != nil {
+		APIError(req, resp, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp.WriteHeaderAndEntity(http.StatusOK, user)
+}
+
+func (i UserResource) removeUser(req *restful.Request, resp *restful.Response) {
+	id, err := strconv.Atoi(req.PathParameter("user-id"))
+	if err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+	if !requestIsAdmin(req) {
+		APIError(req, resp, http.StatusForbidden, errNotAdmin)
+		return
+	}
+
+	if err := models.DeleteUser(uint(id)); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (i UserResource) login(req *restful.Request, resp *restful.Response) {
+	var r RequestLogin
+	if err := req.ReadEntity(&r); err != nil {
+		APIError(req, resp, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := models.GetUserByName(r.Username)
+	if err != nil || !user.CheckPassword(r.Password) {
+		APIError(req, resp, http.StatusUnauthorized, errors.New("invalid username or password"))
+		return
+	}
+
+	http.SetCookie(resp.ResponseWriter, &http.Cookie{
+		Name:     userCookie,
+		Value:    user.Token,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	resp.WriteHeaderAndEntity(http.StatusOK, user)
+}
+
+func (i UserResource) logout(req *restful.Request, resp *restful.Response) {
+	http.SetCookie(resp.ResponseWriter, &http.Cookie{
+		Name:     userCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	resp.WriteHeaderAndEntity(http.StatusOK, models.GetDefaultUser())
+}
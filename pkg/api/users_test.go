@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func TestUserAuthorization(t *testing.T) {
+	admin := models.User{ID: 1, Username: "default", IsDefault: true, HasPassword: true}
+	alice := models.User{ID: 2, Username: "alice"}
+
+	cases := []struct {
+		user   models.User
+		method string
+		path   string
+		status int
+	}{
+		{alice, http.MethodGet, "/api/users", http.StatusForbidden},
+		{alice, http.MethodPost, "/api/users", http.StatusForbidden},
+		{alice, http.MethodDelete, "/api/users/3", http.StatusForbidden},
+		{alice, http.MethodPut, "/api/users/3", http.StatusForbidden},
+		{alice, http.MethodPut, "/api/users/1", http.StatusForbidden},
+		// Past the check the body is read, which isn't JSON
+		{alice, http.MethodPut, "/api/users/2", http.StatusBadRequest},
+		{admin, http.MethodPut, "/api/users/2", http.StatusBadRequest},
+		{admin, http.MethodPost, "/api/users", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		user := c.user
+		container := restful.NewContainer()
+		container.Filter(func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+			req.SetAttribute("user", user)
+			chain.ProcessFilter(req, resp)
+		})
+		container.Add(UserResource{}.WebService())
+
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader("not json"))
+		req.Header.Set("Content-Type", restful.MIME_JSON)
+		rec := httptest.NewRecorder()
+		container.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Fatalf("%v %v %v: expected %d, got %d", c.user.Username, c.method, c.path, c.status, rec.Code)
+		}
+	}
+}
+
+func TestAccountsConfigured(t *testing.T) {
+	open := models.User{ID: 1, Username: "default", IsDefault: true}
+	locked := models.User{ID: 1, Username: "default", IsDefault: true, HasPassword: true}
+	alice := models.User{ID: 2, Username: "alice"}
+
+	if accountsConfigured([]models.User{open}) {
+		t.Fatal("expected a default user without password to leave accounts unconfigured")
+	}
+	if !accountsConfigured([]models.User{locked}) {
+		t.Fatal("expected a password on the default user to configure accounts")
+	}
+	if !accountsConfigured([]models.User{open, alice}) {
+		t.Fatal("expected another user to configure accounts")
+	}
+}
+
+func TestSessionClientUser(t *testing.T) {
+	hr := httptest.NewRequest(http.MethodGet, "/api/dms/file/1", nil)
+	hr.RemoteAddr = "10.0.0.2:1234"
+	hr.Header.Set("User-Agent", "HereSphere")
+	hr.SetBasicAuth("alice", "wrong")
+	req := restful.NewRequest(hr)
+
+	if c := sessionClient(req); c.User != "" || c.Player != "heresphere" || c.RemoteAddr != "10.0.0.2" {
+		t.Fatalf("expected unchecked credentials to be ignored, got %+v", c)
+	}
+
+	req.SetAttribute("user", models.User{ID: 2, Username: "alice"})
+	if c := sessionClient(req); c.User != "alice" {
+		t.Fatalf("expected the checked user, got %+v", c)
+	}
+}
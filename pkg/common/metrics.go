@@ -1,8 +1,13 @@
 package common
 
 import (
+	"errors"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lomik/go-whisper"
@@ -43,3 +48,54 @@ func AddMetricPoint(name string, value float64) error {
 
 	return nil
 }
+
+type MetricPoint struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+var metricNameRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ListMetrics returns the names of all metrics that were recorded so far
+func ListMetrics() []string {
+	files, _ := filepath.Glob(filepath.Join(MetricsDir, "*.wsp"))
+	names := []string{}
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(f), ".wsp"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FetchMetric reads a metric between two times, whisper answers from the finest archive that covers the range.
+// Intervals without a value are left out. It also returns the step between points in seconds.
+func FetchMetric(name string, from time.Time, until time.Time) ([]MetricPoint, int, error) {
+	if !metricNameRegex.MatchString(name) {
+		return nil, 0, errors.New("invalid metric name")
+	}
+	path := filepath.Join(MetricsDir, name+".wsp")
+	if _, err := os.Stat(path); err != nil {
+		return nil, 0, err
+	}
+
+	wsp, err := whisper.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer wsp.Close()
+
+	ts, err := wsp.Fetch(int(from.Unix()), int(until.Unix()))
+	if err != nil {
+		return nil, 0, err
+	}
+	points := []MetricPoint{}
+	if ts == nil {
+		return points, 0, nil
+	}
+	for _, p := range ts.Points() {
+		if !math.IsNaN(p.Value) {
+			points = append(points, MetricPoint{Time: int64(p.Time), Value: p.Value})
+		}
+	}
+	return points, ts.Step(), nil
+}
@@ -81,6 +81,7 @@ type ObjectConfig struct {
 			ServiceName  string   `default:"XBVR" json:"serviceName"`
 			ServiceImage string   `default:"default" json:"serviceImage"`
 			AllowedIP    []string `default:"[]" json:"allowedIp"`
+			User         string   `default:"" json:"user"`
 		} `json:"dlna"`
 		DeoVR struct {
 			Enabled        bool   `default:"true" json:"enabled"`
@@ -185,9 +186,24 @@ type ObjectConfig struct {
 		} `json:"pmvMatchSchedule"`
 	} `json:"cron"`
 	Storage struct {
-		MatchOhash bool     `default:"false" json:"match_ohash"`
-		VideoExt   []string `json:"video_ext"`
+		MatchOhash            bool     `default:"false" json:"match_ohash"`
+		VideoExt              []string `json:"video_ext"`
+		WatchVolumes          bool     `default:"true" json:"watch_volumes"`
+		WatchDebounce         int      `default:"10" json:"watch_debounce"`
+		ScanConcurrency       int      `default:"2" json:"scan_concurrency"`
+		AllowDuplicateDeletes bool     `default:"false" json:"allow_duplicate_deletes"`
+		AnalyseProjection     bool     `default:"false" json:"analyse_projection"`
 	} `json:"storage"`
+	PMVMatch struct {
+		VisualMatching    bool     `default:"false" json:"visualMatching"`
+		KeyframeCount     int      `default:"8" json:"keyframeCount"`
+		VisualWeight      float64  `default:"0.6" json:"visualWeight"`
+		AudioMatching     bool     `default:"false" json:"audioMatching"`
+		AudioWeight       float64  `default:"0.5" json:"audioWeight"`
+		MinConfidence     float64  `default:"0.6" json:"minConfidence"`
+		MinMargin         float64  `default:"0.1" json:"minMargin"`
+		DisabledProviders []string `default:"[]" json:"disabledProviders"`
+	} `json:"pmvMatch"`
 	ScraperSettings struct {
 		TMWVRNet struct {
 			TmwMembersDomain string `default:"members.tmwvrnet.com" json:"tmwMembersDomain"`
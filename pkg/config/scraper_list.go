@@ -29,12 +29,13 @@ type XbvrScrapers struct {
 	VrphubScrapers  []ScraperConfig `json:"vrphub"`
 }
 type CustomScrapers struct {
-	PovrScrapers    []ScraperConfig `json:"povr"`
-	SlrScrapers     []ScraperConfig `json:"slr"`
-	StashDbScrapers []ScraperConfig `json:"stashdb"`
-	RealVRScrapers  []ScraperConfig `json:"realvr"`
-	VrpornScrapers  []ScraperConfig `json:"vrporn"`
-	VrphubScrapers  []ScraperConfig `json:"vrphub"`
+	PovrScrapers     []ScraperConfig `json:"povr"`
+	SlrScrapers      []ScraperConfig `json:"slr"`
+	StashDbScrapers  []ScraperConfig `json:"stashdb"`
+	RealVRScrapers   []ScraperConfig `json:"realvr"`
+	VrpornScrapers   []ScraperConfig `json:"vrporn"`
+	VrphubScrapers   []ScraperConfig `json:"vrphub"`
+	PmvhavenScrapers []ScraperConfig `json:"pmvhaven"`
 }
 type ScraperConfig struct {
 	ID           string `json:"-"`
@@ -85,6 +86,7 @@ func (o *ScraperList) Load() error {
 	SetSiteId(&o.CustomScrapers.VrphubScrapers, "vrphub")
 	SetSiteId(&o.CustomScrapers.VrpornScrapers, "vrporn")
 	SetSiteId(&o.CustomScrapers.RealVRScrapers, "realvr")
+	SetSiteId(&o.CustomScrapers.PmvhavenScrapers, "pmvhaven")
 
 	// remove custom sites that are now offical for the same aggregation site
 	o.CustomScrapers.PovrScrapers = RemoveCustomListNowOffical(o.CustomScrapers.PovrScrapers, o.XbvrScrapers.PovrScrapers)
@@ -17,6 +17,7 @@ import (
 
 	"github.com/anacrolix/ffprobe"
 	"github.com/markphelps/optional"
+	"github.com/xbapps/xbvr/pkg/config"
 	"github.com/xbapps/xbvr/pkg/dms/dlna"
 	"github.com/xbapps/xbvr/pkg/dms/upnp"
 	"github.com/xbapps/xbvr/pkg/dms/upnpav"
@@ -444,6 +445,8 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 				if err := json.Unmarshal([]byte(savedPlaylist.SearchParams), &r); err == nil {
 					r.IsAccessible = optional.NewBool(true)
 					r.IsAvailable = optional.NewBool(true)
+					// DLNA players can't log in, saved searches use the lists of the user set in the options
+					r.UserID = models.ResolveUserID(config.Config.Interfaces.DLNA.User)
 					data := models.QueryScenesFull(r)
 
 					for i := range data.Scenes {
@@ -729,6 +729,7 @@ func (server *Server) initMux(mux *http.ServeMux) {
 		}
 
 		filePath := ""
+		var file models.File
 
 		if sceneId != "" {
 			var scene models.Scene
@@ -738,16 +739,32 @@ func (server *Server) initMux(mux *http.ServeMux) {
 			if err != nil || len(videoFiles) == 0 {
 				return
 			}
+			file = videoFiles[0]
 			filePath = filepath.Join(videoFiles[0].Path, videoFiles[0].Filename)
 		}
 
 		if fileId != 0 {
-			var file models.File
+			file = models.File{}
 			file.GetIfExistByPK(uint(fileId))
 
 			filePath = filepath.Join(file.Path, file.Filename)
 		}
 
+		if file.ID != 0 && file.Volume.ID == 0 {
+			db, _ := models.GetDB()
+			db.First(&file.Volume, file.VolumeID)
+			db.Close()
+		}
+		if file.Volume.IsRemote() {
+			if mimeType := mimeTypeByBaseName(path.Base(filePath)); mimeType != "" {
+				w.Header().Set("Content-Type", string(mimeType))
+			}
+			if err := file.ServeRemote(w, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			}
+			return
+		}
+
 		if filePath != "" {
 			mimeType, err := MimeTypeByPath(filePath)
 			if err != nil {
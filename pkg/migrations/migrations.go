@@ -847,6 +847,131 @@ func Migrate(migrateTo string) {
 				return tx.AutoMigrate(File{}).Error
 			},
 		},
+		{
+			ID: "0088-file-perceptual-hash",
+			Migrate: func(tx *gorm.DB) error {
+				type File struct {
+					PerceptualHash string `json:"-" sql:"type:text;"`
+				}
+				return tx.AutoMigrate(File{}).Error
+			},
+		},
+		{
+			ID: "0089-pmv-review-queue",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.PMVReviewItem{}).Error
+			},
+		},
+		{
+			ID: "0090-audio-fingerprints",
+			Migrate: func(tx *gorm.DB) error {
+				type File struct {
+					AudioFingerprint string `json:"-" sql:"type:text;"`
+				}
+				type Scene struct {
+					Soundtrack string `json:"soundtrack" sql:"type:text;"`
+				}
+				if err := tx.AutoMigrate(File{}, Scene{}).Error; err != nil {
+					return err
+				}
+				return tx.AutoMigrate(&models.AudioFingerprint{}).Error
+			},
+		},
+		{
+			ID: "0091-audio-landmarks",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.AudioFingerprint{}, &models.AudioLandmark{}).Error
+			},
+		},
+		{
+			ID: "0092-pmv-token-weights",
+			Migrate: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&models.PMVTokenWeight{}).Error
+			},
+		},
+		{
+			ID: "0093-volume-scan-concurrency",
+			Migrate: func(tx *gorm.DB) error {
+				type Volume struct {
+					ScanConcurrency int
+				}
+				return tx.AutoMigrate(Volume{}).Error
+			},
+		},
+		{
+			ID: "0094-file-projection-confidence",
+			Migrate: func(tx *gorm.DB) error {
+				type File struct {
+					ProjectionConfidence float64
+					ProjectionSource     string
+				}
+				return tx.AutoMigrate(File{}).Error
+			},
+		},
+		{
+			ID: "0095-volume-scan-rules",
+			Migrate: func(tx *gorm.DB) error {
+				type Volume struct {
+					ScanRules   string `sql:"type:text;"`
+					ContentType string
+				}
+				return tx.AutoMigrate(Volume{}).Error
+			},
+		},
+		{
+			ID: "0096-volume-identity",
+			Migrate: func(tx *gorm.DB) error {
+				type Volume struct {
+					Identity     string
+					OfflineSince time.Time
+				}
+				return tx.AutoMigrate(Volume{}).Error
+			},
+		},
+		{
+			ID: "0097-user-accounts",
+			Migrate: func(tx *gorm.DB) error {
+				type User struct {
+					ID        uint `gorm:"primary_key"`
+					CreatedAt time.Time
+					UpdatedAt time.Time
+					Username  string `gorm:"unique_index"`
+					Password  string
+					IsDefault bool
+					Token     string
+				}
+				type UserScene struct {
+					ID         uint `gorm:"primary_key"`
+					CreatedAt  time.Time
+					UpdatedAt  time.Time
+					UserID     uint `gorm:"unique_index:idx_user_scene"`
+					SceneID    uint `gorm:"unique_index:idx_user_scene;index"`
+					StarRating float64
+					Favourite  bool
+					Watchlist  bool
+					IsWatched  bool
+				}
+				type History struct {
+					UserID uint `gorm:"index"`
+				}
+				return tx.AutoMigrate(User{}, UserScene{}, History{}).Error
+			},
+		},
+		{
+			ID: "0098-user-file-resume",
+			Migrate: func(tx *gorm.DB) error {
+				type UserFile struct {
+					ID             uint `gorm:"primary_key"`
+					CreatedAt      time.Time
+					UpdatedAt      time.Time
+					UserID         uint `gorm:"unique_index:idx_user_file"`
+					FileID         uint `gorm:"unique_index:idx_user_file;index"`
+					ResumePosition float64
+					LastPlayed     time.Time
+				}
+				return tx.AutoMigrate(UserFile{}).Error
+			},
+		},
 
 		// ===============================================================================================
 		// Put DB Schema migrations above this line and migrations that rely on the updated schema below
@@ -2379,6 +2504,88 @@ func Migrate(migrateTo string) {
 				return nil
 			},
 		},
+		{
+			// PMV scenes used to be created per file as custom-pmv-<fileID>, they are now keyed on the PMV source id
+			ID: "0087-merge-custom-pmv-scenes",
+			Migrate: func(tx *gorm.DB) error {
+				changed, err := tasks.MergeCustomPMVScenes(tx)
+				if err != nil {
+					return err
+				}
+				if changed != 0 {
+					tasks.SearchIndex()
+				}
+				return nil
+			},
+		},
+		{
+			// The watched filter of saved searches is a watch state now, so partially watched can be told apart
+			ID: "0099-playlist-watch-state",
+			Migrate: func(tx *gorm.DB) error {
+				var playlists []models.Playlist
+				tx.Where("playlist_type = ?", "scene").Find(&playlists)
+				for _, playlist := range playlists {
+					var params map[string]interface{}
+					if err := json.Unmarshal([]byte(playlist.SearchParams), &params); err != nil {
+						continue
+					}
+					watched, ok := params["isWatched"].(bool)
+					if !ok {
+						continue
+					}
+					if watched {
+						params["watchState"] = "watched"
+					} else {
+						params["watchState"] = "unwatched"
+					}
+					delete(params, "isWatched")
+
+					b, err := json.Marshal(params)
+					if err != nil {
+						return err
+					}
+					if err := tx.Model(&models.Playlist{}).Where("id = ?", playlist.ID).UpdateColumn("search_params", string(b)).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// Ratings, lists, watched state and history so far become those of the default user
+			ID: "0100-default-user",
+			Migrate: func(tx *gorm.DB) error {
+				var user models.User
+				if tx.Where("is_default = ?", true).First(&user).RecordNotFound() {
+					user = models.User{Username: config.Config.Security.Username, IsDefault: true, Token: models.NewUserToken()}
+					if user.Username == "" {
+						user.Username = common.EnvConfig.UIUsername
+					}
+					if user.Username == common.EnvConfig.UIUsername && common.EnvConfig.UIPassword != "" {
+						if err := user.SetPassword(common.EnvConfig.UIPassword); err != nil {
+							return err
+						}
+					}
+					if user.Username == "" {
+						user.Username = "default"
+					}
+					if err := tx.Create(&user).Error; err != nil {
+						return err
+					}
+				}
+
+				now := time.Now()
+				err := tx.Exec(`insert into user_scenes (created_at, updated_at, user_id, scene_id, star_rating, favourite, watchlist, is_watched)
+					select ?, ?, ?, scenes.id, scenes.star_rating, scenes.favourite, scenes.watchlist, scenes.is_watched from scenes
+					where (scenes.star_rating > 0 or scenes.favourite = ? or scenes.watchlist = ? or scenes.is_watched = ?)
+					and not exists (select 1 from user_scenes where user_scenes.scene_id = scenes.id and user_scenes.user_id = ?)`,
+					now, now, user.ID, true, true, true, user.ID).Error
+				if err != nil {
+					return err
+				}
+				return tx.Exec("update histories set user_id = ? where user_id = 0 or user_id is null", user.ID).Error
+			},
+		},
 	}
 
 	// Wrap migrations to automatically track progress
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+// AudioFingerprint is an entry of the local soundtrack fingerprint database. Song entries hold one song cut
+// out of a matched file, scene entries hold the whole soundtrack of a matched PMV so renamed copies can be found.
+type AudioFingerprint struct {
+	ID        uint      `gorm:"primary_key" json:"id" xbvrbackup:"-"`
+	CreatedAt time.Time `json:"created_at" xbvrbackup:"-"`
+	UpdatedAt time.Time `json:"updated_at" xbvrbackup:"-"`
+
+	Kind        string  `gorm:"index" json:"kind" xbvrbackup:"-"`
+	Artist      string  `json:"artist" xbvrbackup:"-"`
+	Title       string  `json:"title" xbvrbackup:"-"`
+	SceneID     string  `gorm:"index" json:"scene_id" xbvrbackup:"-"`
+	FileID      uint    `gorm:"index" json:"file_id" xbvrbackup:"-"`
+	TimeStart   float64 `json:"time_start" xbvrbackup:"-"`
+	TimeEnd     float64 `json:"time_end" xbvrbackup:"-"`
+	Fingerprint string  `json:"-" sql:"type:text;" xbvrbackup:"-"`
+	Indexed     bool    `gorm:"index" json:"-" xbvrbackup:"-"`
+}
+
+// AudioLandmark lists the landmarks of a fingerprint, so matching only decodes the fingerprints sharing some
+// with the file instead of the whole database
+type AudioLandmark struct {
+	ID            uint   `gorm:"primary_key" json:"-"`
+	Landmark      uint32 `gorm:"index:idx_audio_landmark" json:"-"`
+	FingerprintID uint   `gorm:"index:idx_audio_landmark;index" json:"-"`
+}
+
+func (o *AudioFingerprint) GetIfExist(id uint) error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	return db.Where(&AudioFingerprint{ID: id}).First(o).Error
+}
+
+func (o *AudioFingerprint) Save() error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var err error = retry.Do(
+		func() error {
+			err := db.Save(&o).Error
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	)
+
+	if err != nil {
+		log.Fatal("Failed to save ", err)
+	}
+
+	return nil
+}
+
+func (o *AudioFingerprint) Delete() {
+	db, _ := GetDB()
+	db.Where("fingerprint_id = ?", o.ID).Delete(&AudioLandmark{})
+	db.Delete(&o)
+	db.Close()
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"math"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -9,8 +10,19 @@ import (
 	"time"
 
 	"github.com/avast/retry-go/v4"
+	"github.com/xbapps/xbvr/pkg/storage"
 )
 
+// Where the projection of a video comes from, projections set by the user are never replaced by scans
+const (
+	ProjectionSourceAspect   = "aspect"
+	ProjectionSourceFilename = "filename"
+	ProjectionSourceAnalysis = "analysis"
+	ProjectionSourceUser     = "user"
+)
+
+var VideoProjections = []string{"flat", "180_mono", "360_mono", "180_sbs", "360_tb", "fisheye", "mkx200", "mkx220", "rf52", "fisheye190", "vrca220"}
+
 type File struct {
 	ID        uint      `gorm:"primary_key" json:"id" xbvrbackup:"-"`
 	CreatedAt time.Time `json:"created_at" xbvrbackup:"-"`
@@ -37,12 +49,17 @@ type File struct {
 	VideoCodecName       string  `json:"video_codec_name" xbvrbackup:"video_codec_name"`
 	VideoDuration        float64 `json:"duration" xbvrbackup:"duration"`
 	VideoProjection      string  `json:"projection" xbvrbackup:"projection"`
+	ProjectionConfidence float64 `json:"projection_confidence" xbvrbackup:"projection_confidence"`
+	ProjectionSource     string  `json:"projection_source" xbvrbackup:"projection_source"`
 	HasAlpha             bool    `json:"has_alpha" xbvrbackup:"has_alpha"`
 
 	HasHeatmap          bool `json:"has_heatmap" xbvrbackup:"-"`
 	IsSelectedScript    bool `json:"is_selected_script" xbvrbackup:"is_selected_script"`
 	IsExported          bool `json:"is_exported" xbvrbackup:"-"`
 	RefreshHeatmapCache bool `json:"refresh_heatmap_cache" xbvrbackup:"-"`
+
+	PerceptualHash   string `json:"-" sql:"type:text;" xbvrbackup:"-"`
+	AudioFingerprint string `json:"-" sql:"type:text;" xbvrbackup:"-"`
 }
 
 func (f *File) GetPath() string {
@@ -77,6 +94,20 @@ func (f *File) GetIfExistByPK(id uint) error {
 	return db.Where(&File{ID: id}).First(f).Error
 }
 
+// ServeRemote streams a file of a SFTP, WebDAV or S3 volume, the file has to be loaded with its volume
+func (f *File) ServeRemote(w http.ResponseWriter, r *http.Request) error {
+	backend, err := f.Volume.GetBackend()
+	if err != nil {
+		return err
+	}
+	entry, err := backend.Stat(r.Context(), f.GetPath())
+	if err != nil {
+		return err
+	}
+	storage.ServeFile(w, r, backend, entry)
+	return nil
+}
+
 func (f *File) Exists() bool {
 	switch f.Volume.Type {
 	case "local":
@@ -87,6 +118,9 @@ func (f *File) Exists() bool {
 	case "putio":
 		// NOTE: we're assuming files weren't removed via Put.io web UI, so there's no need to check
 		return true
+	case storage.TypeSFTP, storage.TypeWebDAV, storage.TypeS3:
+		// Remote volumes are listed as a whole during scans, files that are gone are removed there
+		return true
 	default:
 		return false
 	}
@@ -12,6 +12,7 @@ type History struct {
 	UpdatedAt time.Time `json:"-" xbvrbackup:"updated_at"`
 
 	SceneID   uint      `json:"scene_id" xbvrbackup:"-"`
+	UserID    uint      `gorm:"index" json:"user_id" xbvrbackup:"-"`
 	TimeStart time.Time `json:"time_start" xbvrbackup:"time_start"`
 	TimeEnd   time.Time `json:"time_end" xbvrbackup:"time_end"`
 	Duration  float64   `json:"duration" xbvrbackup:"duration"`
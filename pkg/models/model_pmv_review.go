@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+// PMVReviewItem holds a file whose PMV match candidates were not confident enough to autolink
+type PMVReviewItem struct {
+	ID        uint      `gorm:"primary_key" json:"id" xbvrbackup:"-"`
+	CreatedAt time.Time `json:"created_at" xbvrbackup:"-"`
+	UpdatedAt time.Time `json:"updated_at" xbvrbackup:"-"`
+
+	FileID     uint   `gorm:"unique_index" json:"file_id" xbvrbackup:"-"`
+	Filename   string `json:"filename" xbvrbackup:"-"`
+	Query      string `json:"query" xbvrbackup:"-"`
+	Status     string `json:"status" xbvrbackup:"-"`
+	Reason     string `json:"reason" xbvrbackup:"-"`
+	Candidates string `json:"-" sql:"type:text;" xbvrbackup:"-"`
+}
+
+func (o *PMVReviewItem) GetIfExist(id uint) error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	return db.Where(&PMVReviewItem{ID: id}).First(o).Error
+}
+
+func (o *PMVReviewItem) Save() error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var err error = retry.Do(
+		func() error {
+			err := db.Save(&o).Error
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	)
+
+	if err != nil {
+		log.Fatal("Failed to save ", err)
+	}
+
+	return nil
+}
+
+func (o *PMVReviewItem) Delete() {
+	db, _ := GetDB()
+	db.Delete(&o)
+	db.Close()
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+// PMVTokenWeight is a learned filename token weight used by the PMV matcher. Weights run from -1 (always noise)
+// to 1 (always part of the title) and apply to files on VolumeID below PathPrefix, VolumeID 0 applies everywhere.
+type PMVTokenWeight struct {
+	ID        uint      `gorm:"primary_key" json:"id" xbvrbackup:"-"`
+	CreatedAt time.Time `json:"created_at" xbvrbackup:"-"`
+	UpdatedAt time.Time `json:"updated_at" xbvrbackup:"-"`
+
+	VolumeID    uint    `gorm:"unique_index:idx_pmv_token_scope" json:"volume_id" xbvrbackup:"-"`
+	PathPrefix  string  `gorm:"unique_index:idx_pmv_token_scope;size:500" json:"path_prefix" xbvrbackup:"-"`
+	Token       string  `gorm:"unique_index:idx_pmv_token_scope;size:100" json:"token" xbvrbackup:"-"`
+	NoiseCount  int     `json:"noise_count" xbvrbackup:"-"`
+	SignalCount int     `json:"signal_count" xbvrbackup:"-"`
+	Weight      float64 `json:"weight" xbvrbackup:"-"`
+	Manual      bool    `json:"manual" xbvrbackup:"-"`
+}
+
+func (o *PMVTokenWeight) GetIfExist(id uint) error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	return db.Where(&PMVTokenWeight{ID: id}).First(o).Error
+}
+
+func (o *PMVTokenWeight) Save() error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var err error = retry.Do(
+		func() error {
+			err := db.Save(&o).Error
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	)
+
+	if err != nil {
+		log.Fatal("Failed to save ", err)
+	}
+
+	return nil
+}
+
+func (o *PMVTokenWeight) Delete() {
+	db, _ := GetDB()
+	db.Delete(&o)
+	db.Close()
+}
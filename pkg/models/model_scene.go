@@ -73,6 +73,7 @@ type Scene struct {
 	Files           []File    `json:"file" xbvrbackup:"-"`
 	Duration        int       `json:"duration" xbvrbackup:"duration"`
 	Synopsis        string    `json:"synopsis" sql:"type:text;" xbvrbackup:"synopsis"`
+	Soundtrack      string    `json:"soundtrack" sql:"type:text;" xbvrbackup:"soundtrack"`
 	ReleaseDate     time.Time `json:"release_date" xbvrbackup:"release_date"`
 	ReleaseDateText string    `json:"release_date_text" xbvrbackup:"release_date_text"`
 	CoverURL        string    `gorm:"size:500" json:"cover_url" xbvrbackup:"cover_url"`
@@ -662,6 +663,7 @@ type RequestSceneList struct {
 	IsAvailable  optional.Bool     `json:"isAvailable"`
 	IsAccessible optional.Bool     `json:"isAccessible"`
 	IsWatched    optional.Bool     `json:"isWatched"`
+	WatchState   optional.String   `json:"watchState"`
 	Lists        []optional.String `json:"lists"`
 	Sites        []optional.String `json:"sites"`
 	Studios      []optional.String `json:"studios"`
@@ -672,6 +674,9 @@ type RequestSceneList struct {
 	Volume       optional.Int      `json:"volume"`
 	Released     optional.String   `json:"releaseMonth"`
 	Sort         optional.String   `json:"sort"`
+
+	// UserID is the user whose ratings, lists and watched state are filtered on, the default user when 0
+	UserID uint `json:"-"`
 }
 
 type ResponseSceneList struct {
@@ -773,8 +778,29 @@ func queryScenes(db *gorm.DB, r RequestSceneList) (*gorm.DB, *gorm.DB) {
 
 	tx := db.Model(&Scene{})
 
-	if r.IsWatched.Present() {
-		tx = tx.Where("is_watched = ?", r.IsWatched.OrElse(true))
+	user := GetDefaultUser()
+	if r.UserID != 0 && r.UserID != user.ID {
+		user = User{ID: r.UserID}
+	}
+	userID := user.ID
+	starRating := userSceneColumn(user, "star_rating")
+	favourite := userSceneColumn(user, "favourite")
+	watchlist := userSceneColumn(user, "watchlist")
+	isWatched := userSceneColumn(user, "is_watched")
+
+	// Scenes with a file to resume count as partially watched, isWatched is kept for older clients
+	resumable := fmt.Sprintf("exists (select 1 from files join user_files on user_files.file_id = files.id where files.scene_id = scenes.id and user_files.user_id = %d and user_files.resume_position > 0)", userID)
+	switch r.WatchState.OrElse("") {
+	case "watched":
+		tx = tx.Where(isWatched+" = ? and not "+resumable, true)
+	case "partial":
+		tx = tx.Where(resumable)
+	case "unwatched":
+		tx = tx.Where(isWatched+" = ? and not "+resumable, false)
+	default:
+		if r.IsWatched.Present() {
+			tx = tx.Where(isWatched+" = ?", r.IsWatched.OrElse(true))
+		}
 	}
 
 	if r.Volume.Present() && r.Volume.OrElse(0) != 0 {
@@ -785,10 +811,10 @@ func queryScenes(db *gorm.DB, r RequestSceneList) (*gorm.DB, *gorm.DB) {
 
 	for _, i := range r.Lists {
 		if i.OrElse("") == "watchlist" {
-			tx = tx.Where("scenes.watchlist = ?", true)
+			tx = tx.Where(watchlist+" = ?", true)
 		}
 		if i.OrElse("") == "favourite" {
-			tx = tx.Where("scenes.favourite = ?", true)
+			tx = tx.Where(favourite+" = ?", true)
 		}
 		if i.OrElse("") == "wishlist" {
 			tx = tx.Where("wishlist = ?", true)
@@ -844,7 +870,7 @@ func queryScenes(db *gorm.DB, r RequestSceneList) (*gorm.DB, *gorm.DB) {
 		case "Has Subtitles File":
 			where = "exists (select 1 from files where files.scene_id = scenes.id and files.`type` = 'subtitles')"
 		case "Has Rating":
-			where = "scenes.star_rating > 0"
+			where = starRating + " > 0"
 		case "Has Cuepoints":
 			where = "exists (select 1 from scene_cuepoints where scene_cuepoints.scene_id = scenes.id)"
 		case "Has Simple Cuepoints":
@@ -858,7 +884,7 @@ func queryScenes(db *gorm.DB, r RequestSceneList) (*gorm.DB, *gorm.DB) {
 		case "Has Subscription":
 			where = "is_subscribed = 1"
 		case "Rating":
-			where = "scenes.star_rating = " + value
+			where = starRating + " = " + value
 		case "No Actor/Cast":
 			where = "exists (select 1 from scenes s left join scene_cast sc on sc.scene_id =s.id where s.id=scenes.id and  sc.scene_id is NULL)"
 		case "Cast 6+":
@@ -916,11 +942,11 @@ func queryScenes(db *gorm.DB, r RequestSceneList) (*gorm.DB, *gorm.DB) {
 		case "Codec":
 			where = "exists (select 1 from files where files.scene_id = scenes.id and files.`type` = 'video' and files.video_codec_name = '" + value + "')"
 		case "In Watchlist":
-			where = "scenes.watchlist = 1"
+			where = watchlist + " = 1"
 		case "Is Scripted":
 			where = "is_scripted = 1"
 		case "Is Favourite":
-			where = "scenes.favourite = 1"
+			where = favourite + " = 1"
 		case "Is Passthrough":
 			where = "(chroma_key <> '' or exists (select 1 from files where files.scene_id = scenes.id and files.`type` = 'video' and files.has_alpha = true))"
 		case "Is Alpha Passthrough":
@@ -1190,12 +1216,12 @@ func queryScenes(db *gorm.DB, r RequestSceneList) (*gorm.DB, *gorm.DB) {
 		tx = tx.Order("total_watch_time asc")
 	case "rating_desc":
 		tx = tx.
-			Where("scenes.star_rating > 0").
-			Order("scenes.star_rating desc")
+			Where(starRating + " > 0").
+			Order(starRating + " desc")
 	case "rating_asc":
 		tx = tx.
-			Where("scenes.star_rating > 0").
-			Order("scenes.star_rating asc")
+			Where(starRating + " > 0").
+			Order(starRating + " asc")
 	case "last_opened_desc":
 		tx = tx.
 			Where("last_opened > '0001-01-01 00:00:00+00:00'").
@@ -0,0 +1,424 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is an account of the household sharing the server. Ratings, lists, watched state and history are kept
+// per user, the default user owns everything that was there before accounts existed.
+type User struct {
+	ID        uint      `gorm:"primary_key" json:"id" xbvrbackup:"-"`
+	CreatedAt time.Time `json:"created_at" xbvrbackup:"-"`
+	UpdatedAt time.Time `json:"-" xbvrbackup:"-"`
+
+	Username  string `gorm:"unique_index" json:"username" xbvrbackup:"username"`
+	Password  string `json:"-" xbvrbackup:"-"`
+	IsDefault bool   `json:"is_default" xbvrbackup:"is_default"`
+	Token     string `json:"-" xbvrbackup:"-"`
+
+	HasPassword bool `gorm:"-" json:"has_password" xbvrbackup:"-"`
+}
+
+// UserScene holds the state of a scene for one user. The state of the default user is read from the scene, where
+// backups, bundles and older code keep writing it.
+type UserScene struct {
+	ID        uint      `gorm:"primary_key" json:"-"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+
+	UserID     uint    `gorm:"unique_index:idx_user_scene" json:"user_id"`
+	SceneID    uint    `gorm:"unique_index:idx_user_scene;index" json:"scene_id"`
+	StarRating float64 `json:"star_rating"`
+	Favourite  bool    `json:"favourite"`
+	Watchlist  bool    `json:"watchlist"`
+	IsWatched  bool    `json:"is_watched"`
+}
+
+// UserFile is where a user stopped playing a file
+type UserFile struct {
+	ID        uint      `gorm:"primary_key" json:"-"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+
+	UserID         uint      `gorm:"unique_index:idx_user_file" json:"user_id"`
+	FileID         uint      `gorm:"unique_index:idx_user_file;index" json:"file_id"`
+	ResumePosition float64   `json:"resume_position"`
+	LastPlayed     time.Time `json:"last_played"`
+}
+
+var userSceneColumns = map[string]bool{"star_rating": true, "favourite": true, "watchlist": true, "is_watched": true}
+
+func (o *User) AfterFind() error {
+	o.HasPassword = o.Password != ""
+	return nil
+}
+
+func (o *User) Save() error {
+	db, _ := GetDB()
+	defer db.Close()
+
+	if o.Token == "" {
+		o.Token = NewUserToken()
+	}
+
+	var err error = retry.Do(
+		func() error {
+			err := db.Save(&o).Error
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	)
+
+	if err != nil {
+		log.Error("Failed to save ", err)
+	}
+	return err
+}
+
+// SetPassword stores the hash of the password, an empty password removes it
+func (o *User) SetPassword(password string) error {
+	if password == "" {
+		o.Password = ""
+		o.HasPassword = false
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	o.Password = string(hash)
+	o.HasPassword = true
+	return nil
+}
+
+// CheckPassword tells whether the password is right, users without a password accept none
+func (o *User) CheckPassword(password string) bool {
+	if o.Password == "" {
+		return password == ""
+	}
+	return bcrypt.CompareHashAndPassword([]byte(o.Password), []byte(password)) == nil
+}
+
+func NewUserToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+func GetUsers() []User {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var users []User
+	db.Order("username asc").Find(&users)
+	return users
+}
+
+func GetUserByID(id uint) (User, error) {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var user User
+	err := db.Where("id = ?", id).First(&user).Error
+	return user, err
+}
+
+func GetUserByName(username string) (User, error) {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var user User
+	err := db.Where("username = ?", username).First(&user).Error
+	return user, err
+}
+
+func GetUserByToken(token string) (User, error) {
+	if token == "" {
+		return User{}, gorm.ErrRecordNotFound
+	}
+
+	db, _ := GetDB()
+	defer db.Close()
+
+	var user User
+	err := db.Where("token = ?", token).First(&user).Error
+	return user, err
+}
+
+// GetDefaultUser returns the user requests without credentials act as, it is created when missing
+func GetDefaultUser() User {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var user User
+	if db.Where("is_default = ?", true).First(&user).RecordNotFound() {
+		user = User{Username: "default", IsDefault: true, Token: NewUserToken()}
+		db.Create(&user)
+	}
+	return user
+}
+
+// ResolveUserID returns the ID of a user by name, unknown and empty names resolve to the default user
+func ResolveUserID(username string) uint {
+	if username != "" {
+		if user, err := GetUserByName(username); err == nil {
+			return user.ID
+		}
+	}
+	return GetDefaultUser().ID
+}
+
+// AuthenticateUser checks the credentials players send, only users with a password can log in this way
+func AuthenticateUser(username string, password string) (User, error) {
+	user, err := GetUserByName(username)
+	if err != nil || user.Password == "" || !user.CheckPassword(password) {
+		return User{}, errors.New("invalid username or password")
+	}
+	return user, nil
+}
+
+// DeleteUser removes a user with its scene state and history, the default user can't be removed
+func DeleteUser(id uint) error {
+	user, err := GetUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user.IsDefault {
+		return errors.New("the default user can't be removed")
+	}
+
+	db, _ := GetDB()
+	defer db.Close()
+
+	tx := db.Begin()
+	if err := tx.Where("user_id = ?", id).Delete(&UserScene{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("user_id = ?", id).Delete(&UserFile{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("user_id = ?", id).Delete(&History{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(&user).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// SaveResumePosition remembers where a user stopped playing a file. Playback that barely started or got close
+// to the end leaves nothing to resume.
+func SaveResumePosition(userID uint, fileID uint, position float64, duration float64) error {
+	position = resumePosition(position, duration)
+
+	db, _ := GetDB()
+	defer db.Close()
+
+	var uf UserFile
+	if err := db.Where(UserFile{UserID: userID, FileID: fileID}).FirstOrCreate(&uf).Error; err != nil {
+		return err
+	}
+	return db.Model(&uf).UpdateColumns(map[string]interface{}{
+		"resume_position": position,
+		"last_played":     time.Now(),
+	}).Error
+}
+
+func resumePosition(position float64, duration float64) float64 {
+	if position < 10 {
+		return 0
+	}
+	if duration > 0 && (position > duration*0.95 || position > duration-30) {
+		return 0
+	}
+	return position
+}
+
+// ResumeFile returns the file of files the user played last and can resume, with the position to resume at
+func ResumeFile(userID uint, files []File) (File, float64, bool) {
+	var ids []uint
+	for _, f := range files {
+		ids = append(ids, f.ID)
+	}
+	if len(ids) == 0 {
+		return File{}, 0, false
+	}
+
+	db, _ := GetDB()
+	defer db.Close()
+
+	var uf UserFile
+	err := db.Where("user_id = ? AND file_id IN (?) AND resume_position > 0", userID, ids).
+		Order("last_played desc").First(&uf).Error
+	if err != nil {
+		return File{}, 0, false
+	}
+	for _, f := range files {
+		if f.ID == uf.FileID {
+			return f, uf.ResumePosition, true
+		}
+	}
+	return File{}, 0, false
+}
+
+func GetUserScene(userID uint, sceneID uint) UserScene {
+	db, _ := GetDB()
+	defer db.Close()
+
+	var user User
+	db.Where("id = ?", userID).First(&user)
+	if user.IsDefault {
+		var scene Scene
+		db.Select("id, star_rating, favourite, watchlist, is_watched").Where("id = ?", sceneID).First(&scene)
+		return UserScene{UserID: userID, SceneID: sceneID, StarRating: scene.StarRating, Favourite: scene.Favourite,
+			Watchlist: scene.Watchlist, IsWatched: scene.IsWatched}
+	}
+
+	var us UserScene
+	db.Where("user_id = ? AND scene_id = ?", userID, sceneID).First(&us)
+	return us
+}
+
+// SetUserState changes star_rating, favourite, watchlist or is_watched of the scene for a user. For the default
+// user the scene columns are updated too, and so is o, so a later Save keeps the change.
+func (o *Scene) SetUserState(userID uint, column string, value interface{}) error {
+	if !userSceneColumns[column] {
+		return fmt.Errorf("%v is not a user state", column)
+	}
+
+	db, _ := GetDB()
+	defer db.Close()
+
+	var us UserScene
+	if err := db.Where(UserScene{UserID: userID, SceneID: o.ID}).FirstOrCreate(&us).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&us).UpdateColumn(column, value).Error; err != nil {
+		return err
+	}
+
+	var user User
+	if db.Where("id = ?", userID).First(&user).Error != nil || !user.IsDefault {
+		return nil
+	}
+	if err := db.Model(&Scene{}).Where("id = ?", o.ID).UpdateColumn(column, value).Error; err != nil {
+		return err
+	}
+	switch column {
+	case "star_rating":
+		o.StarRating, _ = value.(float64)
+	case "favourite":
+		o.Favourite, _ = value.(bool)
+	case "watchlist":
+		o.Watchlist, _ = value.(bool)
+	case "is_watched":
+		o.IsWatched, _ = value.(bool)
+	}
+	return nil
+}
+
+// ApplyUser shows the scene as a user sees it. It's meant for responses, the scene must not be saved afterwards.
+func (o *Scene) ApplyUser(userID uint) {
+	scenes := []Scene{*o}
+	ApplyUserToScenes(userID, scenes)
+	*o = scenes[0]
+}
+
+// ApplyUserToScenes sets the rating, lists and watched state of the user and keeps only the user's history.
+// The default user sees the state of the scene itself. History from before accounts existed without a user
+// belongs to the default user.
+func ApplyUserToScenes(userID uint, scenes []Scene) {
+	if len(scenes) == 0 {
+		return
+	}
+
+	db, _ := GetDB()
+	defer db.Close()
+
+	var user User
+	db.Where("id = ?", userID).First(&user)
+
+	ids := make([]uint, len(scenes))
+	for i := range scenes {
+		ids[i] = scenes[i].ID
+	}
+	var rows []UserScene
+	db.Where("user_id = ? AND scene_id IN (?)", userID, ids).Find(&rows)
+	state := map[uint]UserScene{}
+	for _, us := range rows {
+		state[us.SceneID] = us
+	}
+
+	for i := range scenes {
+		if !user.IsDefault {
+			us := state[scenes[i].ID]
+			scenes[i].StarRating = us.StarRating
+			scenes[i].Favourite = us.Favourite
+			scenes[i].Watchlist = us.Watchlist
+			scenes[i].IsWatched = us.IsWatched
+		}
+
+		history := []History{}
+		for _, h := range scenes[i].History {
+			if h.UserID == userID || (h.UserID == 0 && user.IsDefault) {
+				history = append(history, h)
+			}
+		}
+		scenes[i].History = history
+	}
+}
+
+// MergeUserScenes moves the user state of dup onto keep, combining it where a user has state on both
+func MergeUserScenes(db *gorm.DB, keepID uint, dupID uint) error {
+	var rows []UserScene
+	if err := db.Where("scene_id = ?", dupID).Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, dup := range rows {
+		var keep UserScene
+		if db.Where("user_id = ? AND scene_id = ?", dup.UserID, keepID).First(&keep).RecordNotFound() {
+			if err := db.Model(&dup).UpdateColumn("scene_id", keepID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if dup.StarRating > keep.StarRating {
+			keep.StarRating = dup.StarRating
+		}
+		err := db.Model(&keep).UpdateColumns(map[string]interface{}{
+			"star_rating": keep.StarRating,
+			"favourite":   keep.Favourite || dup.Favourite,
+			"watchlist":   keep.Watchlist || dup.Watchlist,
+			"is_watched":  keep.IsWatched || dup.IsWatched,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := db.Delete(&dup).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userSceneColumn is the SQL for a user state column of the scene in scene queries, the default user's state is
+// the column of the scene
+func userSceneColumn(user User, column string) string {
+	if user.IsDefault {
+		return "scenes." + column
+	}
+	return fmt.Sprintf("coalesce((select user_scenes.%v from user_scenes where user_scenes.scene_id = scenes.id and user_scenes.user_id = %d), 0)", column, user.ID)
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResumePosition(t *testing.T) {
+	cases := []struct {
+		position float64
+		duration float64
+		want     float64
+	}{
+		{5, 600, 0},
+		{120, 600, 120},
+		{580, 600, 0},
+		{3500, 3600, 0},
+		{120, 0, 120},
+	}
+	for _, c := range cases {
+		if got := resumePosition(c.position, c.duration); got != c.want {
+			t.Fatalf("%v of %v: expected %v, got %v", c.position, c.duration, c.want, got)
+		}
+	}
+}
+
+func TestUserSceneColumn(t *testing.T) {
+	// Bundle restores and older code write the scene columns, those hold the state of the default user
+	if got := userSceneColumn(User{ID: 1, IsDefault: true}, "favourite"); got != "scenes.favourite" {
+		t.Fatalf("expected the scene column for the default user, got %v", got)
+	}
+	got := userSceneColumn(User{ID: 2}, "favourite")
+	if !strings.Contains(got, "user_scenes.favourite") || !strings.Contains(got, "user_scenes.user_id = 2") {
+		t.Fatalf("expected the user's own state for other users, got %v", got)
+	}
+}
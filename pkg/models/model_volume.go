@@ -2,12 +2,15 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/putdotio/go-putio"
+	"github.com/xbapps/xbvr/pkg/storage"
 	"golang.org/x/oauth2"
 )
 
@@ -16,15 +19,59 @@ type Volume struct {
 	CreatedAt time.Time `json:"-" xbvrbackup:"-"`
 	UpdatedAt time.Time `json:"-" xbvrbackup:"-"`
 
-	Type           string    `json:"type" xbvrbackup:""`
-	Path           string    `json:"path" xbvrbackup:""`
-	Metadata       string    `json:"metadata" xbvrbackup:""`
-	LastScan       time.Time `json:"last_scan" xbvrbackup:""`
-	IsEnabled      bool      `json:"-" xbvrbackup:""`
-	IsAvailable    bool      `json:"is_available" xbvrbackup:"-"`
-	FileCount      int       `gorm:"-" json:"file_count" xbvrbackup:"-"`
-	UnmatchedCount int       `gorm:"-" json:"unmatched_count" xbvrbackup:"-"`
-	TotalSize      int64     `gorm:"-" json:"total_size" xbvrbackup:"-"`
+	Type            string          `json:"type" xbvrbackup:""`
+	Path            string          `json:"path" xbvrbackup:""`
+	Metadata        string          `json:"metadata" xbvrbackup:""`
+	LastScan        time.Time       `json:"last_scan" xbvrbackup:""`
+	IsEnabled       bool            `json:"-" xbvrbackup:""`
+	IsAvailable     bool            `json:"is_available" xbvrbackup:"-"`
+	ScanConcurrency int             `json:"scan_concurrency" xbvrbackup:""`
+	Identity        string          `json:"identity" xbvrbackup:""`
+	OfflineSince    time.Time       `json:"offline_since" xbvrbackup:"-"`
+	ScanRules       string          `json:"-" sql:"type:text;" xbvrbackup:""`
+	ContentType     string          `json:"content_type" xbvrbackup:""`
+	Rules           VolumeScanRules `gorm:"-" json:"scan_rules" xbvrbackup:"-"`
+	FileCount       int             `gorm:"-" json:"file_count" xbvrbackup:"-"`
+	UnmatchedCount  int             `gorm:"-" json:"unmatched_count" xbvrbackup:"-"`
+	TotalSize       int64           `gorm:"-" json:"total_size" xbvrbackup:"-"`
+}
+
+// Content types of a volume, they decide which matcher runs on its files. Volumes without one go through both.
+const (
+	VolumeContentVR   = "vr"
+	VolumeContentPMV  = "pmv"
+	VolumeContentFlat = "flat"
+)
+
+// VolumeScanRules limits what a scan picks up from a volume. Globs are matched against paths relative to the
+// volume, a glob without a slash matches any file or folder name.
+type VolumeScanRules struct {
+	Include     []string `json:"include"`
+	Exclude     []string `json:"exclude"`
+	VideoExt    []string `json:"video_ext"`
+	MinSize     int64    `json:"min_size"`
+	MinDuration float64  `json:"min_duration"`
+}
+
+func (o *Volume) GetScanRules() VolumeScanRules {
+	var rules VolumeScanRules
+	if o.ScanRules != "" {
+		json.Unmarshal([]byte(o.ScanRules), &rules)
+	}
+	return rules
+}
+
+func (o *Volume) SetScanRules(rules VolumeScanRules) error {
+	if len(rules.Include) == 0 && len(rules.Exclude) == 0 && len(rules.VideoExt) == 0 && rules.MinSize <= 0 && rules.MinDuration <= 0 {
+		o.ScanRules = ""
+		return nil
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	o.ScanRules = string(data)
+	return nil
 }
 
 func IsDirectoryEmpty(name string) (bool, error) {
@@ -47,17 +94,93 @@ func (o *Volume) IsMounted() bool {
 		if _, err := os.Stat(o.Path); os.IsNotExist(err) {
 			return false
 		}
+		// The marker tells the volume apart from an empty mount point or another drive mounted at the same path
+		if o.Identity != "" {
+			return readVolumeMarker(o.Path) == o.Identity
+		}
 		if isEmpty, err := IsDirectoryEmpty(o.Path); isEmpty || err != nil {
 			return false
 		}
 		return true
 	case "putio":
 		return true
+	case storage.TypeSFTP, storage.TypeWebDAV, storage.TypeS3:
+		backend, err := o.GetBackend()
+		if err != nil {
+			return false
+		}
+		cfg, _ := storage.ParseConfig(o.Metadata)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		_, err = backend.Stat(ctx, storage.RootPath(cfg))
+		return err == nil
 	default:
 		return false
 	}
 }
 
+func (o *Volume) IsRemote() bool {
+	return storage.IsRemoteType(o.Type)
+}
+
+var (
+	volumeBackendsMutex sync.Mutex
+	volumeBackends      = map[uint]cachedBackend{}
+)
+
+type cachedBackend struct {
+	metadata string
+	backend  storage.Backend
+}
+
+func CloseVolumeBackend(id uint) {
+	volumeBackendsMutex.Lock()
+	defer volumeBackendsMutex.Unlock()
+
+	if cached, ok := volumeBackends[id]; ok {
+		cached.backend.Close()
+		delete(volumeBackends, id)
+	}
+}
+
+// GetBackend returns the connection to a remote volume, it is shared by scans and streams and must not be closed
+func (o *Volume) GetBackend() (storage.Backend, error) {
+	volumeBackendsMutex.Lock()
+	defer volumeBackendsMutex.Unlock()
+
+	if cached, ok := volumeBackends[o.ID]; ok {
+		if cached.metadata == o.Metadata {
+			return cached.backend, nil
+		}
+		cached.backend.Close()
+		delete(volumeBackends, o.ID)
+	}
+
+	cfg, err := storage.ParseConfig(o.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if o.Type == storage.TypeSFTP && cfg.HostKey == "" {
+		// Volumes added before host keys were checked get theirs pinned on the next connect
+		if cfg.HostKey, err = storage.ScanSFTPHostKey(cfg); err != nil {
+			return nil, err
+		}
+		o.Metadata = cfg.JSON()
+		if o.ID != 0 {
+			db, _ := GetDB()
+			db.Model(o).Update("metadata", o.Metadata)
+			db.Close()
+		}
+		log.Infof("Pinned host key %v for %v", cfg.HostKey, o.Path)
+	}
+	backend, err := storage.New(o.Type, cfg)
+	if err != nil {
+		return nil, err
+	}
+	volumeBackends[o.ID] = cachedBackend{metadata: o.Metadata, backend: backend}
+	return backend, nil
+}
+
 func (o *Volume) Save() error {
 	db, _ := GetDB()
 	defer db.Close()
@@ -93,30 +216,70 @@ func (o *Volume) GetPutIOClient() *putio.Client {
 	return putio.NewClient(oauthClient)
 }
 
-func CheckVolumes() {
+// CheckVolumes refreshes the availability of all volumes and tells whether any of them changed
+func CheckVolumes() bool {
+	return checkVolumes(false)
+}
+
+// CheckLocalVolumes is CheckVolumes for local volumes only, it is cheap enough to run every minute
+func CheckLocalVolumes() bool {
+	return checkVolumes(true)
+}
+
+func checkVolumes(localOnly bool) bool {
 	db, _ := GetDB()
 	defer db.Close()
 
 	var vol []Volume
-	db.Find(&vol)
+	if localOnly {
+		db.Where("type = ?", "local").Find(&vol)
+	} else {
+		db.Find(&vol)
+	}
 
-	var files []File
+	changed := false
 	for i := range vol {
 		isMounted := vol[i].IsMounted()
+
+		// A drive that is gone may have come back at another mount point
+		if !isMounted && vol[i].Type == "local" && vol[i].Identity != "" {
+			if newPath := findVolumeByIdentity(vol[i]); newPath != "" {
+				oldPath := vol[i].Path
+				if err := vol[i].Repoint(db, newPath); err != nil {
+					log.Warnf("Failed to move volume %v to %v: %v", oldPath, newPath, err)
+				} else {
+					log.Infof("Volume %v is now mounted at %v", oldPath, newPath)
+					isMounted = true
+					changed = true
+				}
+			}
+		}
+
+		if isMounted && vol[i].Type == "local" && vol[i].Identity == "" {
+			vol[i].EnsureIdentity()
+		}
+
 		if isMounted != vol[i].IsAvailable {
-			vol[i].IsAvailable = vol[i].IsMounted()
+			changed = true
+			vol[i].IsAvailable = isMounted
+			if isMounted {
+				vol[i].OfflineSince = time.Time{}
+			} else {
+				vol[i].OfflineSince = time.Now()
+				log.Infof("Volume %v is offline, its files stay in the library", vol[i].Path)
+			}
 			vol[i].Save()
 
 			// update the status of any scene with a file on that volume
-			db.
-				Model(&files).
-				Where("volume_id = ?", vol[i].ID).
-				Find(&files)
-			for _, file := range files {
+			var sceneIDs []uint
+			db.Model(&File{}).Where("volume_id = ? AND scene_id <> 0", vol[i].ID).Pluck("DISTINCT scene_id", &sceneIDs)
+			for _, id := range sceneIDs {
 				var scene Scene
-				scene.GetIfExistByPK(file.SceneID)
-				scene.UpdateStatus()
+				if scene.GetIfExistByPK(id) == nil {
+					scene.UpdateStatus()
+				}
 			}
 		}
 	}
+	return changed
 }
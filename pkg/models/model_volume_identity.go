@@ -0,0 +1,161 @@
+package models
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Local volumes carry a marker file with their identity in the root folder. It keeps an unplugged drive apart
+// from the empty folder it was mounted on, and finds the drive again when it comes back at another path.
+const volumeMarkerFile = ".xbvr-volume"
+
+func readVolumeMarker(dir string) string {
+	content, err := os.ReadFile(filepath.Join(dir, volumeMarkerFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func newVolumeIdentity() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// EnsureIdentity gives a mounted local volume its identity, a marker already on the drive is adopted so a
+// volume that was removed and added again is recognised. Read-only volumes go without one.
+func (o *Volume) EnsureIdentity() {
+	if o.Type != "local" || o.Identity != "" {
+		return
+	}
+
+	identity := readVolumeMarker(o.Path)
+	if identity != "" {
+		db, _ := GetDB()
+		var count int
+		db.Model(&Volume{}).Where("identity = ? AND id <> ?", identity, o.ID).Count(&count)
+		db.Close()
+		if count > 0 {
+			return
+		}
+	} else {
+		identity = newVolumeIdentity()
+		if err := os.WriteFile(filepath.Join(o.Path, volumeMarkerFile), []byte(identity+"\n"), 0644); err != nil {
+			log.Debugf("Can't write volume marker to %v: %v", o.Path, err)
+			return
+		}
+	}
+
+	o.Identity = identity
+	o.Save()
+}
+
+// Repoint moves a local volume and the paths of its files to the folder it is mounted at now, the files
+// themselves are unchanged so no rescan is needed
+func (o *Volume) Repoint(db *gorm.DB, newPath string) error {
+	var count int
+	db.Model(&Volume{}).Where("path = ? AND id <> ?", newPath, o.ID).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("%v is already a volume", newPath)
+	}
+
+	var files []File
+	db.Select("id, path").Where("volume_id = ?", o.ID).Find(&files)
+
+	tx := db.Begin()
+	for _, f := range files {
+		p, ok := repointPath(f.Path, o.Path, newPath)
+		if !ok {
+			continue
+		}
+		if err := tx.Model(&File{}).Where("id = ?", f.ID).UpdateColumn("path", p).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Model(&Volume{}).Where("id = ?", o.ID).UpdateColumn("path", newPath).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	o.Path = newPath
+	return nil
+}
+
+func repointPath(p string, oldRoot string, newRoot string) (string, bool) {
+	if p == oldRoot {
+		return newRoot, true
+	}
+	if strings.HasPrefix(p, oldRoot+string(filepath.Separator)) {
+		return newRoot + p[len(oldRoot):], true
+	}
+	return "", false
+}
+
+// findVolumeByIdentity looks for the marker of a volume below the current mount points. The last folders of
+// the old path are kept, so a volume in a subfolder of a drive is found when the drive moves.
+func findVolumeByIdentity(vol Volume) string {
+	old := filepath.Clean(vol.Path)
+	parts := strings.Split(filepath.ToSlash(strings.TrimPrefix(old, filepath.VolumeName(old))), "/")
+	var names []string
+	for _, p := range parts {
+		if p != "" {
+			names = append(names, p)
+		}
+	}
+
+	for _, root := range mountRoots() {
+		for keep := 0; keep <= 3 && keep <= len(names); keep++ {
+			candidate := filepath.Join(append([]string{root}, names[len(names)-keep:]...)...)
+			if candidate == old {
+				continue
+			}
+			if readVolumeMarker(candidate) == vol.Identity {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// mountRoots lists the places removable drives and network shares show up on this platform
+func mountRoots() []string {
+	var roots []string
+	switch runtime.GOOS {
+	case "windows":
+		for c := 'A'; c <= 'Z'; c++ {
+			roots = append(roots, string(c)+":\\")
+		}
+	case "darwin":
+		roots, _ = filepath.Glob("/Volumes/*")
+	default:
+		if f, err := os.Open("/proc/mounts"); err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) > 1 && fields[1] != "/" && !strings.HasPrefix(fields[1], "/proc") && !strings.HasPrefix(fields[1], "/sys") && !strings.HasPrefix(fields[1], "/dev") {
+					roots = append(roots, strings.ReplaceAll(fields[1], "\\040", " "))
+				}
+			}
+			f.Close()
+		}
+		for _, pattern := range []string{"/media/*", "/media/*/*", "/mnt/*", "/run/media/*/*"} {
+			matches, _ := filepath.Glob(pattern)
+			roots = append(roots, matches...)
+		}
+	}
+	return roots
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeIsMounted(t *testing.T) {
+	dir := t.TempDir()
+
+	vol := Volume{Type: "local", Path: filepath.Join(dir, "missing")}
+	if vol.IsMounted() {
+		t.Fatal("expected a missing folder not to be mounted")
+	}
+
+	vol.Path = dir
+	if vol.IsMounted() {
+		t.Fatal("expected an empty mount point not to be mounted")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "scene.mp4"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !vol.IsMounted() {
+		t.Fatal("expected a folder with files to be mounted")
+	}
+
+	// Once a volume has an identity only its own marker counts, another drive with files is not it
+	vol.Identity = "aaaa"
+	if vol.IsMounted() {
+		t.Fatal("expected a volume without its marker not to be mounted")
+	}
+	if err := os.WriteFile(filepath.Join(dir, volumeMarkerFile), []byte("bbbb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if vol.IsMounted() {
+		t.Fatal("expected a volume with the marker of another drive not to be mounted")
+	}
+	if err := os.WriteFile(filepath.Join(dir, volumeMarkerFile), []byte("aaaa\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !vol.IsMounted() {
+		t.Fatal("expected a volume with its marker to be mounted")
+	}
+}
+
+func TestRepointPath(t *testing.T) {
+	sep := string(filepath.Separator)
+	oldRoot := sep + filepath.Join("media", "old")
+	newRoot := sep + filepath.Join("mnt", "new")
+
+	cases := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{oldRoot, newRoot, true},
+		{filepath.Join(oldRoot, "Scenes"), filepath.Join(newRoot, "Scenes"), true},
+		{filepath.Join(oldRoot, "a", "b"), filepath.Join(newRoot, "a", "b"), true},
+		// A sibling folder sharing the prefix is not below the root
+		{oldRoot + "er", "", false},
+		{sep + filepath.Join("media", "other"), "", false},
+	}
+	for _, c := range cases {
+		got, ok := repointPath(c.path, oldRoot, newRoot)
+		if got != c.want || ok != c.ok {
+			t.Fatalf("%s: expected %q %v, got %q %v", c.path, c.want, c.ok, got, ok)
+		}
+	}
+}
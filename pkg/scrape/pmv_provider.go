@@ -0,0 +1,103 @@
+package scrape
+
+import (
+	"strings"
+
+	"github.com/xbapps/xbvr/pkg/config"
+)
+
+// PMVCandidate is a scene found on a PMV source, Provider tells which one
+type PMVCandidate struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	SceneURL     string `json:"scene_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	PreviewURL   string `json:"preview_url,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+
+	PMVSceneMetadata
+}
+
+type PMVSceneMetadata struct {
+	Creator     string    `json:"creator,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Performers  []string  `json:"performers,omitempty"`
+	Songs       []PMVSong `json:"songs,omitempty"`
+	UploadDate  string    `json:"upload_date,omitempty"`
+	Duration    int       `json:"duration,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
+type PMVSong struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+}
+
+// PMVProvider is a compilation/PMV source the PMV matcher can search. Scenes of matches are created under the
+// scraper and site of the source, the same way its scraper creates them.
+type PMVProvider interface {
+	ID() string
+	ScraperID() string
+	Site() string
+	Search(query string, limit int) ([]PMVCandidate, error)
+	Enrich(c PMVCandidate) (PMVCandidate, error)
+	CandidateID(sceneURL string) string
+}
+
+var pmvProviders []PMVProvider
+
+func registerPMVProvider(p PMVProvider) {
+	pmvProviders = append(pmvProviders, p)
+}
+
+func GetPMVProviders() []PMVProvider {
+	return pmvProviders
+}
+
+// EnabledPMVProviders returns the registered providers not switched off in the PMV matching options
+func EnabledPMVProviders() []PMVProvider {
+	var out []PMVProvider
+	for _, p := range pmvProviders {
+		disabled := false
+		for _, id := range config.Config.PMVMatch.DisabledProviders {
+			if strings.EqualFold(strings.TrimSpace(id), p.ID()) {
+				disabled = true
+				break
+			}
+		}
+		if !disabled {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+type pmvHavenProvider struct{}
+
+func (p pmvHavenProvider) ID() string {
+	return "pmvhaven"
+}
+
+func (p pmvHavenProvider) ScraperID() string {
+	return "pmvhaven"
+}
+
+func (p pmvHavenProvider) Site() string {
+	return "PMVHaven"
+}
+
+func (p pmvHavenProvider) Search(query string, limit int) ([]PMVCandidate, error) {
+	return SearchPMVHaven(query, limit)
+}
+
+func (p pmvHavenProvider) Enrich(c PMVCandidate) (PMVCandidate, error) {
+	return EnrichPMVHavenCandidateThumbnail(c)
+}
+
+func (p pmvHavenProvider) CandidateID(sceneURL string) string {
+	return buildCandidateID(sceneURL)
+}
+
+func init() {
+	registerPMVProvider(pmvHavenProvider{})
+}
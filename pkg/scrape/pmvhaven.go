@@ -6,28 +6,28 @@ import (
 	"fmt"
 	"html"
 	"net/url"
-	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-resty/resty/v2"
+	"github.com/gocolly/colly/v2"
+	"github.com/thoas/go-funk"
 	"github.com/tidwall/gjson"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
 )
 
-const pmvHavenBaseURL = "https://pmvhaven.com"
-
-type PMVHavenCandidate struct {
-	ID           string `json:"id"`
-	Title        string `json:"title"`
-	SceneURL     string `json:"scene_url"`
-	ThumbnailURL string `json:"thumbnail_url"`
-}
+const (
+	pmvHavenBaseURL     = "https://pmvhaven.com"
+	pmvHavenMaxPages    = 200
+	pmvHavenListingSize = 100
+)
 
-func EnrichPMVHavenCandidateThumbnail(c PMVHavenCandidate) (PMVHavenCandidate, error) {
+func EnrichPMVHavenCandidateThumbnail(c PMVCandidate) (PMVCandidate, error) {
 	sceneURL := canonicalSceneURL(c.SceneURL)
 	if sceneURL == "" {
 		return c, fmt.Errorf("invalid scene url")
@@ -37,9 +37,12 @@ func EnrichPMVHavenCandidateThumbnail(c PMVHavenCandidate) (PMVHavenCandidate, e
 		SetTimeout(25*time.Second).
 		SetRetryCount(2).
 		SetHeader("User-Agent", UserAgent)
+	fixtures := applyScraperFixtures("pmvhaven-scraper", client)
 
 	req := client.R()
-	SetupRestyRequest("pmvhaven-scraper", req)
+	if fixtures.Mode != FixtureModeReplay {
+		SetupRestyRequest("pmvhaven-scraper", req)
+	}
 
 	resp, err := req.Get(sceneURL)
 	if err != nil {
@@ -55,9 +58,34 @@ func EnrichPMVHavenCandidateThumbnail(c PMVHavenCandidate) (PMVHavenCandidate, e
 	if title := ParsePMVHavenSceneHTMLForTitle(resp.String()); title != "" {
 		c.Title = title
 	}
+	if preview := ParsePMVHavenSceneHTMLForPreview(resp.String()); preview != "" {
+		c.PreviewURL = preview
+	}
+	c.PMVSceneMetadata = ParsePMVHavenSceneHTMLForMetadata(resp.String())
 	return c, nil
 }
 
+// Synopsis appends the song credits to the description, scenes have no dedicated soundtrack field
+func (m PMVSceneMetadata) Synopsis() string {
+	synopsis := strings.TrimSpace(m.Description)
+	if len(m.Songs) == 0 || strings.Contains(strings.ToLower(synopsis), strings.ToLower(m.Songs[0].Title)) {
+		return synopsis
+	}
+
+	lines := []string{"Music:"}
+	for _, song := range m.Songs {
+		if song.Artist == "" {
+			lines = append(lines, song.Title)
+			continue
+		}
+		lines = append(lines, song.Artist+" - "+song.Title)
+	}
+	if synopsis == "" {
+		return strings.Join(lines, "\n")
+	}
+	return synopsis + "\n\n" + strings.Join(lines, "\n")
+}
+
 func ParsePMVHavenSceneHTMLForThumbnail(htmlBody string) string {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
 	if err != nil {
@@ -87,6 +115,290 @@ func ParsePMVHavenSceneHTMLForThumbnail(htmlBody string) string {
 	return ""
 }
 
+func ParsePMVHavenSceneHTMLForPreview(htmlBody string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
+	if err != nil {
+		return ""
+	}
+
+	preview := strings.TrimSpace(firstNonEmpty(
+		attrVal(doc.Find(`meta[property="og:video"]`).First(), "content"),
+		attrVal(doc.Find(`meta[property="og:video:url"]`).First(), "content"),
+		attrVal(doc.Find(`meta[property="og:video:secure_url"]`).First(), "content"),
+		attrVal(doc.Find(`meta[name="twitter:player:stream"]`).First(), "content"),
+		attrVal(doc.Find(`video source[src]`).First(), "src"),
+		attrVal(doc.Find(`video[src]`).First(), "src"),
+	))
+	if preview != "" {
+		return absoluteURL(preview)
+	}
+
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, script *goquery.Selection) bool {
+		text := strings.TrimSpace(script.Text())
+		if text == "" {
+			return true
+		}
+		preview = strings.TrimSpace(firstNonEmpty(
+			gjson.Get(text, "contentUrl").String(),
+			gjson.Get(text, "embedUrl").String(),
+		))
+		return preview == ""
+	})
+	if preview != "" {
+		return absoluteURL(preview)
+	}
+	return ""
+}
+
+func ParsePMVHavenSceneHTMLForMetadata(htmlBody string) PMVSceneMetadata {
+	var meta PMVSceneMetadata
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
+	if err != nil {
+		return meta
+	}
+
+	// JSON-LD VideoObject is the most structured source, meta tags and page links fill the gaps
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, script *goquery.Selection) bool {
+		text := strings.TrimSpace(script.Text())
+		if text == "" {
+			return true
+		}
+		video := findJSONLDVideoObject(gjson.Parse(text))
+		if !video.Exists() {
+			return true
+		}
+
+		meta.Creator = firstNonEmpty(append(jsonLDStrings(video.Get("author")), jsonLDStrings(video.Get("creator"))...)...)
+		meta.UploadDate = parsePMVHavenDate(firstNonEmpty(video.Get("uploadDate").String(), video.Get("datePublished").String()))
+		meta.Duration = parsePMVHavenDuration(video.Get("duration").String())
+		meta.Description = strings.TrimSpace(video.Get("description").String())
+		meta.Tags = appendUniqueStrings(meta.Tags, jsonLDStrings(video.Get("keywords"))...)
+		meta.Tags = appendUniqueStrings(meta.Tags, jsonLDStrings(video.Get("genre"))...)
+		meta.Performers = appendUniqueStrings(meta.Performers, jsonLDStrings(video.Get("actor"))...)
+
+		for _, key := range []string{"audio", "track", "music"} {
+			songs := []gjson.Result{video.Get(key)}
+			if songs[0].IsArray() {
+				songs = songs[0].Array()
+			}
+			for _, song := range songs {
+				if !song.IsObject() {
+					continue
+				}
+				meta.Songs = appendPMVHavenSong(meta.Songs, PMVSong{
+					Artist: firstNonEmpty(append(jsonLDStrings(song.Get("byArtist")), song.Get("artist").String())...),
+					Title:  firstNonEmpty(song.Get("name").String(), song.Get("title").String()),
+				})
+			}
+		}
+		return false
+	})
+
+	meta.Creator = strings.TrimSpace(firstNonEmpty(
+		meta.Creator,
+		attrVal(doc.Find(`meta[name="author"]`).First(), "content"),
+		attrVal(doc.Find(`meta[property="video:creator"]`).First(), "content"),
+		strings.TrimSpace(doc.Find(`a[href*="/profile/"]`).First().Text()),
+	))
+	if meta.UploadDate == "" {
+		meta.UploadDate = parsePMVHavenDate(firstNonEmpty(
+			attrVal(doc.Find(`meta[property="video:release_date"]`).First(), "content"),
+			attrVal(doc.Find(`meta[property="article:published_time"]`).First(), "content"),
+			attrVal(doc.Find(`time[datetime]`).First(), "datetime"),
+		))
+	}
+	if meta.Duration == 0 {
+		meta.Duration = parsePMVHavenDuration(attrVal(doc.Find(`meta[property="video:duration"]`).First(), "content"))
+	}
+	if meta.Description == "" {
+		meta.Description = strings.TrimSpace(firstNonEmpty(
+			attrVal(doc.Find(`meta[property="og:description"]`).First(), "content"),
+			attrVal(doc.Find(`meta[name="description"]`).First(), "content"),
+		))
+	}
+
+	doc.Find(`meta[property="video:tag"], meta[property="article:tag"]`).Each(func(_ int, m *goquery.Selection) {
+		meta.Tags = appendUniqueStrings(meta.Tags, attrVal(m, "content"))
+	})
+	doc.Find(`a[href*="/tag/"], a[href*="/tags/"]`).Each(func(_ int, a *goquery.Selection) {
+		meta.Tags = appendUniqueStrings(meta.Tags, strings.TrimPrefix(strings.TrimSpace(a.Text()), "#"))
+	})
+	doc.Find(`meta[property="video:actor"]`).Each(func(_ int, m *goquery.Selection) {
+		meta.Performers = appendUniqueStrings(meta.Performers, attrVal(m, "content"))
+	})
+	doc.Find(`a[href*="/star/"], a[href*="/stars/"]`).Each(func(_ int, a *goquery.Selection) {
+		meta.Performers = appendUniqueStrings(meta.Performers, strings.TrimSpace(a.Text()))
+	})
+	doc.Find(`a[href*="/music/"]`).Each(func(_ int, a *goquery.Selection) {
+		meta.Songs = appendPMVHavenSong(meta.Songs, parsePMVHavenSongCredit(a.Text()))
+	})
+	if len(meta.Songs) == 0 {
+		for _, song := range parsePMVHavenSongsFromDescription(meta.Description) {
+			meta.Songs = appendPMVHavenSong(meta.Songs, song)
+		}
+	}
+	return meta
+}
+
+func findJSONLDVideoObject(node gjson.Result) gjson.Result {
+	if node.IsArray() {
+		for _, child := range node.Array() {
+			if found := findJSONLDVideoObject(child); found.Exists() {
+				return found
+			}
+		}
+		return gjson.Result{}
+	}
+	if !node.IsObject() {
+		return gjson.Result{}
+	}
+	if strings.EqualFold(node.Get("@type").String(), "VideoObject") {
+		return node
+	}
+	if graph := node.Get("@graph"); graph.Exists() {
+		return findJSONLDVideoObject(graph)
+	}
+	return gjson.Result{}
+}
+
+func jsonLDStrings(node gjson.Result) []string {
+	var out []string
+	switch {
+	case node.IsArray():
+		for _, child := range node.Array() {
+			out = append(out, jsonLDStrings(child)...)
+		}
+	case node.IsObject():
+		out = append(out, node.Get("name").String())
+	case node.Exists():
+		out = append(out, strings.Split(node.String(), ",")...)
+	}
+	return out
+}
+
+func appendUniqueStrings(list []string, values ...string) []string {
+	for _, v := range values {
+		v = strings.TrimSpace(html.UnescapeString(v))
+		if v == "" {
+			continue
+		}
+		exists := false
+		for _, existing := range list {
+			if strings.EqualFold(existing, v) {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func appendPMVHavenSong(list []PMVSong, song PMVSong) []PMVSong {
+	song.Artist = strings.TrimSpace(song.Artist)
+	song.Title = strings.TrimSpace(song.Title)
+	if song.Title == "" {
+		return list
+	}
+	for _, existing := range list {
+		if strings.EqualFold(existing.Artist, song.Artist) && strings.EqualFold(existing.Title, song.Title) {
+			return list
+		}
+	}
+	return append(list, song)
+}
+
+// parsePMVHavenSongCredit splits the usual "Artist - Title" credit format
+func parsePMVHavenSongCredit(raw string) PMVSong {
+	raw = strings.TrimSpace(regexp.MustCompile(`^(\d+[.)]|[-*•])\s*`).ReplaceAllString(strings.TrimSpace(raw), ""))
+	for _, sep := range []string{" - ", " – ", " — ", " by "} {
+		if idx := strings.Index(raw, sep); idx > 0 {
+			if sep == " by " {
+				return PMVSong{Artist: raw[idx+len(sep):], Title: raw[:idx]}
+			}
+			return PMVSong{Artist: raw[:idx], Title: raw[idx+len(sep):]}
+		}
+	}
+	return PMVSong{Title: raw}
+}
+
+func parsePMVHavenSongsFromDescription(description string) []PMVSong {
+	var songs []PMVSong
+	header := regexp.MustCompile(`(?i)^(music|songs?|tracks?|tracklist|audio)\s*:\s*(.*)$`)
+	inList := false
+	for _, line := range strings.Split(description, "\n") {
+		line = strings.TrimSpace(line)
+		if m := header.FindStringSubmatch(line); len(m) == 3 {
+			inList = true
+			for _, part := range strings.Split(m[2], ",") {
+				songs = appendPMVHavenSong(songs, parsePMVHavenSongCredit(part))
+			}
+			continue
+		}
+		if !inList {
+			continue
+		}
+		if line == "" {
+			if len(songs) > 0 {
+				break
+			}
+			continue
+		}
+		songs = appendPMVHavenSong(songs, parsePMVHavenSongCredit(line))
+	}
+	return songs
+}
+
+func parsePMVHavenDate(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", "January 2, 2006", "Jan 2, 2006"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	if len(raw) >= 10 {
+		if t, err := time.Parse("2006-01-02", raw[:10]); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// parsePMVHavenDuration returns seconds from an ISO 8601 duration (PT3M25S), a clock value (3:25) or plain seconds
+func parsePMVHavenDuration(raw string) int {
+	raw = strings.TrimSpace(strings.ToUpper(raw))
+	if raw == "" {
+		return 0
+	}
+	if m := regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`).FindStringSubmatch(raw); len(m) == 5 {
+		days, _ := strconv.Atoi(m[1])
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		seconds, _ := strconv.ParseFloat(m[4], 64)
+		return days*86400 + hours*3600 + minutes*60 + int(seconds)
+	}
+	if strings.Contains(raw, ":") {
+		total := 0
+		for _, part := range strings.Split(raw, ":") {
+			v, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return 0
+			}
+			total = total*60 + v
+		}
+		return total
+	}
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		return int(seconds)
+	}
+	return 0
+}
+
 func ParsePMVHavenSceneHTMLForTitle(htmlBody string) string {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
 	if err != nil {
@@ -103,7 +415,7 @@ func ParsePMVHavenSceneHTMLForTitle(htmlBody string) string {
 	return cleanPMVHavenTitle(title)
 }
 
-func SearchPMVHaven(query string, limit int) ([]PMVHavenCandidate, error) {
+func SearchPMVHaven(query string, limit int) ([]PMVCandidate, error) {
 	q := url.QueryEscape(strings.TrimSpace(query))
 	searchURLs := []string{
 		fmt.Sprintf("%s/search?q=%s", pmvHavenBaseURL, q),
@@ -114,14 +426,17 @@ func SearchPMVHaven(query string, limit int) ([]PMVHavenCandidate, error) {
 		SetTimeout(25*time.Second).
 		SetRetryCount(2).
 		SetHeader("User-Agent", UserAgent)
+	fixtures := applyScraperFixtures("pmvhaven-scraper", client)
 
 	var lastErr error
 	seen := map[string]bool{}
-	allCandidates := make([]PMVHavenCandidate, 0, limit)
+	allCandidates := make([]PMVCandidate, 0, limit)
 	for idx, searchURL := range searchURLs {
 		tlog.Infof("call #%d query=%q url=%s", idx+1, query, searchURL)
 		req := client.R()
-		SetupRestyRequest("pmvhaven-scraper", req)
+		if fixtures.Mode != FixtureModeReplay {
+			SetupRestyRequest("pmvhaven-scraper", req)
+		}
 
 		resp, err := req.Get(searchURL)
 		if err != nil {
@@ -130,11 +445,6 @@ func SearchPMVHaven(query string, limit int) ([]PMVHavenCandidate, error) {
 			continue
 		}
 		tlog.Infof("call #%d response status=%d bytes=%d url=%s", idx+1, resp.StatusCode(), len(resp.String()), searchURL)
-		if dumpPath, dumpErr := dumpPMVHavenHTML(query, idx+1, searchURL, resp.String()); dumpErr != nil {
-			tlog.Warnf("call #%d html dump failed url=%s err=%v", idx+1, searchURL, dumpErr)
-		} else {
-			tlog.Infof("call #%d html dump file=%s", idx+1, dumpPath)
-		}
 		if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
 			lastErr = fmt.Errorf("pmvhaven search failed with status %d", resp.StatusCode())
 			continue
@@ -169,28 +479,7 @@ func SearchPMVHaven(query string, limit int) ([]PMVHavenCandidate, error) {
 		return nil, lastErr
 	}
 	tlog.Infof("no candidates query=%q", query)
-	return []PMVHavenCandidate{}, nil
-}
-
-func dumpPMVHavenHTML(query string, callNum int, callURL string, body string) (string, error) {
-	if strings.TrimSpace(body) == "" {
-		return "", fmt.Errorf("empty body")
-	}
-	dir := ".tmp_pmv_debug"
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", err
-	}
-	kind := "search"
-	if strings.Contains(callURL, "/?s=") {
-		kind = "s-param"
-	}
-	stamp := time.Now().Format("20060102_150405")
-	name := fmt.Sprintf("%s_%02d_%s_%s.html", stamp, callNum, kind, slugForFilename(query))
-	fullPath := filepath.Join(dir, name)
-	if err := os.WriteFile(fullPath, []byte(body), 0644); err != nil {
-		return "", err
-	}
-	return fullPath, nil
+	return []PMVCandidate{}, nil
 }
 
 func slugForFilename(s string) string {
@@ -206,19 +495,19 @@ func slugForFilename(s string) string {
 	return s
 }
 
-func ParsePMVHavenSearchHTML(htmlBody string, limit int) []PMVHavenCandidate {
+func ParsePMVHavenSearchHTML(htmlBody string, limit int) []PMVCandidate {
 	if limit <= 0 {
 		limit = 5
 	}
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlBody))
 	if err != nil {
-		return []PMVHavenCandidate{}
+		return []PMVCandidate{}
 	}
 
 	seen := map[string]bool{}
-	out := make([]PMVHavenCandidate, 0, limit)
-	addCandidate := func(c PMVHavenCandidate) bool {
+	out := make([]PMVCandidate, 0, limit)
+	addCandidate := func(c PMVCandidate) bool {
 		c.SceneURL = canonicalSceneURL(c.SceneURL)
 		c.ThumbnailURL = absoluteURL(c.ThumbnailURL)
 		c.Title = strings.TrimSpace(c.Title)
@@ -268,7 +557,7 @@ func ParsePMVHavenSearchHTML(htmlBody string, limit int) []PMVHavenCandidate {
 			thumbnailURL = strings.TrimSpace(strings.Split(thumbnailURL, " ")[0])
 		}
 
-		c := PMVHavenCandidate{
+		c := PMVCandidate{
 			ID:           buildCandidateID(sceneURL),
 			Title:        title,
 			SceneURL:     sceneURL,
@@ -293,8 +582,7 @@ func ParsePMVHavenSearchHTML(htmlBody string, limit int) []PMVHavenCandidate {
 
 		img := a.Find("img").First()
 		if img.Length() == 0 {
-			// Fallback: some layouts place image in adjacent wrappers.
-			img = a.Parent().Find("img").First()
+			img = cardImage(a)
 		}
 
 		if title == "" && img.Length() > 0 {
@@ -317,7 +605,7 @@ func ParsePMVHavenSearchHTML(htmlBody string, limit int) []PMVHavenCandidate {
 			))
 		}
 
-		c := PMVHavenCandidate{
+		c := PMVCandidate{
 			ID:           buildCandidateID(sceneURL),
 			Title:        title,
 			SceneURL:     sceneURL,
@@ -373,8 +661,28 @@ func ParsePMVHavenSearchHTML(htmlBody string, limit int) []PMVHavenCandidate {
 	return out
 }
 
-func parseJSONLDCandidates(data string) []PMVHavenCandidate {
-	out := []PMVHavenCandidate{}
+// cardImage finds the thumbnail of a scene link whose image isn't nested in it, eg a title link next to the
+// image. It walks up to the card wrapping the link, stopping before a wrapper that also holds other scenes.
+func cardImage(a *goquery.Selection) *goquery.Selection {
+	sceneURL := canonicalSceneURL(attrVal(a, "href"))
+	for p := a.Parent(); p.Length() > 0 && !p.Is("body"); p = p.Parent() {
+		otherScene := false
+		p.Find(`a[href*="/video/"]`).EachWithBreak(func(_ int, link *goquery.Selection) bool {
+			otherScene = canonicalSceneURL(attrVal(link, "href")) != sceneURL
+			return !otherScene
+		})
+		if otherScene {
+			break
+		}
+		if img := p.Find("img").First(); img.Length() > 0 {
+			return img
+		}
+	}
+	return &goquery.Selection{}
+}
+
+func parseJSONLDCandidates(data string) []PMVCandidate {
+	out := []PMVCandidate{}
 	seen := map[string]bool{}
 
 	appendCandidate := func(title, sceneURL, thumbnailURL string) {
@@ -383,7 +691,7 @@ func parseJSONLDCandidates(data string) []PMVHavenCandidate {
 			return
 		}
 		seen[sceneURL] = true
-		out = append(out, PMVHavenCandidate{
+		out = append(out, PMVCandidate{
 			ID:           buildCandidateID(sceneURL),
 			Title:        strings.TrimSpace(title),
 			SceneURL:     sceneURL,
@@ -645,3 +953,120 @@ func cleanPMVHavenTitle(raw string) string {
 	}
 	return title
 }
+
+func PMVHaven(wg *models.ScrapeWG, updateSite bool, knownScenes []string, out chan<- models.ScrapedScene, singleSceneURL string, scraperID string, siteID string, company string, listURL string, limitScraping bool) error {
+	defer wg.Done()
+	logScrapeStart(scraperID, siteID)
+
+	sceneCollector := createCollector("pmvhaven.com")
+	siteCollector := createCollector("pmvhaven.com")
+	page := 1
+
+	sceneCollector.OnResponse(func(r *colly.Response) {
+		sc := pmvHavenScrapedScene(string(r.Body), r.Request.URL.String(), scraperID, siteID, company)
+		if sc.Title == "" {
+			log.Warnf("Unable to process %s - no title", r.Request.URL)
+			return
+		}
+		out <- sc
+	})
+
+	siteCollector.OnResponse(func(r *colly.Response) {
+		candidates := ParsePMVHavenSearchHTML(string(r.Body), pmvHavenListingSize)
+		newScenes := 0
+		for _, c := range candidates {
+			// If scene exist in database, there's no need to scrape
+			if funk.ContainsString(knownScenes, c.SceneURL) {
+				continue
+			}
+			newScenes++
+			WaitBeforeVisit("pmvhaven.com", sceneCollector.Visit, c.SceneURL)
+		}
+
+		// listings are newest first, once a whole page is already known the rest will be too
+		if newScenes > 0 && !limitScraping && page < pmvHavenMaxPages {
+			page++
+			WaitBeforeVisit("pmvhaven.com", siteCollector.Visit, pmvHavenPageURL(listURL, page))
+		}
+	})
+
+	if singleSceneURL != "" {
+		sceneCollector.Visit(canonicalSceneURL(singleSceneURL))
+	} else {
+		WaitBeforeVisit("pmvhaven.com", siteCollector.Visit, pmvHavenPageURL(listURL, page))
+	}
+
+	if updateSite {
+		updateSiteLastUpdate(scraperID)
+	}
+	logScrapeFinished(scraperID, siteID)
+	return nil
+}
+
+func pmvHavenScrapedScene(body string, sceneURL string, scraperID string, siteID string, company string) models.ScrapedScene {
+	meta := ParsePMVHavenSceneHTMLForMetadata(body)
+
+	sc := models.ScrapedScene{}
+	sc.ScraperID = scraperID
+	sc.SceneType = "2D"
+	sc.Site = siteID
+	sc.HomepageURL = canonicalSceneURL(sceneURL)
+	sc.MembersUrl = sc.HomepageURL
+	sc.SiteID = buildCandidateID(sc.HomepageURL)
+	sc.SceneID = "pmvhaven-" + sc.SiteID
+	sc.Title = ParsePMVHavenSceneHTMLForTitle(body)
+
+	sc.Studio = firstNonEmpty(meta.Creator, company, "PMVHaven")
+	sc.Released = meta.UploadDate
+	sc.Duration = meta.Duration / 60
+	sc.Synopsis = meta.Synopsis()
+	sc.Tags = meta.Tags
+
+	sc.ActorDetails = make(map[string]models.ActorDetails)
+	for _, performer := range meta.Performers {
+		sc.Cast = append(sc.Cast, performer)
+		sc.ActorDetails[performer] = models.ActorDetails{Source: scraperID + " scrape"}
+	}
+
+	if thumb := ParsePMVHavenSceneHTMLForThumbnail(body); thumb != "" {
+		sc.Covers = append(sc.Covers, thumb)
+	}
+	if preview := ParsePMVHavenSceneHTMLForPreview(body); preview != "" {
+		sc.TrailerType = "url"
+		sc.TrailerSrc = preview
+	}
+	return sc
+}
+
+func pmvHavenPageURL(listURL string, page int) string {
+	u, err := url.Parse(absoluteURL(listURL))
+	if err != nil {
+		return listURL
+	}
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func addPMVHavenScraper(id string, name string, company string, avatarURL string, siteURL string) {
+	if avatarURL == "" {
+		avatarURL = pmvHavenBaseURL + "/favicon.ico"
+	}
+	registerScraper(id, name+" (PMVHaven)", avatarURL, "pmvhaven.com", func(wg *models.ScrapeWG, updateSite bool, knownScenes []string, out chan<- models.ScrapedScene, singleSceneURL string, singeScrapeAdditionalInfo string, limitScraping bool) error {
+		return PMVHaven(wg, updateSite, knownScenes, out, singleSceneURL, id, name, company, siteURL, limitScraping)
+	})
+}
+
+func init() {
+	registerScraper("pmvhaven", "PMVHaven", pmvHavenBaseURL+"/favicon.ico", "pmvhaven.com", func(wg *models.ScrapeWG, updateSite bool, knownScenes []string, out chan<- models.ScrapedScene, singleSceneURL string, singeScrapeAdditionalInfo string, limitScraping bool) error {
+		return PMVHaven(wg, updateSite, knownScenes, out, singleSceneURL, "pmvhaven", "PMVHaven", "", pmvHavenBaseURL+"/browse?sort=newest", limitScraping)
+	})
+
+	// followed creators are added as custom sites with their profile page as the url
+	var scrapers config.ScraperList
+	scrapers.Load()
+	for _, scraper := range scrapers.CustomScrapers.PmvhavenScrapers {
+		addPMVHavenScraper(scraper.ID, scraper.Name, scraper.Company, scraper.AvatarUrl, scraper.URL)
+	}
+}
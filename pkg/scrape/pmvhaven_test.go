@@ -1,6 +1,14 @@
 package scrape
 
-import "testing"
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestParsePMVHavenSearchHTML_ArticleCards(t *testing.T) {
 	html := `
@@ -162,3 +170,280 @@ func TestParsePMVHavenSceneHTMLForTitle_TitleTagFallback(t *testing.T) {
 		t.Fatalf("expected %q, got %q", want, got)
 	}
 }
+
+func TestParsePMVHavenSceneHTMLForPreview_Meta(t *testing.T) {
+	html := `
+	<html><head>
+	  <meta property="og:video" content="https://video.pmvhaven.com/previews/scene_preview.mp4" />
+	</head><body>
+	  <video><source src="/videos/full.mp4" /></video>
+	</body></html>`
+
+	got := ParsePMVHavenSceneHTMLForPreview(html)
+	want := "https://video.pmvhaven.com/previews/scene_preview.mp4"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParsePMVHavenSceneHTMLForPreview_VideoSourceFallback(t *testing.T) {
+	html := `
+	<html><body>
+	  <video poster="/poster.webp"><source src="/videos/preview.mp4" type="video/mp4" /></video>
+	</body></html>`
+
+	got := ParsePMVHavenSceneHTMLForPreview(html)
+	want := "https://pmvhaven.com/videos/preview.mp4"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParsePMVHavenSceneHTMLForMetadata_JSONLD(t *testing.T) {
+	html := `
+	<html><head>
+	  <script type="application/ld+json">{
+	    "@context": "https://schema.org",
+	    "@type": "VideoObject",
+	    "name": "Neon Nights PMV",
+	    "author": {"@type": "Person", "name": "Arckom"},
+	    "uploadDate": "2024-03-09T18:22:10Z",
+	    "duration": "PT4M12S",
+	    "description": "A neon themed compilation.",
+	    "keywords": "Compilation, Neon,Music Video",
+	    "actor": [{"@type": "Person", "name": "Performer One"}, {"@type": "Person", "name": "Performer Two"}],
+	    "audio": [{"@type": "MusicRecording", "name": "Midnight City", "byArtist": {"@type": "MusicGroup", "name": "M83"}}]
+	  }</script>
+	</head><body>
+	  <a href="/tags/neon">#neon</a>
+	  <a href="/tags/hypno">#hypno</a>
+	</body></html>`
+
+	got := ParsePMVHavenSceneHTMLForMetadata(html)
+	if got.Creator != "Arckom" {
+		t.Fatalf("expected creator Arckom, got %q", got.Creator)
+	}
+	if got.UploadDate != "2024-03-09" {
+		t.Fatalf("expected upload date 2024-03-09, got %q", got.UploadDate)
+	}
+	if got.Duration != 252 {
+		t.Fatalf("expected duration 252, got %d", got.Duration)
+	}
+	if got.Description != "A neon themed compilation." {
+		t.Fatalf("unexpected description %q", got.Description)
+	}
+	wantTags := []string{"Compilation", "Neon", "Music Video", "hypno"}
+	if strings.Join(got.Tags, "|") != strings.Join(wantTags, "|") {
+		t.Fatalf("expected tags %v, got %v", wantTags, got.Tags)
+	}
+	if len(got.Performers) != 2 || got.Performers[1] != "Performer Two" {
+		t.Fatalf("unexpected performers %v", got.Performers)
+	}
+	if len(got.Songs) != 1 || got.Songs[0].Artist != "M83" || got.Songs[0].Title != "Midnight City" {
+		t.Fatalf("unexpected songs %v", got.Songs)
+	}
+}
+
+func TestParsePMVHavenSceneHTMLForMetadata_MetaAndDescriptionFallback(t *testing.T) {
+	html := `
+	<html><head>
+	  <meta name="author" content="PMV Maker" />
+	  <meta property="video:duration" content="185" />
+	  <meta property="video:release_date" content="2023-11-02" />
+	  <meta property="video:tag" content="Rhythm" />
+	  <meta property="video:actor" content="Performer Three" />
+	  <meta property="og:description" content="Edited to the beat.
+Music:
+1. Daft Punk - One More Time
+2. Justice - D.A.N.C.E.
+
+Thanks for watching" />
+	</head><body></body></html>`
+
+	got := ParsePMVHavenSceneHTMLForMetadata(html)
+	if got.Creator != "PMV Maker" || got.Duration != 185 || got.UploadDate != "2023-11-02" {
+		t.Fatalf("unexpected metadata %+v", got)
+	}
+	if len(got.Tags) != 1 || got.Tags[0] != "Rhythm" {
+		t.Fatalf("unexpected tags %v", got.Tags)
+	}
+	if len(got.Performers) != 1 || got.Performers[0] != "Performer Three" {
+		t.Fatalf("unexpected performers %v", got.Performers)
+	}
+	if len(got.Songs) != 2 || got.Songs[0].Artist != "Daft Punk" || got.Songs[1].Title != "D.A.N.C.E." {
+		t.Fatalf("unexpected songs %v", got.Songs)
+	}
+}
+
+func TestParsePMVHavenDuration(t *testing.T) {
+	cases := map[string]int{
+		"PT4M12S": 252,
+		"PT1H2M":  3720,
+		"3:25":    205,
+		"1:00:05": 3605,
+		"95.6":    95,
+		"":        0,
+		"soon":    0,
+	}
+	for in, want := range cases {
+		if got := parsePMVHavenDuration(in); got != want {
+			t.Fatalf("parsePMVHavenDuration(%q): expected %d, got %d", in, want, got)
+		}
+	}
+}
+
+func TestPMVHavenSceneMetadataSynopsis_AppendsSongCredits(t *testing.T) {
+	got := PMVSceneMetadata{
+		Description: "Edited to the beat.",
+		Songs: []PMVSong{
+			{Artist: "Daft Punk", Title: "One More Time"},
+			{Title: "Untitled Mix"},
+		},
+	}.Synopsis()
+	want := "Edited to the beat.\n\nMusic:\nDaft Punk - One More Time\nUntitled Mix"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	already := PMVSceneMetadata{
+		Description: "Music: Daft Punk - One More Time",
+		Songs:       []PMVSong{{Artist: "Daft Punk", Title: "One More Time"}},
+	}.Synopsis()
+	if already != "Music: Daft Punk - One More Time" {
+		t.Fatalf("expected description to be kept as is, got %q", already)
+	}
+}
+
+func TestPMVHavenScrapedScene(t *testing.T) {
+	html := `
+	<html><head>
+	  <meta property="og:title" content="Neon Nights PMV | PMVHaven" />
+	  <meta property="og:image" content="https://cdn.pmvhaven.com/thumbs/neon.jpg" />
+	  <meta property="og:video" content="https://video.pmvhaven.com/previews/neon.mp4" />
+	  <meta name="author" content="Arckom" />
+	  <meta property="video:duration" content="252" />
+	  <meta property="video:release_date" content="2024-03-09" />
+	  <meta property="video:actor" content="Performer One" />
+	</head><body><a href="/tags/neon">neon</a></body></html>`
+
+	sc := pmvHavenScrapedScene(html, "https://pmvhaven.com/video/neon-nights_65f0c1d2e3a4b5c6d7e8f901?ref=home", "pmvhaven", "PMVHaven", "")
+	if sc.SceneID != "pmvhaven-65f0c1d2e3a4b5c6d7e8f901" {
+		t.Fatalf("unexpected scene id %q", sc.SceneID)
+	}
+	if sc.HomepageURL != "https://pmvhaven.com/video/neon-nights_65f0c1d2e3a4b5c6d7e8f901" {
+		t.Fatalf("unexpected homepage url %q", sc.HomepageURL)
+	}
+	if sc.Title != "Neon Nights PMV" || sc.Studio != "Arckom" || sc.Released != "2024-03-09" || sc.Duration != 4 {
+		t.Fatalf("unexpected scene %+v", sc)
+	}
+	if len(sc.Cast) != 1 || len(sc.Tags) != 1 || len(sc.Covers) != 1 {
+		t.Fatalf("expected cast, tags and cover, got %+v", sc)
+	}
+	if sc.TrailerType != "url" || sc.TrailerSrc != "https://video.pmvhaven.com/previews/neon.mp4" {
+		t.Fatalf("unexpected trailer %q %q", sc.TrailerType, sc.TrailerSrc)
+	}
+}
+
+func TestPMVHavenPageURL(t *testing.T) {
+	got := pmvHavenPageURL("https://pmvhaven.com/browse?sort=newest", 3)
+	want := "https://pmvhaven.com/browse?page=3&sort=newest"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	got = pmvHavenPageURL("/profile/Arckom", 2)
+	want = "https://pmvhaven.com/profile/Arckom?page=2"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func replayPMVHavenFixtures(t *testing.T) {
+	SetScraperFixtureOverride("pmvhaven-scraper", &ScraperFixtureSettings{Mode: FixtureModeReplay, Dir: filepath.Join("testdata", "pmvhaven")})
+	t.Cleanup(func() { SetScraperFixtureOverride("pmvhaven-scraper", nil) })
+}
+
+func TestSearchPMVHaven_Replay(t *testing.T) {
+	replayPMVHavenFixtures(t)
+
+	got, err := SearchPMVHaven("neon nights", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 candidates, got %d", len(got))
+	}
+	if got[0].SceneURL != "https://pmvhaven.com/video/neon-nights-pmv_65f0c1d2e3a4b5c6d7e8f901" {
+		t.Fatalf("unexpected scene url: %q", got[0].SceneURL)
+	}
+	if got[0].Title != "Neon Nights PMV" {
+		t.Fatalf("unexpected title: %q", got[0].Title)
+	}
+	if got[1].ThumbnailURL != "https://cdn.pmvhaven.com/thumbs/65f0c1d2e3a4b5c6d7e8f902.webp" {
+		t.Fatalf("unexpected thumbnail: %q", got[1].ThumbnailURL)
+	}
+}
+
+func TestSearchPMVHaven_ReplayMissingFixture(t *testing.T) {
+	replayPMVHavenFixtures(t)
+
+	if _, err := SearchPMVHaven("not recorded", 5); err == nil {
+		t.Fatalf("expected an error for a query without a fixture")
+	}
+}
+
+func TestEnrichPMVHavenCandidateThumbnail_Replay(t *testing.T) {
+	replayPMVHavenFixtures(t)
+
+	got, err := EnrichPMVHavenCandidateThumbnail(PMVCandidate{SceneURL: "https://pmvhaven.com/video/neon-nights-pmv_65f0c1d2e3a4b5c6d7e8f901/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ThumbnailURL != "https://cdn.pmvhaven.com/posters/65f0c1d2e3a4b5c6d7e8f901.jpg" {
+		t.Fatalf("unexpected thumbnail: %q", got.ThumbnailURL)
+	}
+	if got.PreviewURL != "https://video.pmvhaven.com/previews/65f0c1d2e3a4b5c6d7e8f901.mp4" {
+		t.Fatalf("unexpected preview: %q", got.PreviewURL)
+	}
+	if got.Creator != "Arckom" || got.Duration != 252 || got.UploadDate != "2024-03-09" {
+		t.Fatalf("unexpected metadata: %+v", got.PMVSceneMetadata)
+	}
+	if len(got.Songs) != 1 || got.Songs[0].Artist != "M83" || got.Songs[0].Title != "Midnight City" {
+		t.Fatalf("unexpected songs: %+v", got.Songs)
+	}
+}
+
+func TestFixtureTransport_RecordThenReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>recorded</html>"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder := &http.Client{Transport: &fixtureTransport{settings: ScraperFixtureSettings{Mode: FixtureModeRecord, Dir: dir}, next: http.DefaultTransport}}
+	resp, err := recorder.Get(srv.URL + "/video/abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, fixtureFilename("GET", srv.URL+"/video/abc"))); err != nil {
+		t.Fatalf("expected fixture to be recorded: %v", err)
+	}
+
+	srv.Close()
+	player := &http.Client{Transport: &fixtureTransport{settings: ScraperFixtureSettings{Mode: FixtureModeReplay, Dir: dir}}}
+	resp, err = player.Get(srv.URL + "/video/abc")
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	defer resp.Body.Close()
+	body := new(strings.Builder)
+	if _, err := io.Copy(body, resp.Body); err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if body.String() != "<html>recorded</html>" || resp.Header.Get("Content-Type") != "text/html" {
+		t.Fatalf("unexpected replayed response: %q %v", body.String(), resp.Header)
+	}
+}
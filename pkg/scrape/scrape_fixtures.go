@@ -0,0 +1,169 @@
+package scrape
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/xbapps/xbvr/pkg/common"
+)
+
+// Scraper fixtures capture request/response pairs so parsers can be regression tested offline.
+// They are off by default and switched on per scraper through the "other" settings of its http config, eg
+// pmvhaven-scraper: {"key": "fixture_mode", "value": "record"} and optionally {"key": "fixture_dir", "value": "/path"}
+const (
+	FixtureModeOff    = ""
+	FixtureModeRecord = "record"
+	FixtureModeReplay = "replay"
+)
+
+type ScraperFixtureSettings struct {
+	Mode string
+	Dir  string
+}
+
+type scraperFixture struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	StatusCode int                 `json:"status_code"`
+	Header     map[string][]string `json:"header"`
+	Body       string              `json:"body"`
+	RecordedAt time.Time           `json:"recorded_at"`
+}
+
+var (
+	fixtureOverrideMutex sync.Mutex
+	fixtureOverrides     = map[string]ScraperFixtureSettings{}
+)
+
+// SetScraperFixtureOverride forces fixture settings for a scraper without reading its http config, used by tests
+func SetScraperFixtureOverride(kvKey string, settings *ScraperFixtureSettings) {
+	fixtureOverrideMutex.Lock()
+	defer fixtureOverrideMutex.Unlock()
+
+	if settings == nil {
+		delete(fixtureOverrides, kvKey)
+		return
+	}
+	fixtureOverrides[kvKey] = *settings
+}
+
+func GetScraperFixtureSettings(kvKey string) ScraperFixtureSettings {
+	fixtureOverrideMutex.Lock()
+	override, ok := fixtureOverrides[kvKey]
+	fixtureOverrideMutex.Unlock()
+	if ok {
+		return override
+	}
+
+	settings := ScraperFixtureSettings{}
+	for _, other := range GetScrapeHttpConfig(kvKey).Other {
+		switch other.Key {
+		case "fixture_mode":
+			settings.Mode = strings.ToLower(strings.TrimSpace(other.Value))
+		case "fixture_dir":
+			settings.Dir = strings.TrimSpace(other.Value)
+		}
+	}
+	if settings.Mode != FixtureModeRecord && settings.Mode != FixtureModeReplay {
+		settings.Mode = FixtureModeOff
+	}
+	if settings.Dir == "" {
+		settings.Dir = filepath.Join(common.AppDir, "scraper_fixtures", kvKey)
+	}
+	return settings
+}
+
+// applyScraperFixtures routes the client through the fixture recorder or player when the scraper has fixtures enabled
+func applyScraperFixtures(kvKey string, client *resty.Client) ScraperFixtureSettings {
+	settings := GetScraperFixtureSettings(kvKey)
+	if settings.Mode == FixtureModeOff {
+		return settings
+	}
+	client.SetTransport(&fixtureTransport{settings: settings, next: http.DefaultTransport})
+	if settings.Mode == FixtureModeReplay {
+		client.SetRetryCount(0)
+	}
+	return settings
+}
+
+type fixtureTransport struct {
+	settings ScraperFixtureSettings
+	next     http.RoundTripper
+}
+
+func (t *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fName := filepath.Join(t.settings.Dir, fixtureFilename(req.Method, req.URL.String()))
+
+	if t.settings.Mode == FixtureModeReplay {
+		b, err := os.ReadFile(fName)
+		if err != nil {
+			return nil, fmt.Errorf("no fixture for %s %s: %v", req.Method, req.URL, err)
+		}
+		var fixture scraperFixture
+		if err := json.Unmarshal(b, &fixture); err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", fixture.StatusCode, http.StatusText(fixture.StatusCode)),
+			StatusCode:    fixture.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header(fixture.Header),
+			Body:          io.NopCloser(strings.NewReader(fixture.Body)),
+			ContentLength: int64(len(fixture.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	fixture := scraperFixture{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(body),
+		RecordedAt: time.Now(),
+	}
+	if err := writeScraperFixture(fName, fixture); err != nil {
+		log.Warnf("could not record fixture for %s: %v", req.URL, err)
+	}
+	return resp, nil
+}
+
+func writeScraperFixture(fName string, fixture scraperFixture) error {
+	if err := os.MkdirAll(filepath.Dir(fName), 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fName, content, 0644)
+}
+
+func fixtureFilename(method string, rawURL string) string {
+	sum := sha1.Sum([]byte(strings.ToUpper(method) + " " + rawURL))
+	return slugForFilename(rawURL) + "_" + hex.EncodeToString(sum[:])[:12] + ".json"
+}
@@ -24,6 +24,7 @@ func SetupCron() {
 	cronInstance = cron.New()
 	cronInstance.AddFunc("@every 2s", session.CheckForDeadSession)
 	cronInstance.AddFunc("@every 6h", tasks.CalculateCacheSizes)
+	cronInstance.AddFunc("@every 1m", tasks.CheckVolumeAvailability)
 	if config.Config.Cron.RescrapeSchedule.Enabled {
 		log.Println(fmt.Sprintf("Setup Rescrape Task %v", formatCronSchedule(config.CronSchedule(config.Config.Cron.RescrapeSchedule))))
 		rescrapTask, _ = cronInstance.AddFunc(formatCronSchedule(config.CronSchedule(config.Config.Cron.RescrapeSchedule)), scrapeCron)
@@ -56,6 +57,7 @@ func SetupCron() {
 	cronInstance.Start()
 
 	go tasks.CalculateCacheSizes()
+	go tasks.RefreshVolumeWatchers()
 
 	if config.Config.Cron.RescrapeSchedule.RunAtStartDelay > 0 {
 		time.AfterFunc(time.Duration(config.Config.Cron.RescrapeSchedule.RunAtStartDelay)*time.Minute, scrapeCron)
@@ -90,11 +90,15 @@ func StartServer(version, commit, branch, date string) {
 	restful.Add(api.ConfigResource{}.WebService())
 	restful.Add(api.FilesResource{}.WebService())
 	restful.Add(api.DeoVRResource{}.WebService())
+	restful.Add(api.RemoteResource{}.WebService())
 	restful.Add(api.HeresphereResource{}.WebService())
 	restful.Add(api.PlaylistResource{}.WebService())
 	restful.Add(api.AkaResource{}.WebService())
 	restful.Add(api.TagGroupResource{}.WebService())
 	restful.Add(api.ExternalReference{}.WebService())
+	restful.Add(api.StatsResource{}.WebService())
+	restful.Add(api.UserResource{}.WebService())
+	restful.Filter(api.UserFilter)
 
 	restConfig := restfulspec.Config{
 		WebServices: restful.RegisteredWebServices(),
@@ -44,12 +44,15 @@ func deoLoop() error {
 	if DeoPlayerHost == "" || !config.Config.Interfaces.DeoVR.RemoteEnabled {
 		return nil
 	}
-	conn, err := net.Dial("tcp", DeoPlayerHost+":23554")
+	host := DeoPlayerHost
+	conn, err := net.Dial("tcp", host+":23554")
 	if err != nil {
 		return err
 	}
 
 	common.Log.Info("Connected to DeoVR")
+	setRemoteConnected(host, true)
+	defer setRemoteConnected(host, false)
 
 	for {
 		// Read
@@ -75,7 +78,8 @@ func deoLoop() error {
 			}
 
 			packet := decodePacket(recvBuf)
-			go TrackSessionFromRemote(packet)
+			go TrackSessionFromRemote(Client{RemoteAddr: host, Player: "deovr"}, packet)
+			advanceRemotePlaylist(packet)
 		}
 
 		// Write
@@ -85,26 +89,40 @@ func deoLoop() error {
 		}
 
 		// Check if there's command queued, otherwise send ping packet
-		packet := encodePacket(DeoPacket{})
+		packet := encodePacket(nextRemoteCommand(host, time.Now()))
 		_, err = conn.Write(packet)
 		if err != nil {
 			return err
 		}
 
+		state := remoteSession()
 		go common.PublishWS("remote.state", map[string]interface{}{
 			"connected":       true,
-			"deovrHost":       DeoPlayerHost,
-			"isPlaying":       isPlaying,
-			"currentPosition": currentPosition,
-			"sessionStart":    lastSessionStart,
-			"sessionEnd":      lastSessionEnd,
-			"currentFileID":   currentFileID,
-			"currentSceneID":  currentSceneID,
+			"deovrHost":       host,
+			"isPlaying":       state.IsPlaying,
+			"currentPosition": state.Position,
+			"sessionStart":    state.Start,
+			"sessionEnd":      state.LastSeen,
+			"currentFileID":   state.FileID,
+			"currentSceneID":  state.SceneID,
 		})
 	}
 }
 
-func encodePacket(packet DeoPacket) []byte {
+// remoteSession returns the session of the player the remote control is connected to
+func remoteSession() Session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	for _, s := range sessions {
+		if s.RemoteAddr == DeoPlayerHost && s.Player == "deovr" {
+			return *s
+		}
+	}
+	return Session{}
+}
+
+func encodePacket(packet DeoCommand) []byte {
 	data, _ := json.Marshal(packet)
 	header := make([]byte, 4)
 	binary.LittleEndian.PutUint32(header, uint32(len(data)))
@@ -0,0 +1,222 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/markphelps/optional"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// DeoCommand is a packet sent to DeoVR instead of the ping. Only the fields that are set are sent, so pointers
+// are used where zero is a valid value (playing state, start of the video).
+type DeoCommand struct {
+	Path        string   `json:"path,omitempty"`
+	CurrentTime *float64 `json:"currentTime,omitempty"`
+	PlayerState *int     `json:"playerState,omitempty"`
+}
+
+var ErrRemoteNotConnected = errors.New("DeoVR remote is not connected")
+
+const (
+	// Commands DeoVR did not pick up in time are dropped, a seek or pause is meaningless seconds later
+	remoteCommandTTL = 10 * time.Second
+	// Commands queued per headset at most
+	remoteQueueSize = 16
+)
+
+type queuedRemoteCommand struct {
+	cmd    DeoCommand
+	queued time.Time
+}
+
+var (
+	remoteMutex sync.Mutex
+	// DeoVR host the remote control is connected to, empty while it is not
+	remoteHost string
+	// Commands waiting to be sent, by DeoVR host
+	remoteQueues = map[string][]queuedRemoteCommand{}
+
+	// Scenes of a loaded playlist still to be played, the next one is sent when DeoVR finishes the current one
+	remotePlaylist         []uint
+	remotePlaylistAdvanced time.Time
+)
+
+func setRemoteConnected(host string, connected bool) {
+	remoteMutex.Lock()
+	defer remoteMutex.Unlock()
+
+	// Commands queued for a previous connection are stale
+	delete(remoteQueues, host)
+	if connected {
+		remoteHost = host
+	} else {
+		if remoteHost == host {
+			remoteHost = ""
+		}
+		remotePlaylist = nil
+	}
+}
+
+// queueRemoteCommand queues a command for the headset the remote control is connected to
+func queueRemoteCommand(cmd DeoCommand) (int, error) {
+	return queueRemoteCommandAt(cmd, time.Now())
+}
+
+func queueRemoteCommandAt(cmd DeoCommand, now time.Time) (int, error) {
+	remoteMutex.Lock()
+	defer remoteMutex.Unlock()
+
+	if remoteHost == "" {
+		return 503, ErrRemoteNotConnected
+	}
+	queue := liveRemoteCommands(remoteQueues[remoteHost], now)
+	if len(queue) >= remoteQueueSize {
+		remoteQueues[remoteHost] = queue
+		return 429, errors.New("too many DeoVR remote commands queued")
+	}
+	remoteQueues[remoteHost] = append(queue, queuedRemoteCommand{cmd: cmd, queued: now})
+	return 200, nil
+}
+
+// nextRemoteCommand returns the packet to send to a headset on this round, the ping packet if nothing is queued
+func nextRemoteCommand(host string, now time.Time) DeoCommand {
+	remoteMutex.Lock()
+	defer remoteMutex.Unlock()
+
+	queue := liveRemoteCommands(remoteQueues[host], now)
+	if len(queue) == 0 {
+		delete(remoteQueues, host)
+		return DeoCommand{}
+	}
+	remoteQueues[host] = queue[1:]
+	return queue[0].cmd
+}
+
+// liveRemoteCommands drops the commands that waited longer than remoteCommandTTL
+func liveRemoteCommands(queue []queuedRemoteCommand, now time.Time) []queuedRemoteCommand {
+	for len(queue) > 0 && now.Sub(queue[0].queued) > remoteCommandTTL {
+		queue = queue[1:]
+	}
+	return queue
+}
+
+func remoteScenePath(sceneID uint) string {
+	return fmt.Sprintf("%v/deovr/%v", DeoRequestHost, sceneID)
+}
+
+func RemotePlayScene(sceneID uint) (int, error) {
+	var scene models.Scene
+	if err := scene.GetIfExistByPK(sceneID); err != nil {
+		return 404, fmt.Errorf("scene %v not found", sceneID)
+	}
+
+	statusCode, err := queueRemoteCommand(DeoCommand{Path: remoteScenePath(sceneID)})
+	if err == nil {
+		clearRemotePlaylist()
+	}
+	return statusCode, err
+}
+
+func RemotePlayFile(fileID uint) (int, error) {
+	var file models.File
+	if err := file.GetIfExistByPK(fileID); err != nil {
+		return 404, fmt.Errorf("file %v not found", fileID)
+	}
+
+	statusCode, err := queueRemoteCommand(DeoCommand{Path: fmt.Sprintf("%v/deovr/file/%v", DeoRequestHost, fileID)})
+	if err == nil {
+		clearRemotePlaylist()
+	}
+	return statusCode, err
+}
+
+func RemoteSeek(position float64) (int, error) {
+	if position < 0 {
+		return 400, errors.New("position can't be negative")
+	}
+	return queueRemoteCommand(DeoCommand{CurrentTime: &position})
+}
+
+func RemotePause() (int, error) {
+	state := PAUSED
+	return queueRemoteCommand(DeoCommand{PlayerState: &state})
+}
+
+func RemoteResume() (int, error) {
+	state := PLAYING
+	return queueRemoteCommand(DeoCommand{PlayerState: &state})
+}
+
+// RemotePlayPlaylist plays the scenes of a saved playlist, or the given scenes when no playlist is given, one
+// after the other. It returns the number of scenes queued.
+func RemotePlayPlaylist(playlistID uint, sceneIDs []uint) (int, int, error) {
+	if playlistID != 0 {
+		var playlist models.Playlist
+		db, _ := models.GetDB()
+		err := db.Where("id = ?", playlistID).First(&playlist).Error
+		db.Close()
+		if err != nil {
+			return 0, 404, fmt.Errorf("playlist %v not found", playlistID)
+		}
+
+		var r models.RequestSceneList
+		if err := json.Unmarshal([]byte(playlist.SearchParams), &r); err != nil {
+			return 0, 500, err
+		}
+		r.IsAccessible = optional.NewBool(true)
+		r.IsAvailable = optional.NewBool(true)
+
+		sceneIDs = nil
+		for _, s := range models.QuerySceneSummaries(r) {
+			sceneIDs = append(sceneIDs, s.ID)
+		}
+	}
+	if len(sceneIDs) == 0 {
+		return 0, 400, errors.New("no scenes to play")
+	}
+
+	statusCode, err := queueRemoteCommand(DeoCommand{Path: remoteScenePath(sceneIDs[0])})
+	if err != nil {
+		return 0, statusCode, err
+	}
+
+	remoteMutex.Lock()
+	remotePlaylist = sceneIDs[1:]
+	remotePlaylistAdvanced = time.Now()
+	remoteMutex.Unlock()
+
+	return len(sceneIDs), statusCode, nil
+}
+
+func clearRemotePlaylist() {
+	remoteMutex.Lock()
+	remotePlaylist = nil
+	remoteMutex.Unlock()
+}
+
+// advanceRemotePlaylist queues the next scene of the playlist once DeoVR reports the current video finished.
+// DeoVR keeps reporting finished until the next video loads, so it waits a few seconds after queueing one.
+func advanceRemotePlaylist(packet DeoPacket) {
+	if packet.PlayerState != FINISHED {
+		return
+	}
+
+	remoteMutex.Lock()
+	if len(remotePlaylist) == 0 || time.Since(remotePlaylistAdvanced) < 5*time.Second {
+		remoteMutex.Unlock()
+		return
+	}
+	next := remotePlaylist[0]
+	remotePlaylist = remotePlaylist[1:]
+	remotePlaylistAdvanced = time.Now()
+	remoteMutex.Unlock()
+
+	if _, err := queueRemoteCommand(DeoCommand{Path: remoteScenePath(next)}); err != nil {
+		common.Log.Error(err)
+	}
+}
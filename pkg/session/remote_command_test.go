@@ -0,0 +1,97 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func resetRemote(t *testing.T) {
+	reset := func() {
+		remoteMutex.Lock()
+		remoteHost = ""
+		remoteQueues = map[string][]queuedRemoteCommand{}
+		remotePlaylist = nil
+		remoteMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestRemoteCommandQueue(t *testing.T) {
+	resetRemote(t)
+	now := time.Now()
+
+	if status, err := queueRemoteCommandAt(DeoCommand{Path: "a"}, now); err != ErrRemoteNotConnected || status != 503 {
+		t.Fatalf("expected commands to be refused without a connection, got %d %v", status, err)
+	}
+
+	setRemoteConnected("10.0.0.2", true)
+	for _, path := range []string{"a", "b"} {
+		if status, err := queueRemoteCommandAt(DeoCommand{Path: path}, now); err != nil {
+			t.Fatalf("expected %v to be queued, got %d %v", path, status, err)
+		}
+	}
+	for _, want := range []string{"a", "b", ""} {
+		if cmd := nextRemoteCommand("10.0.0.2", now); cmd.Path != want {
+			t.Fatalf("expected %q to be sent, got %+v", want, cmd)
+		}
+	}
+
+	for i := 0; i < remoteQueueSize; i++ {
+		queueRemoteCommandAt(DeoCommand{Path: "x"}, now)
+	}
+	if status, err := queueRemoteCommandAt(DeoCommand{Path: "y"}, now); err == nil || status != 429 {
+		t.Fatalf("expected a full queue to refuse commands, got %d %v", status, err)
+	}
+}
+
+func TestRemoteCommandExpiry(t *testing.T) {
+	resetRemote(t)
+	now := time.Now()
+
+	setRemoteConnected("10.0.0.2", true)
+	queueRemoteCommandAt(DeoCommand{Path: "old"}, now.Add(-remoteCommandTTL-time.Second))
+	queueRemoteCommandAt(DeoCommand{Path: "new"}, now)
+
+	if cmd := nextRemoteCommand("10.0.0.2", now); cmd.Path != "new" {
+		t.Fatalf("expected the expired command to be skipped, got %+v", cmd)
+	}
+
+	// Expired commands don't count against the size of the queue
+	for i := 0; i < remoteQueueSize; i++ {
+		queueRemoteCommandAt(DeoCommand{Path: "x"}, now.Add(-remoteCommandTTL-time.Second))
+	}
+	if status, err := queueRemoteCommandAt(DeoCommand{Path: "y"}, now); err != nil {
+		t.Fatalf("expected expired commands to make room, got %d %v", status, err)
+	}
+}
+
+func TestRemoteCommandsPerClient(t *testing.T) {
+	resetRemote(t)
+	now := time.Now()
+
+	setRemoteConnected("10.0.0.2", true)
+	queueRemoteCommandAt(DeoCommand{Path: "for-2"}, now)
+
+	if cmd := nextRemoteCommand("10.0.0.3", now); cmd.Path != "" {
+		t.Fatalf("expected another headset not to get the command, got %+v", cmd)
+	}
+
+	// Reconnecting drops what was queued for the old connection
+	setRemoteConnected("10.0.0.2", false)
+	setRemoteConnected("10.0.0.2", true)
+	if cmd := nextRemoteCommand("10.0.0.2", now); cmd.Path != "" {
+		t.Fatalf("expected the queue to be dropped on reconnect, got %+v", cmd)
+	}
+
+	queueRemoteCommandAt(DeoCommand{Path: "for-2"}, now)
+	setRemoteConnected("10.0.0.3", true)
+	queueRemoteCommandAt(DeoCommand{Path: "for-3"}, now)
+	if cmd := nextRemoteCommand("10.0.0.3", now); cmd.Path != "for-3" {
+		t.Fatalf("expected the command of the connected headset, got %+v", cmd)
+	}
+	setRemoteConnected("10.0.0.2", false)
+	if status, err := queueRemoteCommandAt(DeoCommand{Path: "still-3"}, now); err != nil {
+		t.Fatalf("expected the other connection to stay usable, got %d %v", status, err)
+	}
+}
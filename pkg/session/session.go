@@ -6,60 +6,223 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xbapps/xbvr/pkg/common"
 	"github.com/xbapps/xbvr/pkg/models"
 )
 
+// Client identifies who is watching. Players on different headsets are tracked in their own sessions.
+type Client struct {
+	RemoteAddr string `json:"remote_addr"`
+	Player     string `json:"player"`
+	User       string `json:"user"`
+}
+
+func (c Client) key() string {
+	return c.RemoteAddr + "|" + c.Player + "|" + c.User
+}
+
+// Session is the watch session of one client, it is flushed to its history row when the client stops playing
+type Session struct {
+	Client
+	Source    string    `json:"source"`
+	HistoryID uint      `json:"history_id"`
+	SceneID   uint      `json:"scene_id"`
+	FileID    int       `json:"file_id"`
+	IsPlaying bool      `json:"is_playing"`
+	Position  float64   `json:"position"`
+	Start     time.Time `json:"start"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	heatmap  []int
+	duration float64
+	// history row of the watch session, it is saved after the session is opened so its ID comes later
+	history *models.History
+}
+
+// sessionWrite is a database or heatmap write left by a change of a session. Writes are taken while holding
+// sessionsMutex and done after it was released, in the order they were taken.
+type sessionWrite struct {
+	open   *sessionOpen
+	flush  *sessionFlush
+	resume *sessionResume
+}
+
+type sessionOpen struct {
+	session *Session
+	history *models.History
+	user    string
+}
+
+type sessionFlush struct {
+	history  *models.History
+	sceneID  uint
+	source   string
+	start    time.Time
+	lastSeen time.Time
+	heatmap  []int
+}
+
+type sessionResume struct {
+	user     string
+	fileID   uint
+	position float64
+	duration float64
+}
+
 var (
-	sessionSource      string
-	isPlaying          bool
-	currentPosition    float64
-	currentFileID      int
-	currentSceneID     uint
-	lastSessionID      uint
-	lastSessionSceneID uint
-	lastSessionStart   time.Time
-	lastSessionEnd     time.Time
+	sessionsMutex sync.Mutex
+	sessions      = map[string]*Session{}
+	heatmapMutex  sync.Mutex
+
+	// Writes waiting for the writer, guarded by sessionsMutex. writesMutex lets one goroutine at a time do them.
+	pendingWrites []sessionWrite
+	writesMutex   sync.Mutex
+
+	// Users that logged in from a player, the remote control and video streams don't carry credentials
+	knownUsersMutex sync.Mutex
+	knownUsers      = map[string]string{}
 )
 
-var currentSessionHeatmap []int
+// RememberUser notes who logged in from a player, later sessions of that player without a user are theirs
+func RememberUser(remoteAddr string, player string, user string) {
+	knownUsersMutex.Lock()
+	defer knownUsersMutex.Unlock()
 
+	knownUsers[remoteAddr+"|"+player] = user
+	knownUsers[remoteAddr] = user
+}
+
+// withUser fills in the user a client without credentials is known as
+func withUser(c Client) Client {
+	if c.User != "" {
+		return c
+	}
+
+	knownUsersMutex.Lock()
+	defer knownUsersMutex.Unlock()
+
+	if user, ok := knownUsers[c.RemoteAddr+"|"+c.Player]; ok {
+		c.User = user
+	} else {
+		c.User = knownUsers[c.RemoteAddr]
+	}
+	return c
+}
+
+// HasActiveSession tells whether anyone is watching, background tasks wait until nobody is
 func HasActiveSession() bool {
-	return lastSessionID != 0
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	for _, s := range sessions {
+		if s.history != nil {
+			return true
+		}
+	}
+	return false
 }
 
-func TrackSessionFromFile(f models.File, doNotTrack string) {
-	sessionSource = "file"
+// ClientHasActiveSession tells whether a particular client is watching
+func ClientHasActiveSession(c Client) bool {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	s, ok := sessions[withUser(c).key()]
+	return ok && s.history != nil
+}
+
+// ActiveSessions returns a copy of the sessions that are being watched, oldest first
+func ActiveSessions() []Session {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	out := []Session{}
+	for _, s := range sessions {
+		if s.history != nil {
+			c := *s
+			c.heatmap = nil
+			c.history = nil
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// getSession returns the session of a client, creating it when needed. The remote control does not know the
+// user, so it joins a session of the same player on the same address if there is one.
+func getSession(c Client) *Session {
+	c = withUser(c)
+	if s, ok := sessions[c.key()]; ok {
+		return s
+	}
+	if c.User == "" {
+		for _, s := range sessions {
+			if s.RemoteAddr == c.RemoteAddr && s.Player == c.Player {
+				return s
+			}
+		}
+	}
+	s := &Session{Client: c}
+	sessions[c.key()] = s
+	return s
+}
+
+func TrackSessionFromFile(c Client, f models.File, doNotTrack string) {
+	defer writeSessions()
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	s := getSession(c)
+	if s.fedByEvents() {
+		return
+	}
+	s.Source = "file"
 
 	if f.SceneID != 0 && doNotTrack != "true" {
-		if lastSessionSceneID != f.SceneID {
-			newWatchSession(f.SceneID)
+		if s.SceneID != f.SceneID || s.history == nil {
+			s.newWatchSession(f.SceneID)
 		}
 
-		lastSessionEnd = time.Now()
+		s.LastSeen = time.Now()
 	}
 }
 
-func FinishTrackingFromFile(doNotTrack string) {
-	lastSessionEnd = time.Now()
+func FinishTrackingFromFile(c Client, doNotTrack string) {
+	defer writeSessions()
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	s, ok := sessions[withUser(c).key()]
+	if !ok {
+		return
+	}
+	if s.fedByEvents() {
+		return
+	}
+	s.LastSeen = time.Now()
 	if doNotTrack != "true" {
-		watchSessionFlush()
+		s.watchSessionFlush()
 	}
 }
 
-func TrackSessionFromRemote(packet DeoPacket) {
+// fedByEvents tells whether the session is tracked from the events of HereSphere, the video stream then only
+// serves the file. Without events the stream is tracked like those of any other player.
+func (s *Session) fedByEvents() bool {
+	return s.Source == "heresphere" && s.history != nil
+}
+
+func TrackSessionFromRemote(c Client, packet DeoPacket) {
 	if packet.Path == "" || packet.Duration == 0 {
 		return
 	}
 
-	sessionSource = "deovr"
-	isPlaying = packet.PlayerState == PLAYING
-	currentPosition = packet.CurrentTime
-
 	tmpPath, err := url.Parse(packet.Path)
 	if err != nil {
 		return
@@ -70,112 +233,291 @@ func TrackSessionFromRemote(packet DeoPacket) {
 		return
 	}
 
-	// Currently playing file has changed
-	if tmpCurrentFileID != currentFileID {
-		// Get scene ID
-		currentFileID = tmpCurrentFileID
-
-		f := models.File{}
+	// Look up the scene of a newly played file before taking the lock
+	sessionsMutex.Lock()
+	fileChanged := getSession(c).FileID != tmpCurrentFileID
+	sessionsMutex.Unlock()
+	f := models.File{}
+	if fileChanged {
 		db, _ := models.GetDB()
-		_ = db.First(&f, currentFileID).Error
-		defer db.Close()
+		_ = db.First(&f, tmpCurrentFileID).Error
+		db.Close()
+	}
+
+	defer writeSessions()
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	s := getSession(c)
+	s.Source = "deovr"
+
+	// Currently playing file has changed
+	if tmpCurrentFileID != s.FileID {
+		s.saveResumePosition()
 
 		// Create new session
-		if lastSessionSceneID != f.SceneID {
-			newWatchSession(f.SceneID)
+		if s.SceneID != f.SceneID || s.history == nil {
+			s.newWatchSession(f.SceneID)
 		}
 
-		currentSessionHeatmap = make([]int, int(packet.Duration))
+		s.FileID = tmpCurrentFileID
+		s.heatmap = make([]int, int(packet.Duration))
+	}
+
+	s.IsPlaying = packet.PlayerState == PLAYING
+	s.Position = packet.CurrentTime
+	s.duration = packet.Duration
+	if packet.PlayerState == FINISHED {
+		s.Position = packet.Duration
 	}
 
 	// Keep session alive if Deo is playing
 	if packet.PlayerState == PLAYING {
-		lastSessionEnd = time.Now()
+		s.LastSeen = time.Now()
 
 		position := int(packet.CurrentTime)
-		if position > 0 && position < len(currentSessionHeatmap) {
-			currentSessionHeatmap[position] = currentSessionHeatmap[position] + 1
+		if position > 0 && position < len(s.heatmap) {
+			s.heatmap[position] = s.heatmap[position] + 1
 		}
 	}
 }
 
+// Events HereSphere posts to the event server of a video
+const (
+	HeresphereOpen  = 0
+	HerespherePlay  = 1
+	HerespherePause = 2
+	HeresphereClose = 3
+)
+
+// TrackSessionFromHeresphere follows the events of HereSphere. It only reports changes of the player state, so
+// the seconds between two events are counted as watched while it plays.
+func TrackSessionFromHeresphere(c Client, fileID uint, event int, position float64) {
+	var f models.File
+	if err := f.GetIfExistByPK(fileID); err != nil || f.SceneID == 0 {
+		return
+	}
+
+	defer writeSessions()
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	s := getSession(c)
+	s.Source = "heresphere"
+
+	if s.FileID != int(fileID) || s.history == nil {
+		if event == HeresphereClose {
+			return
+		}
+		s.saveResumePosition()
+
+		if s.SceneID != f.SceneID || s.history == nil {
+			s.newWatchSession(f.SceneID)
+		}
+
+		s.FileID = int(fileID)
+		s.IsPlaying = false
+		s.Position = position
+		s.duration = f.VideoDuration
+		s.heatmap = make([]int, int(f.VideoDuration))
+	}
+
+	if s.IsPlaying && position > s.Position && position-s.Position <= 2*time.Since(s.LastSeen).Seconds()+5 {
+		for i := int(s.Position); i < int(position) && i < len(s.heatmap); i++ {
+			if i > 0 {
+				s.heatmap[i] = s.heatmap[i] + 1
+			}
+		}
+	}
+
+	s.IsPlaying = event == HerespherePlay
+	s.Position = position
+	s.LastSeen = time.Now()
+
+	if event == HeresphereClose {
+		s.watchSessionFlush()
+	}
+}
+
 func CheckForDeadSession() {
-	var timeout float64
-	if sessionSource == "file" {
-		timeout = 60
-	} else {
-		timeout = 5
+	defer writeSessions()
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	expireSessions(time.Now())
+}
+
+// expireSessions flushes and forgets the sessions that were not heard from in time, sessionsMutex must be held
+func expireSessions(now time.Time) {
+	for key, s := range sessions {
+		var timeout float64
+		switch {
+		case s.Source == "file":
+			timeout = 60
+		case s.Source == "heresphere" && s.IsPlaying:
+			// No events arrive while HereSphere plays, give it until the end of the video
+			timeout = s.duration - s.Position + 60
+		case s.Source == "heresphere":
+			timeout = 60
+		default:
+			timeout = 5
+		}
+
+		if now.Sub(s.LastSeen).Seconds() > timeout {
+			if s.history != nil {
+				s.watchSessionFlush()
+			}
+			delete(sessions, key)
+		}
 	}
+}
 
-	if time.Since(lastSessionEnd).Seconds() > timeout && lastSessionSceneID != 0 && HasActiveSession() {
-		watchSessionFlush()
-		lastSessionID = 0
-		lastSessionSceneID = 0
+// newWatchSession starts watching a scene, the history row is written by writeSessions
+func (s *Session) newWatchSession(sceneID uint) {
+	if s.history != nil {
+		s.watchSessionFlush()
 	}
+
+	s.SceneID = sceneID
+	s.Start = time.Now()
+	s.HistoryID = 0
+	s.history = &models.History{SceneID: sceneID, TimeStart: s.Start}
+	pendingWrites = append(pendingWrites, sessionWrite{open: &sessionOpen{session: s, history: s.history, user: s.User}})
 }
 
-func newWatchSession(sceneID uint) {
-	if HasActiveSession() {
-		watchSessionFlush()
+// watchSessionFlush ends the watch session, what is left to write is taken from the session
+func (s *Session) watchSessionFlush() {
+	if s.history != nil {
+		pendingWrites = append(pendingWrites, sessionWrite{flush: &sessionFlush{
+			history:  s.history,
+			sceneID:  s.SceneID,
+			source:   s.Source,
+			start:    s.Start,
+			lastSeen: s.LastSeen,
+			heatmap:  s.heatmap,
+		}})
 	}
 
-	lastSessionSceneID = sceneID
-	lastSessionStart = time.Now()
+	s.saveResumePosition()
 
-	obj := models.History{SceneID: sceneID, TimeStart: lastSessionStart}
-	obj.Save()
+	s.FileID = 0
+	s.SceneID = 0
+	s.HistoryID = 0
+	s.history = nil
+	s.heatmap = nil
+}
+
+// saveResumePosition keeps the position of the file being played, only players report one
+func (s *Session) saveResumePosition() {
+	if s.FileID == 0 || s.Source == "file" {
+		return
+	}
+	position := s.Position
+	if s.Source == "heresphere" && s.IsPlaying {
+		position += time.Since(s.LastSeen).Seconds()
+	}
+	pendingWrites = append(pendingWrites, sessionWrite{resume: &sessionResume{user: s.User, fileID: uint(s.FileID), position: position, duration: s.duration}})
+}
+
+// writeSessions does the writes taken from sessions, without holding sessionsMutex so other players are not
+// held up by the database
+func writeSessions() {
+	writesMutex.Lock()
+	defer writesMutex.Unlock()
+
+	for {
+		sessionsMutex.Lock()
+		writes := pendingWrites
+		pendingWrites = nil
+		sessionsMutex.Unlock()
+
+		if len(writes) == 0 {
+			return
+		}
+		for _, w := range writes {
+			switch {
+			case w.open != nil:
+				w.open.write()
+			case w.flush != nil:
+				w.flush.write()
+			case w.resume != nil:
+				w.resume.write()
+			}
+		}
+	}
+}
 
+func (o *sessionOpen) write() {
 	var scene models.Scene
-	err := scene.GetIfExistByPK(sceneID)
-	if err == nil {
-		scene.LastOpened = time.Now()
-		scene.Save()
-	} else {
+	if err := scene.GetIfExistByPK(o.history.SceneID); err != nil {
+		sessionsMutex.Lock()
+		if o.session.history == o.history {
+			o.session.history = nil
+		}
+		sessionsMutex.Unlock()
 		return
 	}
 
-	lastSessionID = obj.ID
-	currentSceneID = scene.ID
+	o.history.UserID = models.ResolveUserID(o.user)
+	o.history.Save()
+
+	scene.LastOpened = time.Now()
+	scene.Save()
+
+	sessionsMutex.Lock()
+	if o.session.history == o.history {
+		o.session.HistoryID = o.history.ID
+	}
+	source, player, remoteAddr := o.session.Source, o.session.Player, o.session.RemoteAddr
+	sessionsMutex.Unlock()
 
-	common.Log.Infof("New session #%v for scene #%v from %v", lastSessionID, lastSessionSceneID, sessionSource)
+	common.Log.Infof("New session #%v for scene #%v from %v (%v %v)", o.history.ID, o.history.SceneID, source, player, remoteAddr)
 }
 
-func watchSessionFlush() {
+func (f *sessionFlush) write() {
+	if f.history.ID == 0 {
+		return
+	}
 	var obj models.History
-	err := obj.GetIfExist(lastSessionID)
+	err := obj.GetIfExist(f.history.ID)
+	if err != nil {
+		return
+	}
+	obj.TimeEnd = f.lastSeen
+	obj.Duration = time.Since(f.start).Seconds()
+	obj.Save()
+
+	var scene models.Scene
+	err = scene.GetIfExistByPK(f.sceneID)
 	if err == nil {
-		obj.TimeEnd = lastSessionEnd
-		obj.Duration = time.Since(lastSessionStart).Seconds()
-		obj.Save()
-
-		var scene models.Scene
-		err := scene.GetIfExistByPK(lastSessionSceneID)
-		if err == nil {
-			if !scene.IsWatched {
-				scene.IsWatched = true
-				scene.Save()
-			}
+		if !models.GetUserScene(f.history.UserID, scene.ID).IsWatched {
+			scene.SetUserState(f.history.UserID, "is_watched", true)
 		}
+	}
 
-		common.Log.Infof("Session #%v duration for scene #%v is %v", lastSessionID, lastSessionSceneID, time.Since(lastSessionStart).Seconds())
+	common.Log.Infof("Session #%v duration for scene #%v is %v", f.history.ID, f.sceneID, time.Since(f.start).Seconds())
 
-		// Dump heatmap
-		// TODO: handle multipart scenes
-		if !scene.IsMultipart && sessionSource == "deovr" {
-			err = dumpHeatmap(lastSessionSceneID, currentSessionHeatmap)
-			if err != nil {
-				common.Log.Error("Error while writing heatmap data", err)
-			}
+	// Dump heatmap
+	// TODO: handle multipart scenes
+	if !scene.IsMultipart && (f.source == "deovr" || f.source == "heresphere") {
+		err = dumpHeatmap(f.sceneID, f.heatmap)
+		if err != nil {
+			common.Log.Error("Error while writing heatmap data", err)
 		}
 	}
+}
 
-	currentFileID = 0
-	currentSceneID = 0
-	lastSessionID = 0
-	lastSessionSceneID = 0
+func (r *sessionResume) write() {
+	if err := models.SaveResumePosition(models.ResolveUserID(r.user), r.fileID, r.position, r.duration); err != nil {
+		common.Log.Error("Error while saving resume position ", err)
+	}
 }
 
 func dumpHeatmap(sceneID uint, data []int) error {
+	// Sessions of several clients may end at the same time
+	heatmapMutex.Lock()
+	defer heatmapMutex.Unlock()
+
 	path := path.Join(common.HeatmapDir, fmt.Sprintf("%v.json", sceneID))
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		// Create new heatmap
@@ -195,6 +537,9 @@ func dumpHeatmap(sceneID uint, data []int) error {
 		}
 
 		for k, v := range tmpHeatmap {
+			if k >= len(data) {
+				break
+			}
 			data[k] = data[k] + v
 		}
 
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// resetSessions clears the package state, the writes taken are dropped instead of hitting the database
+func resetSessions(t *testing.T) {
+	reset := func() {
+		sessionsMutex.Lock()
+		sessions = map[string]*Session{}
+		pendingWrites = nil
+		sessionsMutex.Unlock()
+
+		knownUsersMutex.Lock()
+		knownUsers = map[string]string{}
+		knownUsersMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSessionKeying(t *testing.T) {
+	resetSessions(t)
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	alice := getSession(Client{RemoteAddr: "10.0.0.2", Player: "heresphere", User: "alice"})
+	bob := getSession(Client{RemoteAddr: "10.0.0.2", Player: "heresphere", User: "bob"})
+	if alice == bob {
+		t.Fatal("expected users on the same headset to get their own sessions")
+	}
+	if getSession(Client{RemoteAddr: "10.0.0.3", Player: "heresphere", User: "alice"}) == alice {
+		t.Fatal("expected the same user on another headset to get its own session")
+	}
+
+	// Streams don't carry credentials, they belong to whoever logged in from the player
+	RememberUser("10.0.0.4", "deovr", "carol")
+	carol := getSession(Client{RemoteAddr: "10.0.0.4", Player: "deovr", User: "carol"})
+	if s := getSession(Client{RemoteAddr: "10.0.0.4", Player: "deovr"}); s != carol || s.User != "carol" {
+		t.Fatalf("expected a client without credentials to join the session of the known user, got %+v", s)
+	}
+	if s := getSession(Client{RemoteAddr: "10.0.0.4", Player: "browser"}); s == carol || s.User != "carol" {
+		t.Fatalf("expected another player on the address to be the known user in its own session, got %+v", s)
+	}
+
+	// Nobody logged in from here, the remote control joins the session of the player
+	dave := getSession(Client{RemoteAddr: "10.0.0.5", Player: "deovr", User: "dave"})
+	if getSession(Client{RemoteAddr: "10.0.0.5", Player: "deovr"}) != dave {
+		t.Fatal("expected the remote control to join the session of the player on the same address")
+	}
+}
+
+func TestSessionFlushTakesWrites(t *testing.T) {
+	resetSessions(t)
+
+	sessionsMutex.Lock()
+	s := getSession(Client{RemoteAddr: "10.0.0.2", Player: "deovr", User: "alice"})
+	s.Source = "deovr"
+	s.newWatchSession(7)
+	s.FileID = 3
+	s.Position = 120
+	s.duration = 600
+	s.LastSeen = time.Now()
+	s.heatmap = []int{0, 1, 1}
+	sessionsMutex.Unlock()
+
+	if !HasActiveSession() || !ClientHasActiveSession(Client{RemoteAddr: "10.0.0.2", Player: "deovr", User: "alice"}) {
+		t.Fatal("expected the session to be active before its history row is written")
+	}
+
+	sessionsMutex.Lock()
+	history := s.history
+	s.watchSessionFlush()
+	writes := pendingWrites
+	sessionsMutex.Unlock()
+
+	if len(writes) != 3 || writes[0].open == nil || writes[1].flush == nil || writes[2].resume == nil {
+		t.Fatalf("expected open, flush and resume writes in order, got %+v", writes)
+	}
+	if f := writes[1].flush; f.history != history || f.sceneID != 7 || f.source != "deovr" || len(f.heatmap) != 3 {
+		t.Fatalf("expected the flush to hold the state of the session, got %+v", f)
+	}
+	if r := writes[2].resume; r.user != "alice" || r.fileID != 3 || r.position != 120 || r.duration != 600 {
+		t.Fatalf("expected the resume position of the file, got %+v", r)
+	}
+	if s.history != nil || s.SceneID != 0 || s.FileID != 0 || s.heatmap != nil {
+		t.Fatalf("expected the session to be reset, got %+v", s)
+	}
+	if HasActiveSession() {
+		t.Fatal("expected no active session after the flush")
+	}
+}
+
+func TestExpireSessions(t *testing.T) {
+	resetSessions(t)
+
+	now := time.Now()
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	stale := getSession(Client{RemoteAddr: "10.0.0.2", Player: "browser", User: "alice"})
+	stale.Source = "file"
+	stale.newWatchSession(7)
+	stale.LastSeen = now.Add(-2 * time.Minute)
+
+	// HereSphere sends nothing while it plays, the session lasts until the end of the video
+	playing := getSession(Client{RemoteAddr: "10.0.0.3", Player: "heresphere", User: "bob"})
+	playing.Source = "heresphere"
+	playing.newWatchSession(8)
+	playing.IsPlaying = true
+	playing.Position = 100
+	playing.duration = 1000
+	playing.LastSeen = now.Add(-5 * time.Minute)
+
+	pendingWrites = nil
+	expireSessions(now)
+
+	if _, ok := sessions[stale.key()]; ok {
+		t.Fatal("expected the stale session to be removed")
+	}
+	if _, ok := sessions[playing.key()]; !ok {
+		t.Fatal("expected the playing HereSphere session to be kept")
+	}
+	if len(pendingWrites) != 1 || pendingWrites[0].flush == nil || pendingWrites[0].flush.sceneID != 7 {
+		t.Fatalf("expected the stale session to be flushed, got %+v", pendingWrites)
+	}
+}
+
+func TestFileStreamLeavesHeresphereSessions(t *testing.T) {
+	resetSessions(t)
+
+	c := Client{RemoteAddr: "10.0.0.2", Player: "heresphere", User: "alice"}
+	sessionsMutex.Lock()
+	s := getSession(c)
+	s.Source = "heresphere"
+	s.newWatchSession(7)
+	s.FileID = 3
+	s.IsPlaying = true
+	pendingWrites = nil
+	sessionsMutex.Unlock()
+
+	TrackSessionFromFile(c, models.File{ID: 4, SceneID: 8}, "")
+	FinishTrackingFromFile(c, "")
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	if s.Source != "heresphere" || s.SceneID != 7 || s.history == nil || len(pendingWrites) != 0 {
+		t.Fatalf("expected the stream to leave the session of the events alone, got %+v %+v", s, pendingWrites)
+	}
+}
@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SHA256 of an empty body, none of the requests send one
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type s3Backend struct {
+	cfg      Config
+	endpoint *url.URL
+	region   string
+	prefix   string
+	client   *http.Client
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func newS3Backend(cfg Config) (*s3Backend, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("endpoint must be a http(s) address")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Backend{
+		cfg:      cfg,
+		endpoint: u,
+		region:   region,
+		prefix:   strings.TrimPrefix(cleanRoot(cfg.Root), "/"),
+		client:   &http.Client{},
+	}, nil
+}
+
+func (b *s3Backend) objectURL(key string, query url.Values) *url.URL {
+	u := *b.endpoint
+	if b.cfg.PathStyle {
+		u.Path = b.endpoint.Path + "/" + b.cfg.Bucket + "/" + key
+	} else {
+		u.Host = b.cfg.Bucket + "." + b.endpoint.Host
+		u.Path = b.endpoint.Path + "/" + key
+	}
+	// Send the path exactly as it is encoded for the signature
+	u.RawPath = s3URIEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (b *s3Backend) request(ctx context.Context, method string, key string, query url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	signS3Request(req, b.cfg.AccessKey, b.cfg.SecretKey, b.region, time.Now().UTC())
+	return req, nil
+}
+
+// signS3Request adds an AWS signature version 4 to a request without body
+func signS3Request(req *http.Request, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", s3EmptyPayloadHash)
+	if accessKey == "" {
+		return
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + s3EmptyPayloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var canonicalQuery []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			canonicalQuery = append(canonicalQuery, s3URIEncode(k, true)+"="+s3URIEncode(v, true))
+		}
+	}
+
+	canonicalURI := s3URIEncode(req.URL.Path, false)
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3URIEncode escapes everything but unreserved characters, as required for the canonical request
+func s3URIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			sb.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return sb.String()
+}
+
+func (b *s3Backend) key(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (b *s3Backend) list(ctx context.Context, maxKeys int) ([]Entry, error) {
+	prefix := b.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var out []Entry
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if maxKeys > 0 {
+			query.Set("max-keys", strconv.Itoa(maxKeys))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := b.request(ctx, http.MethodGet, "", query)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListBucketResult
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("listing bucket %v: %v", b.cfg.Bucket, resp.Status)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			if strings.HasSuffix(obj.Key, "/") {
+				continue
+			}
+			out = append(out, Entry{Path: "/" + obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" || maxKeys > 0 {
+			return out, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (b *s3Backend) List(ctx context.Context) ([]Entry, error) {
+	return b.list(ctx, 0)
+}
+
+func (b *s3Backend) Stat(ctx context.Context, name string) (Entry, error) {
+	key := b.key(name)
+	// Buckets have no folders, the root is there when the bucket can be listed
+	if key == "" || key == b.prefix {
+		if _, err := b.list(ctx, 1); err != nil {
+			return Entry{}, err
+		}
+		return Entry{Path: "/" + key, IsDir: true}, nil
+	}
+
+	req, err := b.request(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return Entry{}, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return Entry{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Entry{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Entry{}, fmt.Errorf("HEAD %v: %v", key, resp.Status)
+	}
+	modTime, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
+	return Entry{Path: "/" + key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (b *s3Backend) ReadRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	req, err := b.request(ctx, http.MethodGet, b.key(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", httpRange(offset, length))
+	return doRangeRequest(b.client, req, offset, length)
+}
+
+func (b *s3Backend) Remove(ctx context.Context, name string) error {
+	req, err := b.request(ctx, http.MethodDelete, b.key(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE %v: %v", name, resp.Status)
+	}
+	return nil
+}
+
+func (b *s3Backend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+type sftpBackend struct {
+	cfg  Config
+	root string
+
+	mutex  sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+func newSFTPBackend(cfg Config) (*sftpBackend, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	b := &sftpBackend{cfg: cfg, root: cleanRoot(cfg.Root)}
+	if b.root == "" {
+		b.root = "/"
+	}
+	if _, err := b.sftp(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newSFTPBackendFromClient wraps an existing connection, used to test against an in-process server
+func newSFTPBackendFromClient(client *sftp.Client, root string) *sftpBackend {
+	b := &sftpBackend{client: client, root: cleanRoot(root)}
+	if b.root == "" {
+		b.root = "/"
+	}
+	return b
+}
+
+// sftp returns the open connection or dials a new one after the previous one was lost
+func (b *sftpBackend) sftp() (*sftp.Client, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	var auth []ssh.AuthMethod
+	if b.cfg.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if b.cfg.Password != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(b.cfg.PrivateKey), []byte(b.cfg.Password))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(b.cfg.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if b.cfg.Password != "" {
+		auth = append(auth, ssh.Password(b.cfg.Password))
+	}
+
+	// Never talk to a server whose key was not pinned, see ScanSFTPHostKey
+	if b.cfg.HostKey == "" {
+		return nil, errors.New("host key is not pinned")
+	}
+	hostKeyCallback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fp := ssh.FingerprintSHA256(key); fp != b.cfg.HostKey && strings.TrimPrefix(fp, "SHA256:") != b.cfg.HostKey {
+			return fmt.Errorf("host key %v does not match", fp)
+		}
+		return nil
+	}
+
+	conn, err := ssh.Dial("tcp", sftpAddress(b.cfg), &ssh.ClientConfig{
+		User:            b.cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         15 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	b.conn = conn
+	b.client = client
+	return client, nil
+}
+
+func sftpAddress(cfg Config) string {
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(cfg.Host, fmt.Sprint(port))
+}
+
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanSFTPHostKey returns the fingerprint the server presents, it is pinned on the volume the first time it connects
+func ScanSFTPHostKey(cfg Config) (string, error) {
+	if cfg.Host == "" {
+		return "", errors.New("host is required")
+	}
+	var fingerprint string
+	conn, err := ssh.Dial("tcp", sftpAddress(cfg), &ssh.ClientConfig{
+		User: cfg.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			return errHostKeyScanned
+		},
+		Timeout: 15 * time.Second,
+	})
+	if conn != nil {
+		conn.Close()
+	}
+	if fingerprint == "" {
+		if err == nil {
+			err = errors.New("server did not present a host key")
+		}
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+// check drops the connection when it broke so the next call reconnects
+func (b *sftpBackend) check(err error) error {
+	if err == nil || b.conn == nil {
+		return err
+	}
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) {
+		b.Close()
+	}
+	return err
+}
+
+func (b *sftpBackend) List(ctx context.Context) ([]Entry, error) {
+	client, err := b.sftp()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Entry
+	walker := client.Walk(b.root)
+	for walker.Step() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := walker.Err(); err != nil {
+			if walker.Path() == b.root {
+				return nil, b.check(err)
+			}
+			continue
+		}
+		fi := walker.Stat()
+		if fi.IsDir() {
+			if walker.Path() != b.root && strings.HasPrefix(fi.Name(), ".") {
+				walker.SkipDir()
+			}
+			continue
+		}
+		out = append(out, Entry{Path: walker.Path(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	return out, nil
+}
+
+func (b *sftpBackend) Stat(ctx context.Context, name string) (Entry, error) {
+	client, err := b.sftp()
+	if err != nil {
+		return Entry{}, err
+	}
+	fi, err := client.Stat(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Entry{}, ErrNotFound
+		}
+		return Entry{}, b.check(err)
+	}
+	return Entry{Path: path.Clean(name), Size: fi.Size(), ModTime: fi.ModTime(), IsDir: fi.IsDir()}, nil
+}
+
+func (b *sftpBackend) ReadRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	client, err := b.sftp()
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, b.check(err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (b *sftpBackend) Remove(ctx context.Context, name string) error {
+	client, err := b.sftp()
+	if err != nil {
+		return err
+	}
+	if err := client.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return b.check(err)
+	}
+	return nil
+}
+
+func (b *sftpBackend) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.client != nil {
+		b.client.Close()
+		b.client = nil
+	}
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+	return nil
+}
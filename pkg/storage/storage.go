@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Volume types served through a Backend, local folders and put.io keep their own code paths
+const (
+	TypeSFTP   = "sftp"
+	TypeWebDAV = "webdav"
+	TypeS3     = "s3"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Entry is a file or folder on a remote volume. Paths are slash separated and absolute, the same value is
+// stored as path and filename of the file record and handed back to the backend.
+type Entry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// Backend gives access to the files of a volume that is not mounted locally
+type Backend interface {
+	// List returns every file below the root of the volume, folders are walked but not returned
+	List(ctx context.Context) ([]Entry, error)
+	Stat(ctx context.Context, name string) (Entry, error)
+	// ReadRange reads length bytes starting at offset, a negative length reads to the end of the file
+	ReadRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error)
+	Remove(ctx context.Context, name string) error
+	Close() error
+}
+
+// Config holds the connection settings of a remote volume, it is stored as JSON in the metadata of the volume
+type Config struct {
+	// SFTP
+	Host       string `json:"host,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	HostKey    string `json:"host_key,omitempty"`
+
+	// WebDAV
+	URL string `json:"url,omitempty"`
+
+	// S3 compatible
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	PathStyle bool   `json:"path_style,omitempty"`
+
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Root is the folder on the server (SFTP, WebDAV) or the key prefix in the bucket (S3) holding the videos
+	Root string `json:"root,omitempty"`
+}
+
+func ParseConfig(metadata string) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(metadata), &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid storage settings: %v", err)
+	}
+	return cfg, nil
+}
+
+func (c Config) JSON() string {
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
+func IsRemoteType(kind string) bool {
+	return kind == TypeSFTP || kind == TypeWebDAV || kind == TypeS3
+}
+
+// DisplayName is shown as the path of the volume, it never contains credentials
+func DisplayName(kind string, cfg Config) string {
+	switch kind {
+	case TypeSFTP:
+		port := cfg.Port
+		if port == 0 {
+			port = 22
+		}
+		return fmt.Sprintf("sftp://%v@%v:%v%v", cfg.User, cfg.Host, port, cleanRoot(cfg.Root))
+	case TypeWebDAV:
+		return strings.TrimSuffix(cfg.URL, "/") + cleanRoot(cfg.Root)
+	case TypeS3:
+		return fmt.Sprintf("s3://%v%v", cfg.Bucket, cleanRoot(cfg.Root))
+	}
+	return ""
+}
+
+// New connects to a remote volume
+func New(kind string, cfg Config) (Backend, error) {
+	switch kind {
+	case TypeSFTP:
+		return newSFTPBackend(cfg)
+	case TypeWebDAV:
+		return newWebDAVBackend(cfg)
+	case TypeS3:
+		return newS3Backend(cfg)
+	}
+	return nil, fmt.Errorf("unsupported storage type %q", kind)
+}
+
+// RootPath is the folder of a volume that holds its files, it is checked to tell whether the volume is online
+func RootPath(cfg Config) string {
+	if root := cleanRoot(cfg.Root); root != "" {
+		return root
+	}
+	return "/"
+}
+
+func cleanRoot(root string) string {
+	root = path.Clean("/" + strings.TrimSpace(root))
+	if root == "/" {
+		return ""
+	}
+	return root
+}
+
+// FileReader reads a remote file through ranged requests. Sequential reads share one request, a seek starts
+// a new one, so it can be handed to http.ServeContent or the OSDB hash.
+type FileReader struct {
+	ctx     context.Context
+	backend Backend
+	name    string
+	size    int64
+
+	offset int64
+	body   io.ReadCloser
+	bodyAt int64
+}
+
+func NewFileReader(ctx context.Context, backend Backend, name string, size int64) *FileReader {
+	return &FileReader{ctx: ctx, backend: backend, name: name, size: size}
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || r.bodyAt != r.offset {
+		r.closeBody()
+		body, err := r.backend.ReadRange(r.ctx, r.name, r.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+		r.bodyAt = r.offset
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	r.bodyAt += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		r.closeBody()
+		err = nil
+		if n == 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return n, err
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *FileReader) ReadAt(p []byte, offset int64) (int, error) {
+	body, err := r.backend.ReadRange(r.ctx, r.name, offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *FileReader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *FileReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+// ServeFile streams a remote file with support for range requests
+func ServeFile(w http.ResponseWriter, req *http.Request, backend Backend, entry Entry) {
+	reader := NewFileReader(req.Context(), backend, entry.Path, entry.Size)
+	defer reader.Close()
+	http.ServeContent(w, req, path.Base(entry.Path), entry.ModTime, reader)
+}
+
+// LoopbackURL serves a remote file on a local port for tools like ffprobe that only read files and URLs.
+// The returned function stops the listener.
+func LoopbackURL(backend Backend, entry Entry) (string, func(), error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ServeFile(w, req, backend, entry)
+	})}
+	go srv.Serve(listener)
+
+	u := fmt.Sprintf("http://%v/%v", listener.Addr().String(), url.PathEscape(path.Base(entry.Path)))
+	return u, func() { srv.Close() }, nil
+}
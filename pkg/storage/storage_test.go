@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/xml"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
+)
+
+// writeTestTree creates the files every backend is tested against and returns the content of the video
+func writeTestTree(t *testing.T, dir string) []byte {
+	t.Helper()
+
+	video := make([]byte, 200*1024)
+	rand.New(rand.NewSource(1)).Read(video)
+
+	files := map[string][]byte{
+		"videos/scene one.mp4":      video,
+		"videos/sub/scene one.srt":  []byte("1\n00:00:01,000 --> 00:00:02,000\nhello\n"),
+		"videos/.hidden/ignore.mp4": []byte("hidden"),
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return video
+}
+
+func testBackend(t *testing.T, b Backend, root string, video []byte, skipsHidden bool) {
+	t.Helper()
+	ctx := context.Background()
+	videoPath := root + "/scene one.mp4"
+
+	entries, err := b.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+		if e.Path == videoPath && e.Size != int64(len(video)) {
+			t.Errorf("size of %v = %v, want %v", e.Path, e.Size, len(video))
+		}
+	}
+	sort.Strings(paths)
+	want := []string{videoPath, root + "/sub/scene one.srt"}
+	if !skipsHidden {
+		want = []string{root + "/.hidden/ignore.mp4", videoPath, root + "/sub/scene one.srt"}
+	}
+	if strings.Join(paths, "|") != strings.Join(want, "|") {
+		t.Errorf("List = %v, want %v", paths, want)
+	}
+
+	entry, err := b.Stat(ctx, videoPath)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if entry.Size != int64(len(video)) || entry.IsDir {
+		t.Errorf("Stat = %+v", entry)
+	}
+	if _, err := b.Stat(ctx, root+"/missing.mp4"); err != ErrNotFound {
+		t.Errorf("Stat of missing file err = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Stat(ctx, RootPath(Config{Root: root})); err != nil {
+		t.Errorf("Stat of root: %v", err)
+	}
+
+	body, err := b.ReadRange(ctx, videoPath, 1000, 500)
+	if err != nil {
+		t.Fatalf("ReadRange: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(got, video[1000:1500]) {
+		t.Errorf("ReadRange returned %v bytes that do not match", len(got))
+	}
+
+	// Seeking reader as used by http.ServeContent
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Range", "bytes=150000-")
+	ServeFile(rec, req, b, entry)
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), video[150000:]) {
+		t.Errorf("ServeFile status %v, %v bytes", rec.Code, rec.Body.Len())
+	}
+
+	reader := NewFileReader(ctx, b, videoPath, entry.Size)
+	tail := make([]byte, 64)
+	if _, err := reader.ReadAt(tail, entry.Size-64); err != nil && err != io.EOF {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(tail, video[len(video)-64:]) {
+		t.Error("ReadAt of the tail does not match")
+	}
+
+	if err := b.Remove(ctx, root+"/sub/scene one.srt"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := b.Stat(ctx, root+"/sub/scene one.srt"); err != ErrNotFound {
+		t.Errorf("Stat after Remove err = %v", err)
+	}
+}
+
+func TestWebDAVBackend(t *testing.T) {
+	dir := t.TempDir()
+	video := writeTestTree(t, dir)
+
+	handler := &webdav.Handler{Prefix: "/dav", FileSystem: webdav.Dir(dir), LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "xbvr" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	b, err := New(TypeWebDAV, Config{URL: srv.URL + "/dav/", User: "xbvr", Password: "secret", Root: "videos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	testBackend(t, b, "/videos", video, true)
+}
+
+func TestSFTPBackend(t *testing.T) {
+	dir := t.TempDir()
+	video := writeTestTree(t, dir)
+
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverRead, serverWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientRead, clientWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.ToSlash(filepath.Join(dir, "videos"))
+	b := newSFTPBackendFromClient(client, root)
+	testBackend(t, b, root, video, true)
+
+	// The client only shuts down once the server side of the pipe is gone
+	server.Close()
+	b.Close()
+}
+
+func TestSFTPHostKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		cfg := &ssh.ServerConfig{NoClientAuth: true}
+		cfg.AddHostKey(signer)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// Only the handshake matters here
+				if conn, _, _, err := ssh.NewServerConn(c, cfg); err == nil {
+					conn.Close()
+				}
+				c.Close()
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	cfg := Config{Host: "127.0.0.1", Port: addr.Port, User: "xbvr"}
+
+	if _, err := newSFTPBackend(cfg); err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Fatalf("connected without a host key: %v", err)
+	}
+
+	fp, err := ScanSFTPHostKey(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ssh.FingerprintSHA256(signer.PublicKey()); fp != want {
+		t.Fatalf("scanned %v, want %v", fp, want)
+	}
+
+	cfg.HostKey = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	if _, err := newSFTPBackend(cfg); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("connected with the wrong host key: %v", err)
+	}
+}
+
+// fakeS3 serves a folder as a bucket, it only checks that requests are signed
+type fakeS3 struct {
+	t      *testing.T
+	dir    string
+	bucket string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("x-amz-date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket+"/")
+	if r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2" {
+		type object struct {
+			Key          string
+			Size         int64
+			LastModified time.Time
+		}
+		var result struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Contents    []object
+			IsTruncated bool
+		}
+		prefix := r.URL.Query().Get("prefix")
+		filepath.Walk(s.dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(s.dir, p)
+			if k := filepath.ToSlash(rel); strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, object{Key: k, Size: fi.Size(), LastModified: fi.ModTime().UTC()})
+			}
+			return nil
+		})
+		xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	switch r.Method {
+	case http.MethodDelete:
+		os.Remove(p)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet, http.MethodHead:
+		f, err := os.Open(p)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		fi, _ := f.Stat()
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Backend(t *testing.T) {
+	dir := t.TempDir()
+	video := writeTestTree(t, dir)
+
+	srv := httptest.NewServer(&fakeS3{t: t, dir: dir, bucket: "library"})
+	defer srv.Close()
+
+	b, err := New(TypeS3, Config{Endpoint: srv.URL, Bucket: "library", AccessKey: "AKID", SecretKey: "secret", PathStyle: true, Root: "videos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	// Buckets have no hidden folders, everything below the prefix is listed
+	testBackend(t, b, "/videos", video, false)
+}
+
+func TestS3URIEncode(t *testing.T) {
+	cases := map[string]string{
+		"/bucket/scene one.mp4": "/bucket/scene%20one.mp4",
+		"/a+b/(c)~d_e.f":        "/a%2Bb/%28c%29~d_e.f",
+	}
+	for in, want := range cases {
+		if got := s3URIEncode(in, false); got != want {
+			t.Errorf("s3URIEncode(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := s3URIEncode("a/b", true); got != "a%2Fb" {
+		t.Errorf("s3URIEncode with slashes = %q", got)
+	}
+}
+
+func TestDisplayNameHasNoCredentials(t *testing.T) {
+	cfg := Config{Host: "nas", User: "me", Password: "secret", Root: "media/vr", Bucket: "vr", SecretKey: "secret", URL: "https://seedbox/dav"}
+	for _, kind := range []string{TypeSFTP, TypeWebDAV, TypeS3} {
+		name := DisplayName(kind, cfg)
+		if name == "" || strings.Contains(name, "secret") {
+			t.Errorf("DisplayName(%v) = %q", kind, name)
+		}
+	}
+	if got := DisplayName(TypeSFTP, cfg); got != "sftp://me@nas:22/media/vr" {
+		t.Errorf("DisplayName(sftp) = %q", got)
+	}
+}
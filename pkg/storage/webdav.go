@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type webdavBackend struct {
+	cfg    Config
+	base   *url.URL
+	root   string
+	client *http.Client
+}
+
+type webdavMultistatus struct {
+	Responses []webdavResponse `xml:"DAV: response"`
+}
+
+type webdavResponse struct {
+	Href     string           `xml:"DAV: href"`
+	Propstat []webdavPropstat `xml:"DAV: propstat"`
+}
+
+type webdavPropstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ResourceType  struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+	} `xml:"DAV: prop"`
+}
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/><D:getlastmodified/><D:resourcetype/></D:prop></D:propfind>`
+
+func newWebDAVBackend(cfg Config) (*webdavBackend, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, errors.New("url must be a http(s) address")
+	}
+	return &webdavBackend{cfg: cfg, base: base, root: cleanRoot(cfg.Root), client: &http.Client{}}, nil
+}
+
+func (b *webdavBackend) request(ctx context.Context, method string, name string, body io.Reader) (*http.Request, error) {
+	u := *b.base
+	u.Path = b.base.Path + name
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if b.cfg.User != "" || b.cfg.Password != "" {
+		req.SetBasicAuth(b.cfg.User, b.cfg.Password)
+	}
+	return req, nil
+}
+
+// propfind lists a file, or a folder and its direct children
+func (b *webdavBackend) propfind(ctx context.Context, name string) ([]Entry, error) {
+	req, err := b.request(ctx, "PROPFIND", name, bytes.NewBufferString(webdavPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %v: %v", name, resp.Status)
+	}
+
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+
+	var out []Entry
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		p := strings.TrimPrefix(href.Path, b.base.Path)
+		entry := Entry{Path: path.Clean("/" + p)}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			entry.IsDir = ps.Prop.ResourceType.Collection != nil
+			entry.Size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			entry.ModTime, _ = time.Parse(http.TimeFormat, ps.Prop.LastModified)
+		}
+		out = append(out, entry)
+	}
+	return out, nil
+}
+
+func (b *webdavBackend) List(ctx context.Context) ([]Entry, error) {
+	root := b.root
+	if root == "" {
+		root = "/"
+	}
+
+	var out []Entry
+	queue := []string{root}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+
+		entries, err := b.propfind(ctx, dir)
+		if err != nil {
+			if dir == root {
+				return nil, err
+			}
+			continue
+		}
+		for _, e := range entries {
+			if e.Path == path.Clean(dir) {
+				continue
+			}
+			if e.IsDir {
+				if !strings.HasPrefix(path.Base(e.Path), ".") {
+					queue = append(queue, e.Path+"/")
+				}
+				continue
+			}
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (b *webdavBackend) Stat(ctx context.Context, name string) (Entry, error) {
+	entries, err := b.propfind(ctx, name)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range entries {
+		if e.Path == path.Clean("/"+name) {
+			return e, nil
+		}
+	}
+	return Entry{}, ErrNotFound
+}
+
+func (b *webdavBackend) ReadRange(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	req, err := b.request(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", httpRange(offset, length))
+	return doRangeRequest(b.client, req, offset, length)
+}
+
+func (b *webdavBackend) Remove(ctx context.Context, name string) error {
+	req, err := b.request(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE %v: %v", name, resp.Status)
+	}
+	return nil
+}
+
+func (b *webdavBackend) Close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+func httpRange(offset int64, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// doRangeRequest runs a ranged GET, servers that ignore the range get the skipped part discarded
+func doRangeRequest(client *http.Client, req *http.Request, offset int64, length int64) (io.ReadCloser, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if length >= 0 {
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, length), resp.Body}, nil
+		}
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("GET %v: %v", req.URL.Path, resp.Status)
+}
@@ -0,0 +1,470 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+const (
+	DoctorMissingFile   = "missing_file"
+	DoctorMissingScene  = "missing_scene"
+	DoctorOrphanScript  = "orphan_script"
+	DoctorStaleFilename = "stale_filename"
+	DoctorStaleStatus   = "stale_status"
+	DoctorOrphanLink    = "orphan_link"
+	DoctorIndexMissing  = "index_missing"
+	DoctorIndexOrphan   = "index_orphan"
+)
+
+// DoctorCategories lists every check in the order it runs, repairs of earlier checks feed into later ones
+var DoctorCategories = []string{DoctorMissingFile, DoctorMissingScene, DoctorOrphanScript, DoctorStaleFilename, DoctorStaleStatus, DoctorOrphanLink, DoctorIndexMissing, DoctorIndexOrphan}
+
+type DoctorIssue struct {
+	Category   string `json:"category"`
+	SceneID    string `json:"scene_id,omitempty"`
+	FileID     uint   `json:"file_id,omitempty"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+}
+
+type DoctorReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Repair      []string       `json:"repair"`
+	Counts      map[string]int `json:"counts"`
+	Repaired    int            `json:"repaired"`
+	Skipped     []string       `json:"skipped"`
+	Issues      []DoctorIssue  `json:"issues"`
+	ReportFile  string         `json:"report_file"`
+}
+
+type libraryDoctor struct {
+	db     *gorm.DB
+	tlog   *logrus.Entry
+	repair map[string]bool
+	report *DoctorReport
+}
+
+// addIssue records an issue, repairFn is only called when the category was selected for repair
+func (d *libraryDoctor) addIssue(issue DoctorIssue, repairFn func() error) {
+	issue.Repairable = repairFn != nil
+	if repairFn != nil && d.repair[issue.Category] {
+		if err := repairFn(); err != nil {
+			d.tlog.Warnf("Failed to repair %v %v: %v", issue.Category, issue.Detail, err)
+		} else {
+			issue.Repaired = true
+			d.report.Repaired++
+		}
+	}
+	d.report.Counts[issue.Category]++
+	d.report.Issues = append(d.report.Issues, issue)
+}
+
+// LibraryDoctor looks for inconsistencies between files, scenes, external reference links and the search
+// index. Categories listed in repair are fixed where that is safe, "all" repairs everything.
+func LibraryDoctor(repair []string) {
+	tlog := log.WithField("task", "doctor")
+	if models.CheckLock("doctor") {
+		tlog.Infof("skipped: task already running")
+		return
+	}
+	if models.CheckLock("rescan") {
+		tlog.Infof("skipped: a rescan is running")
+		return
+	}
+	models.CreateLock("doctor")
+	defer models.RemoveLock("doctor")
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	d := &libraryDoctor{
+		db:     db,
+		tlog:   tlog,
+		repair: map[string]bool{},
+		report: &DoctorReport{GeneratedAt: time.Now(), Counts: map[string]int{}, Repair: []string{}, Skipped: []string{}, Issues: []DoctorIssue{}},
+	}
+	for _, c := range repair {
+		if c == "all" {
+			for _, c := range DoctorCategories {
+				d.repair[c] = true
+			}
+		} else {
+			d.repair[c] = true
+		}
+	}
+	for _, c := range DoctorCategories {
+		if d.repair[c] {
+			d.report.Repair = append(d.report.Repair, c)
+		}
+	}
+
+	tlog.Infof("Checking library health")
+	d.checkMissingFiles()
+	d.checkMissingScenes()
+	d.checkOrphanScripts()
+	d.checkStaleFilenames()
+	d.checkSceneStatus()
+	d.checkOrphanLinks()
+	d.checkSearchIndex()
+
+	if _, err := writeDoctorReport(d.report); err != nil {
+		tlog.Errorf("Failed to write report: %v", err)
+		return
+	}
+
+	tlog.Infof("Found %v issues, repaired %v", len(d.report.Issues), d.report.Repaired)
+	common.PublishWS("doctor.done", map[string]interface{}{"issues": len(d.report.Issues), "repaired": d.report.Repaired, "report_file": d.report.ReportFile})
+}
+
+// checkMissingFiles only looks at local volumes that are online, an unplugged drive would otherwise empty the library
+func (d *libraryDoctor) checkMissingFiles() {
+	var files []models.File
+	d.db.Preload("Volume").
+		Where("volume_id IN (SELECT id FROM volumes WHERE type = ? AND is_available = ?)", "local", true).
+		Find(&files)
+
+	// The available flag is only as fresh as the last rescan, a volume unplugged since looks like all its files are gone
+	mounts := newMountedVolumes()
+	for i := range files {
+		fl := files[i]
+		if !mounts.isMounted(fl.Volume) {
+			continue
+		}
+		if fl.Exists() {
+			continue
+		}
+		sceneID := d.sceneIDOf(fl.SceneID)
+		d.addIssue(DoctorIssue{Category: DoctorMissingFile, SceneID: sceneID, FileID: fl.ID, Detail: fl.GetPath()}, func() error {
+			if err := d.db.Delete(&models.File{}, fl.ID).Error; err != nil {
+				return err
+			}
+			models.AddAction(sceneID, "repair", "files", "removed missing file "+fl.GetPath())
+			if fl.SceneID != 0 {
+				var scene models.Scene
+				if scene.GetIfExistByPK(fl.SceneID) == nil {
+					scene.UpdateStatus()
+				}
+			}
+			return nil
+		})
+	}
+}
+
+func (d *libraryDoctor) checkMissingScenes() {
+	var files []models.File
+	d.db.Where("scene_id <> 0 AND scene_id NOT IN (SELECT id FROM scenes)").Find(&files)
+
+	for i := range files {
+		fl := files[i]
+		d.addIssue(DoctorIssue{Category: DoctorMissingScene, FileID: fl.ID, Detail: fmt.Sprintf("%v is matched to deleted scene %v", fl.GetPath(), fl.SceneID)}, func() error {
+			if err := d.db.Model(&models.File{}).Where("id = ?", fl.ID).Update("scene_id", 0).Error; err != nil {
+				return err
+			}
+			models.AddAction("", "repair", "scene_id", fmt.Sprintf("unmatched %v from deleted scene %v", fl.GetPath(), fl.SceneID))
+			return nil
+		})
+	}
+}
+
+// checkOrphanScripts reports scripts without a video, they are left alone as the script may still be wanted
+func (d *libraryDoctor) checkOrphanScripts() {
+	var files []models.File
+	d.db.Where("type IN (?)", []string{"video", "script"}).Find(&files)
+
+	for _, fl := range findOrphanScripts(files) {
+		d.addIssue(DoctorIssue{Category: DoctorOrphanScript, SceneID: d.sceneIDOf(fl.SceneID), FileID: fl.ID, Detail: fl.GetPath()}, nil)
+	}
+}
+
+// findOrphanScripts returns scripts matched to a scene without videos, or unmatched scripts without a video of the same name next to them
+func findOrphanScripts(files []models.File) []models.File {
+	sceneVideos := map[uint]bool{}
+	videoNames := map[string]bool{}
+	for _, f := range files {
+		if f.Type != "video" {
+			continue
+		}
+		if f.SceneID != 0 {
+			sceneVideos[f.SceneID] = true
+		}
+		videoNames[strings.ToLower(filepath.Join(f.Path, strings.TrimSuffix(f.Filename, filepath.Ext(f.Filename))))] = true
+	}
+
+	var out []models.File
+	for _, f := range files {
+		if f.Type != "script" {
+			continue
+		}
+		if f.SceneID != 0 {
+			if !sceneVideos[f.SceneID] {
+				out = append(out, f)
+			}
+			continue
+		}
+		if !videoNames[strings.ToLower(filepath.Join(f.Path, strings.TrimSuffix(f.Filename, filepath.Ext(f.Filename))))] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// checkStaleFilenames looks for names added to a scene by matching a file, when no file has that name anymore.
+// Names from scene data are what the studio released and are expected to have no file.
+func (d *libraryDoctor) checkStaleFilenames() {
+	var actions []models.Action
+	d.db.Where("action_type = ? AND changed_column = ?", "match", "filenames_arr").Find(&actions)
+	matched := map[string]map[string]bool{}
+	for _, a := range actions {
+		if name := matchAddedFilename(a.NewValue); name != "" {
+			if matched[a.SceneID] == nil {
+				matched[a.SceneID] = map[string]bool{}
+			}
+			matched[a.SceneID][name] = true
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	var names []string
+	d.db.Model(&models.File{}).Pluck("filename", &names)
+	existing := map[string]bool{}
+	for _, n := range names {
+		existing[n] = true
+	}
+
+	for sceneID, added := range matched {
+		var scene models.Scene
+		if err := d.db.Where(&models.Scene{SceneID: sceneID}).First(&scene).Error; err != nil {
+			continue
+		}
+		var current []string
+		json.Unmarshal([]byte(scene.FilenamesArr), &current)
+
+		for _, name := range findStaleFilenames(current, added, existing) {
+			name := name
+			d.addIssue(DoctorIssue{Category: DoctorStaleFilename, SceneID: sceneID, Detail: name}, func() error {
+				var s models.Scene
+				if err := d.db.Where(&models.Scene{SceneID: sceneID}).First(&s).Error; err != nil {
+					return err
+				}
+				var arr []string
+				json.Unmarshal([]byte(s.FilenamesArr), &arr)
+				kept := []string{}
+				for _, n := range arr {
+					if n != name {
+						kept = append(kept, n)
+					}
+				}
+				tmp, _ := json.Marshal(kept)
+				if err := d.db.Model(&models.Scene{}).Where("id = ?", s.ID).Update("filenames_arr", string(tmp)).Error; err != nil {
+					return err
+				}
+				models.AddAction(sceneID, "repair", "filenames_arr", string(tmp))
+				return nil
+			})
+		}
+	}
+}
+
+// matchAddedFilename returns the name a match action added, matching appends the file name to the end of the list
+func matchAddedFilename(value string) string {
+	var arr []string
+	if err := json.Unmarshal([]byte(value), &arr); err != nil || len(arr) == 0 {
+		return ""
+	}
+	return arr[len(arr)-1]
+}
+
+func findStaleFilenames(current []string, added map[string]bool, existing map[string]bool) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, n := range current {
+		if added[n] && !existing[n] && !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (d *libraryDoctor) checkSceneStatus() {
+	var files []models.File
+	d.db.Preload("Volume").Where("scene_id <> 0").Find(&files)
+	byScene := map[uint][]models.File{}
+	for _, f := range files {
+		byScene[f.SceneID] = append(byScene[f.SceneID], f)
+	}
+
+	var scenes []models.Scene
+	d.db.Select("id, scene_id, is_available, is_accessible, is_scripted").
+		Where("is_available = ? OR is_accessible = ? OR is_scripted = ? OR id IN (SELECT scene_id FROM files)", true, true, true).
+		Find(&scenes)
+
+	for i := range scenes {
+		s := scenes[i]
+		available, accessible, scripted := expectedSceneStatus(byScene[s.ID])
+		var diff []string
+		if available != s.IsAvailable {
+			diff = append(diff, fmt.Sprintf("is_available=%v", available))
+		}
+		if accessible != s.IsAccessible {
+			diff = append(diff, fmt.Sprintf("is_accessible=%v", accessible))
+		}
+		if scripted != s.IsScripted {
+			diff = append(diff, fmt.Sprintf("is_scripted=%v", scripted))
+		}
+		if len(diff) == 0 {
+			continue
+		}
+
+		d.addIssue(DoctorIssue{Category: DoctorStaleStatus, SceneID: s.SceneID, Detail: strings.Join(diff, ", ")}, func() error {
+			var scene models.Scene
+			if err := scene.GetIfExistByPK(s.ID); err != nil {
+				return err
+			}
+			scene.UpdateStatus()
+			for _, change := range diff {
+				kv := strings.SplitN(change, "=", 2)
+				models.AddAction(s.SceneID, "repair", kv[0], kv[1])
+			}
+			return nil
+		})
+	}
+}
+
+// expectedSceneStatus mirrors Scene.UpdateStatus for the flags a scene should have with these files
+func expectedSceneStatus(files []models.File) (available bool, accessible bool, scripted bool) {
+	for i := range files {
+		switch files[i].Type {
+		case "video":
+			available = true
+			if !accessible && files[i].Exists() {
+				accessible = true
+			}
+		case "script":
+			scripted = true
+		}
+	}
+	return
+}
+
+func (d *libraryDoctor) checkOrphanLinks() {
+	var links []models.ExternalReferenceLink
+	d.db.Where("(internal_table = ? AND internal_db_id NOT IN (SELECT id FROM scenes)) OR external_reference_id NOT IN (SELECT id FROM external_references)", "scenes").
+		Find(&links)
+
+	for i := range links {
+		link := links[i]
+		detail := fmt.Sprintf("%v %v linked to %v %v", link.ExternalSource, link.ExternalId, link.InternalTable, link.InternalNameId)
+		d.addIssue(DoctorIssue{Category: DoctorOrphanLink, SceneID: link.InternalNameId, Detail: detail}, func() error {
+			if err := d.db.Delete(&models.ExternalReferenceLink{}, link.ID).Error; err != nil {
+				return err
+			}
+			models.AddAction(link.InternalNameId, "repair", "external_reference_link", "removed "+detail)
+			return nil
+		})
+	}
+}
+
+func (d *libraryDoctor) checkSearchIndex() {
+	if models.CheckLock("index") {
+		d.tlog.Infof("Skipping search index check, indexing is running")
+		d.report.Skipped = append(d.report.Skipped, DoctorIndexMissing, DoctorIndexOrphan)
+		return
+	}
+	models.CreateLock("index")
+	defer models.RemoveLock("index")
+
+	idx, err := NewIndex("scenes")
+	if err != nil {
+		d.tlog.Errorf("Failed to open search index: %v", err)
+		d.report.Skipped = append(d.report.Skipped, DoctorIndexMissing, DoctorIndexOrphan)
+		return
+	}
+	defer idx.Bleve.Close()
+
+	count, _ := idx.Bleve.DocCount()
+	res, err := idx.Bleve.Search(bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(count), 0, false))
+	if err != nil {
+		d.tlog.Errorf("Failed to read search index: %v", err)
+		d.report.Skipped = append(d.report.Skipped, DoctorIndexMissing, DoctorIndexOrphan)
+		return
+	}
+	indexed := map[string]bool{}
+	for _, hit := range res.Hits {
+		indexed[hit.ID] = true
+	}
+
+	var sceneIDs []string
+	d.db.Model(&models.Scene{}).Pluck("scene_id", &sceneIDs)
+	inDB := map[string]bool{}
+	for _, id := range sceneIDs {
+		inDB[id] = true
+		if indexed[id] {
+			continue
+		}
+		id := id
+		d.addIssue(DoctorIssue{Category: DoctorIndexMissing, SceneID: id, Detail: "scene is not in the search index"}, func() error {
+			var scene models.Scene
+			if err := d.db.Preload("Cast").Where(&models.Scene{SceneID: id}).First(&scene).Error; err != nil {
+				return err
+			}
+			if err := idx.PutScene(scene); err != nil {
+				return err
+			}
+			models.AddAction(id, "repair", "search_index", "added")
+			return nil
+		})
+	}
+
+	for id := range indexed {
+		if inDB[id] {
+			continue
+		}
+		id := id
+		d.addIssue(DoctorIssue{Category: DoctorIndexOrphan, SceneID: id, Detail: "search index has a deleted scene"}, func() error {
+			if err := idx.Bleve.Delete(id); err != nil {
+				return err
+			}
+			models.AddAction(id, "repair", "search_index", "removed")
+			return nil
+		})
+	}
+}
+
+func (d *libraryDoctor) sceneIDOf(id uint) string {
+	if id == 0 {
+		return ""
+	}
+	var scene models.Scene
+	d.db.Select("scene_id").Where("id = ?", id).First(&scene)
+	return scene.SceneID
+}
+
+func writeDoctorReport(report *DoctorReport) (string, error) {
+	report.ReportFile = doctorReports.fileName(fmt.Sprint(report.GeneratedAt.Unix()))
+	if err := doctorReports.write(report.ReportFile, report); err != nil {
+		return "", err
+	}
+	return report.ReportFile, nil
+}
+
+// GetLastDoctorReport loads the report of the most recent health check, it can also be downloaded from /download/<report_file>
+func GetLastDoctorReport() (*DoctorReport, int, error) {
+	var report DoctorReport
+	if statusCode, err := doctorReports.readLast(&report); err != nil {
+		return nil, statusCode, err
+	}
+	return &report, 200, nil
+}
@@ -0,0 +1,91 @@
+package tasks
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func TestFindOrphanScripts(t *testing.T) {
+	files := []models.File{
+		{ID: 1, Type: "video", Path: "/vr", Filename: "scene1.mp4", SceneID: 10},
+		{ID: 2, Type: "script", Path: "/vr", Filename: "scene1.funscript", SceneID: 10},
+		// Matched to a scene that lost its video
+		{ID: 3, Type: "script", Path: "/vr", Filename: "scene2.funscript", SceneID: 11},
+		// Unmatched, video next to it
+		{ID: 4, Type: "video", Path: "/vr", Filename: "Scene3.MP4"},
+		{ID: 5, Type: "script", Path: "/vr", Filename: "scene3.funscript"},
+		// Unmatched, video gone
+		{ID: 6, Type: "script", Path: "/vr", Filename: "scene4.funscript"},
+	}
+
+	orphans := findOrphanScripts(files)
+	if len(orphans) != 2 || orphans[0].ID != 3 || orphans[1].ID != 6 {
+		t.Fatalf("expected scripts 3 and 6 to be orphans, got %+v", orphans)
+	}
+}
+
+func TestFindStaleFilenames(t *testing.T) {
+	if name := matchAddedFilename(`["studio_name.mp4","my copy.mp4"]`); name != "my copy.mp4" {
+		t.Fatalf("expected the last name of a match action, got %q", name)
+	}
+	if name := matchAddedFilename(`not json`); name != "" {
+		t.Fatalf("expected no name for a broken action, got %q", name)
+	}
+
+	current := []string{"studio_name.mp4", "old copy.mp4", "renamed.mp4", "old copy.mp4"}
+	added := map[string]bool{"old copy.mp4": true, "renamed.mp4": true}
+	existing := map[string]bool{"renamed.mp4": true}
+
+	stale := findStaleFilenames(current, added, existing)
+	if len(stale) != 1 || stale[0] != "old copy.mp4" {
+		t.Fatalf("expected only the matched name without a file, got %v", stale)
+	}
+}
+
+func TestExpectedSceneStatus(t *testing.T) {
+	remote := models.Volume{Type: "putio"}
+	available, accessible, scripted := expectedSceneStatus([]models.File{
+		{Type: "video", Volume: remote},
+		{Type: "script", Volume: remote},
+	})
+	if !available || !accessible || !scripted {
+		t.Fatalf("expected a scene with a video and a script to be available, accessible and scripted")
+	}
+
+	available, accessible, scripted = expectedSceneStatus([]models.File{
+		{Type: "video", Path: "/does/not/exist", Filename: "scene.mp4", Volume: models.Volume{Type: "local"}},
+	})
+	if !available || accessible || scripted {
+		t.Fatalf("expected a missing local video to leave the scene available but not accessible")
+	}
+
+	if available, accessible, scripted = expectedSceneStatus(nil); available || accessible || scripted {
+		t.Fatalf("expected a scene without files to have no flags")
+	}
+}
+
+func TestCheckMissingFiles_SkipsUnmountedVolumes(t *testing.T) {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "here.mp4"), "x")
+
+	mounted := models.Volume{Type: "local", Path: root, IsAvailable: true}
+	db.Create(&mounted)
+	// Still flagged available, it was unplugged after the last rescan
+	unplugged := models.Volume{Type: "local", Path: filepath.Join(root, "unplugged"), IsAvailable: true}
+	db.Create(&unplugged)
+
+	db.Create(&models.File{Type: "video", VolumeID: mounted.ID, Path: root, Filename: "here.mp4"})
+	db.Create(&models.File{Type: "video", VolumeID: mounted.ID, Path: root, Filename: "gone.mp4"})
+	db.Create(&models.File{Type: "video", VolumeID: unplugged.ID, Path: unplugged.Path, Filename: "away.mp4"})
+
+	d := &libraryDoctor{db: db, tlog: logrus.NewEntry(logrus.New()), repair: map[string]bool{}, report: &DoctorReport{Counts: map[string]int{}}}
+	d.checkMissingFiles()
+
+	if len(d.report.Issues) != 1 || d.report.Issues[0].Detail != filepath.Join(root, "gone.mp4") {
+		t.Fatalf("expected only the file gone from the mounted volume to be reported, got %+v", d.report.Issues)
+	}
+}
@@ -0,0 +1,263 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/jinzhu/gorm"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+const (
+	DuplicateKindOsHash       = "oshash"
+	DuplicateKindSizeDuration = "size_duration"
+	DuplicateKindScene        = "scene"
+)
+
+type DuplicateFile struct {
+	FileID      uint    `json:"file_id"`
+	SceneID     uint    `json:"scene_id"`
+	VolumeID    uint    `json:"volume_id"`
+	VolumePath  string  `json:"volume_path"`
+	IsAvailable bool    `json:"is_available"`
+	Path        string  `json:"path"`
+	Filename    string  `json:"filename"`
+	Size        int64   `json:"size"`
+	OsHash      string  `json:"oshash"`
+	Duration    float64 `json:"duration"`
+	Width       int     `json:"video_width"`
+	Height      int     `json:"video_height"`
+	BitRate     int     `json:"video_bitrate"`
+	CodecName   string  `json:"video_codec_name"`
+	Projection  string  `json:"projection"`
+}
+
+type DuplicateGroup struct {
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key"`
+	SceneID   uint            `json:"scene_id"`
+	TotalSize int64           `json:"total_size"`
+	Files     []DuplicateFile `json:"files"`
+}
+
+type DuplicateReport struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Files       int              `json:"files"`
+	Groups      []DuplicateGroup `json:"groups"`
+	// Bytes that would be freed by keeping one file of every exact copy group
+	Reclaimable int64  `json:"reclaimable"`
+	ReportFile  string `json:"report_file"`
+}
+
+func toDuplicateFile(f models.File) DuplicateFile {
+	return DuplicateFile{
+		FileID:      f.ID,
+		SceneID:     f.SceneID,
+		VolumeID:    f.VolumeID,
+		VolumePath:  f.Volume.Path,
+		IsAvailable: f.Volume.IsAvailable,
+		Path:        f.Path,
+		Filename:    f.Filename,
+		Size:        f.Size,
+		OsHash:      f.OsHash,
+		Duration:    f.VideoDuration,
+		Width:       f.VideoWidth,
+		Height:      f.VideoHeight,
+		BitRate:     f.VideoBitRate,
+		CodecName:   f.VideoCodecName,
+		Projection:  f.VideoProjection,
+	}
+}
+
+// groupDuplicateFiles groups videos that are exact copies (same oshash), most likely copies (same size and
+// duration, but hashed differently or not hashed yet) and different files of the same scene. A scene group lists
+// every file of the scene, so copies in it can also be in a more certain group. A group is only left out when
+// all of its files are already grouped under a more certain kind.
+func groupDuplicateFiles(files []models.File) []DuplicateGroup {
+	byHash := map[string][]models.File{}
+	bySizeDuration := map[string][]models.File{}
+	byScene := map[uint][]models.File{}
+	for _, f := range files {
+		if f.OsHash != "" {
+			byHash[f.OsHash] = append(byHash[f.OsHash], f)
+		}
+		if f.Size > 0 && f.VideoDuration > 0 {
+			key := fmt.Sprintf("%d-%.1f", f.Size, f.VideoDuration)
+			bySizeDuration[key] = append(bySizeDuration[key], f)
+		}
+		if f.SceneID != 0 {
+			byScene[f.SceneID] = append(byScene[f.SceneID], f)
+		}
+	}
+
+	var groups []DuplicateGroup
+	addGroup := func(kind string, key string, members []models.File) {
+		group := DuplicateGroup{Kind: kind, Key: key}
+		for _, f := range members {
+			group.Files = append(group.Files, toDuplicateFile(f))
+			group.TotalSize += f.Size
+		}
+		if kind == DuplicateKindScene {
+			group.SceneID = members[0].SceneID
+		}
+		sort.Slice(group.Files, func(i, j int) bool { return group.Files[i].FileID < group.Files[j].FileID })
+		groups = append(groups, group)
+	}
+
+	for hash, members := range byHash {
+		if len(members) > 1 {
+			addGroup(DuplicateKindOsHash, hash, members)
+		}
+	}
+	for key, members := range bySizeDuration {
+		if len(members) > 1 && !sameOsHash(members) {
+			addGroup(DuplicateKindSizeDuration, key, members)
+		}
+	}
+	for sceneID, members := range byScene {
+		if len(members) > 1 && !sameOsHash(members) && !sameSizeDuration(members) {
+			addGroup(DuplicateKindScene, fmt.Sprintf("%d", sceneID), members)
+		}
+	}
+
+	kindOrder := map[string]int{DuplicateKindOsHash: 0, DuplicateKindSizeDuration: 1, DuplicateKindScene: 2}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Kind != groups[j].Kind {
+			return kindOrder[groups[i].Kind] < kindOrder[groups[j].Kind]
+		}
+		if groups[i].TotalSize != groups[j].TotalSize {
+			return groups[i].TotalSize > groups[j].TotalSize
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
+
+func sameOsHash(files []models.File) bool {
+	for _, f := range files {
+		if f.OsHash == "" || f.OsHash != files[0].OsHash {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSizeDuration(files []models.File) bool {
+	for _, f := range files {
+		if f.Size != files[0].Size || fmt.Sprintf("%.1f", f.VideoDuration) != fmt.Sprintf("%.1f", files[0].VideoDuration) {
+			return false
+		}
+	}
+	return true
+}
+
+func findDuplicateGroups(db *gorm.DB) ([]DuplicateGroup, int, error) {
+	var files []models.File
+	if err := db.Preload("Volume").Where("type = ?", "video").Find(&files).Error; err != nil {
+		return nil, 0, err
+	}
+	return groupDuplicateFiles(files), len(files), nil
+}
+
+func DuplicateDetection() {
+	tlog := log.WithField("task", "duplicates")
+	if models.CheckLock("duplicates") {
+		tlog.Infof("skipped: task already running")
+		return
+	}
+	models.CreateLock("duplicates")
+	defer models.RemoveLock("duplicates")
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	tlog.Infof("Looking for duplicate videos")
+	groups, fileCount, err := findDuplicateGroups(db)
+	if err != nil {
+		tlog.Errorf("Failed to load files: %v", err)
+		return
+	}
+
+	report := DuplicateReport{GeneratedAt: time.Now(), Files: fileCount, Groups: groups}
+	for _, g := range groups {
+		if g.Kind == DuplicateKindOsHash {
+			report.Reclaimable += g.TotalSize - g.Files[0].Size
+		}
+	}
+	if _, err := writeDuplicateReport(&report); err != nil {
+		tlog.Errorf("Failed to write report: %v", err)
+		return
+	}
+
+	tlog.Infof("Found %v groups of duplicate videos, %v reclaimable", len(groups), humanize.Bytes(uint64(report.Reclaimable)))
+	common.PublishWS("duplicates.done", map[string]interface{}{"groups": len(groups), "report_file": report.ReportFile})
+}
+
+func writeDuplicateReport(report *DuplicateReport) (string, error) {
+	report.ReportFile = duplicateReports.fileName(fmt.Sprint(report.GeneratedAt.Unix()))
+	if err := duplicateReports.write(report.ReportFile, report); err != nil {
+		return "", err
+	}
+	return report.ReportFile, nil
+}
+
+// GetLastDuplicateReport loads the report of the most recent duplicate detection, it can also be downloaded from /download/<report_file>
+func GetLastDuplicateReport() (*DuplicateReport, int, error) {
+	var report DuplicateReport
+	if statusCode, err := duplicateReports.readLast(&report); err != nil {
+		return nil, statusCode, err
+	}
+	return &report, 200, nil
+}
+
+// CheckDuplicateDeletion verifies that files can be removed as duplicates. Deletes have to be enabled, every
+// file has to be in a duplicate group of the current library, and each group has to keep at least one copy.
+func CheckDuplicateDeletion(fileIDs []uint) (int, error) {
+	if !config.Config.Storage.AllowDuplicateDeletes {
+		return 403, errors.New("deleting duplicates is not enabled")
+	}
+	if len(fileIDs) == 0 {
+		return 400, errors.New("no files selected")
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	groups, _, err := findDuplicateGroups(db)
+	if err != nil {
+		return 500, err
+	}
+	return checkDuplicateDeletion(groups, fileIDs)
+}
+
+func checkDuplicateDeletion(groups []DuplicateGroup, fileIDs []uint) (int, error) {
+	selected := map[uint]bool{}
+	for _, id := range fileIDs {
+		selected[id] = true
+	}
+
+	grouped := map[uint]bool{}
+	for _, g := range groups {
+		kept := 0
+		for _, f := range g.Files {
+			grouped[f.FileID] = true
+			if !selected[f.FileID] {
+				kept++
+			}
+		}
+		if kept == 0 {
+			return 400, fmt.Errorf("all files of duplicate group %v would be deleted", g.Key)
+		}
+	}
+	for id := range selected {
+		if !grouped[id] {
+			return 400, fmt.Errorf("file %v is not a duplicate", id)
+		}
+	}
+	return 200, nil
+}
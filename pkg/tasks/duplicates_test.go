@@ -0,0 +1,81 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func TestGroupDuplicateFiles(t *testing.T) {
+	files := []models.File{
+		// Exact copies on two volumes
+		{ID: 1, VolumeID: 1, OsHash: "aaaa", Size: 100, VideoDuration: 60, SceneID: 10},
+		{ID: 2, VolumeID: 2, OsHash: "aaaa", Size: 100, VideoDuration: 60, SceneID: 10},
+		// Same size and duration, one not hashed yet
+		{ID: 3, OsHash: "", Size: 200, VideoDuration: 90.01},
+		{ID: 4, OsHash: "bbbb", Size: 200, VideoDuration: 90.04},
+		// Another encode of scene 10
+		{ID: 5, OsHash: "cccc", Size: 50, VideoDuration: 60, SceneID: 10},
+		// Unique file
+		{ID: 6, OsHash: "dddd", Size: 300, VideoDuration: 120, SceneID: 11},
+	}
+
+	groups := groupDuplicateFiles(files)
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %d: %+v", len(groups), groups)
+	}
+
+	want := []struct {
+		kind string
+		ids  []uint
+	}{
+		{DuplicateKindOsHash, []uint{1, 2}},
+		{DuplicateKindSizeDuration, []uint{3, 4}},
+		{DuplicateKindScene, []uint{1, 2, 5}},
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.Kind != w.kind {
+			t.Fatalf("group %d: expected kind %s, got %s", i, w.kind, g.Kind)
+		}
+		if len(g.Files) != len(w.ids) {
+			t.Fatalf("group %d: expected %d files, got %d", i, len(w.ids), len(g.Files))
+		}
+		for j, id := range w.ids {
+			if g.Files[j].FileID != id {
+				t.Fatalf("group %d: expected file %d at %d, got %d", i, id, j, g.Files[j].FileID)
+			}
+		}
+	}
+	if groups[2].SceneID != 10 {
+		t.Fatalf("expected scene group for scene 10, got %d", groups[2].SceneID)
+	}
+}
+
+func TestGroupDuplicateFiles_SceneCopiesReportedOnce(t *testing.T) {
+	files := []models.File{
+		{ID: 1, OsHash: "aaaa", Size: 100, VideoDuration: 60, SceneID: 10},
+		{ID: 2, OsHash: "aaaa", Size: 100, VideoDuration: 60, SceneID: 10},
+	}
+	groups := groupDuplicateFiles(files)
+	if len(groups) != 1 || groups[0].Kind != DuplicateKindOsHash {
+		t.Fatalf("expected a single oshash group, got %+v", groups)
+	}
+}
+
+func TestCheckDuplicateDeletion(t *testing.T) {
+	groups := []DuplicateGroup{
+		{Kind: DuplicateKindOsHash, Key: "aaaa", Files: []DuplicateFile{{FileID: 1}, {FileID: 2}}},
+		{Kind: DuplicateKindScene, Key: "10", Files: []DuplicateFile{{FileID: 1}, {FileID: 2}, {FileID: 5}}},
+	}
+
+	if status, err := checkDuplicateDeletion(groups, []uint{2}); err != nil {
+		t.Fatalf("expected deleting one copy to be allowed, got %d %v", status, err)
+	}
+	if _, err := checkDuplicateDeletion(groups, []uint{1, 2}); err == nil {
+		t.Fatalf("expected deleting every exact copy to be refused")
+	}
+	if _, err := checkDuplicateDeletion(groups, []uint{7}); err == nil {
+		t.Fatalf("expected deleting a file outside the groups to be refused")
+	}
+}
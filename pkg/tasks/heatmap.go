@@ -69,7 +69,8 @@ func GenerateHeatmaps(tlog *logrus.Entry) {
 			if tlog != nil && (i%50) == 0 {
 				tlog.Infof("Generating heatmaps (%v/%v)", i+1, len(scriptfiles))
 			}
-			if file.Exists() {
+			// Scripts on remote volumes are not rendered, they are only read when served
+			if file.Exists() && !file.Volume.IsRemote() {
 				path := file.GetPath()
 				if strings.HasSuffix(path, ".funscript") {
 					log.Infof("Rendering %v", file.Filename)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -18,7 +19,13 @@ func HashFile(file *os.File) (hash uint64, err error) {
 	if err != nil {
 		return
 	}
-	if fi.Size() < ChunkSize {
+	return HashReaderAt(file, fi.Size())
+}
+
+// HashReaderAt generates an OSDB hash from the head and tail of a file of the given size,
+// remote files are hashed without downloading them.
+func HashReaderAt(file io.ReaderAt, size int64) (hash uint64, err error) {
+	if size < ChunkSize {
 		return 0, fmt.Errorf("file is too small")
 	}
 
@@ -28,7 +35,7 @@ func HashFile(file *os.File) (hash uint64, err error) {
 	if err != nil {
 		return
 	}
-	err = readChunk(file, fi.Size()-ChunkSize, buf[ChunkSize:])
+	err = readChunk(file, size-ChunkSize, buf[ChunkSize:])
 	if err != nil {
 		return
 	}
@@ -44,7 +51,7 @@ func HashFile(file *os.File) (hash uint64, err error) {
 		hash += num
 	}
 
-	return hash + uint64(fi.Size()), nil
+	return hash + uint64(size), nil
 }
 
 // Hash generates an OSDB hash for a file.
@@ -58,11 +65,12 @@ func Hash(path string) (uint64, error) {
 }
 
 // Read a chunk of a file at `offset` so as to fill `buf`.
-func readChunk(file *os.File, offset int64, buf []byte) (err error) {
+func readChunk(file io.ReaderAt, offset int64, buf []byte) (err error) {
 	n, err := file.ReadAt(buf, offset)
-	if err != nil {
+	if err != nil && !(err == io.EOF && n == len(buf)) {
 		return
 	}
+	err = nil
 	if n != ChunkSize {
 		return fmt.Errorf("invalid read %v", n)
 	}
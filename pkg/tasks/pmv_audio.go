@@ -0,0 +1,814 @@
+package tasks
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
+	"github.com/xbapps/xbvr/pkg/scrape"
+)
+
+// Audio fingerprints follow the chromaprint approach: the soundtrack is decoded to 11025 Hz mono, folded into
+// a 12 bin chroma image and every frame is reduced to a 32 bit sub-fingerprint comparing neighbouring pitch
+// classes and frames. The upper bits of each sub-fingerprint are used as landmarks, matching votes on the time
+// offset between equal landmarks and then counts the frames that agree within a few bits at that offset.
+const (
+	pmvAudioSampleRate     = 11025
+	pmvAudioFrameSize      = 4096
+	pmvAudioFrameStep      = pmvAudioFrameSize / 3
+	pmvAudioMinFreq        = 28.0
+	pmvAudioMaxFreq        = 3520.0
+	pmvAudioMaxSeconds     = 300
+	pmvAudioTimeout        = 3 * time.Minute
+	pmvAudioLandmarkShift  = 12
+	pmvAudioMaxLandmarkHit = 50
+	pmvAudioMinVotes       = 3
+	pmvAudioMaxBitErrors   = 6
+	pmvAudioMinSongFrames  = 80
+	pmvAudioFullMatchRatio = 0.6
+	pmvAudioMinSceneScore  = 0.5
+	pmvAudioMinSongSeconds = 5.0
+	pmvAudioLandmarkBatch  = 500
+
+	pmvAudioKindScene = "scene"
+	pmvAudioKindSong  = "song"
+)
+
+type pmvAudioSceneHit struct {
+	SceneID string
+	Score   float64
+}
+
+type pmvAudioIdentification struct {
+	SceneHits []pmvAudioSceneHit
+	Songs     []scrape.PMVSong
+}
+
+type PMVAudioSongRequest struct {
+	FileID uint    `json:"file_id"`
+	Artist string  `json:"artist"`
+	Title  string  `json:"title"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+}
+
+type PMVAudioPopulateResult struct {
+	Scanned int `json:"scanned"`
+	Added   int `json:"added"`
+	Errors  int `json:"errors"`
+}
+
+func pmvAudioFramesPerSecond() float64 {
+	return float64(pmvAudioSampleRate) / float64(pmvAudioFrameStep)
+}
+
+// ensureFileAudioFingerprint returns the stored soundtrack fingerprint of a file, computing it with ffmpeg on first use.
+// Dry runs compute it without storing it.
+func ensureFileAudioFingerprint(db *gorm.DB, file *models.File, dryRun bool) ([]uint32, error) {
+	if fp := decodeAudioFingerprint(file.AudioFingerprint); len(fp) > 0 {
+		return fp, nil
+	}
+	if file.Volume.Type != "local" || !file.Exists() {
+		return nil, fmt.Errorf("file %s is not accessible", file.GetPath())
+	}
+
+	fp, err := extractAudioFingerprint(file.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	file.AudioFingerprint = encodeAudioFingerprint(fp)
+	if dryRun {
+		return fp, nil
+	}
+	if err := db.Model(file).Update("audio_fingerprint", file.AudioFingerprint).Error; err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+func extractAudioFingerprint(path string) ([]uint32, error) {
+	pcm, err := runFFmpegOutputTimeout(pmvAudioTimeout, nil,
+		"-v", "error",
+		"-i", path,
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(pmvAudioSampleRate),
+		"-t", strconv.Itoa(pmvAudioMaxSeconds),
+		"-f", "s16le",
+		"pipe:1",
+	)
+	if err != nil {
+		return nil, err
+	}
+	fp := audioFingerprintFromPCM(pcm)
+	if len(fp) == 0 {
+		return nil, errors.New("ffmpeg returned no audio")
+	}
+	return fp, nil
+}
+
+// audioFingerprintFromPCM fingerprints signed 16 bit little endian mono samples
+func audioFingerprintFromPCM(pcm []byte) []uint32 {
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / 32768
+	}
+	if len(samples) < pmvAudioFrameSize {
+		return nil
+	}
+
+	window := make([]float64, pmvAudioFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(pmvAudioFrameSize-1))
+	}
+	binClass := make([]int, pmvAudioFrameSize/2)
+	for k := range binClass {
+		freq := float64(k) * pmvAudioSampleRate / pmvAudioFrameSize
+		if freq < pmvAudioMinFreq || freq > pmvAudioMaxFreq {
+			binClass[k] = -1
+			continue
+		}
+		note := int(math.Round(12 * math.Log2(freq/440)))
+		binClass[k] = ((note % 12) + 12) % 12
+	}
+
+	var chroma [][12]float64
+	frame := make([]complex128, pmvAudioFrameSize)
+	for start := 0; start+pmvAudioFrameSize <= len(samples); start += pmvAudioFrameStep {
+		for i := range frame {
+			frame[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(frame)
+
+		var c [12]float64
+		for k, class := range binClass {
+			if class < 0 {
+				continue
+			}
+			mag := cmplx.Abs(frame[k])
+			c[class] += mag * mag
+		}
+		norm := 0.0
+		for _, v := range c {
+			norm += v * v
+		}
+		if norm = math.Sqrt(norm); norm > 1e-9 {
+			for i := range c {
+				c[i] /= norm
+			}
+		}
+		chroma = append(chroma, c)
+	}
+
+	var fp []uint32
+	for t := 3; t < len(chroma); t++ {
+		fp = append(fp, chromaSubFingerprint(chroma, t))
+	}
+	return fp
+}
+
+// chromaSubFingerprint packs 12 bits of pitch change over time, 12 bits of neighbouring pitch classes
+// and 8 bits comparing major thirds over two frames
+func chromaSubFingerprint(chroma [][12]float64, t int) uint32 {
+	var v uint32
+	cur, prev, prev2 := chroma[t], chroma[t-1], chroma[t-2]
+	for i := 0; i < 12; i++ {
+		if cur[i] > prev[i] {
+			v |= 1 << uint(i)
+		}
+		if cur[i] > cur[(i+1)%12] {
+			v |= 1 << uint(12+i)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		if cur[i]+cur[(i+4)%12] > prev2[i]+prev2[(i+4)%12] {
+			v |= 1 << uint(24+i)
+		}
+	}
+	return v
+}
+
+// fft is an in place iterative radix-2 transform, len(a) must be a power of two
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := a[start+k]
+				v := a[start+k+size/2] * w
+				a[start+k] = u + v
+				a[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
+
+type audioFingerprintIndex map[uint32][]int
+
+func newAudioFingerprintIndex(fp []uint32) audioFingerprintIndex {
+	idx := audioFingerprintIndex{}
+	for i, v := range fp {
+		if v == 0 {
+			continue
+		}
+		key := v >> pmvAudioLandmarkShift
+		idx[key] = append(idx[key], i)
+	}
+	return idx
+}
+
+// landmarks returns the distinct landmarks of an indexed fingerprint, leaving out those shared by too many
+// frames to vote in alignAudioFingerprints
+func (idx audioFingerprintIndex) landmarks() []uint32 {
+	var out []uint32
+	for key, positions := range idx {
+		if len(positions) <= pmvAudioMaxLandmarkHit {
+			out = append(out, key)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// indexAudioFingerprints fills the landmark table for entries added or changed since the last match
+func indexAudioFingerprints(db *gorm.DB) error {
+	var entries []models.AudioFingerprint
+	if err := db.Where("indexed = ?", false).Find(&entries).Error; err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		tx := db.Begin()
+		if err := tx.Where("fingerprint_id = ?", entry.ID).Delete(&models.AudioLandmark{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, landmark := range newAudioFingerprintIndex(decodeAudioFingerprint(entry.Fingerprint)).landmarks() {
+			if err := tx.Create(&models.AudioLandmark{Landmark: landmark, FingerprintID: entry.ID}).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Model(&entry).UpdateColumn("indexed", true).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audioFingerprintCandidates returns the entries sharing enough landmarks with the file to possibly align
+func audioFingerprintCandidates(db *gorm.DB, fileID uint, idx audioFingerprintIndex) ([]models.AudioFingerprint, error) {
+	landmarks := idx.landmarks()
+	hits := map[uint]int{}
+	for start := 0; start < len(landmarks); start += pmvAudioLandmarkBatch {
+		end := start + pmvAudioLandmarkBatch
+		if end > len(landmarks) {
+			end = len(landmarks)
+		}
+		var rows []struct {
+			FingerprintID uint
+			Hits          int
+		}
+		err := db.Model(&models.AudioLandmark{}).
+			Select("fingerprint_id, count(*) as hits").
+			Where("landmark in (?)", landmarks[start:end]).
+			Group("fingerprint_id").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			hits[row.FingerprintID] += row.Hits
+		}
+	}
+
+	var ids []uint
+	for id, n := range hits {
+		if n >= pmvAudioMinVotes {
+			ids = append(ids, id)
+		}
+	}
+	var entries []models.AudioFingerprint
+	if len(ids) == 0 {
+		return entries, nil
+	}
+	err := db.Where("id in (?) and file_id <> ?", ids, fileID).Order("id").Find(&entries).Error
+	return entries, err
+}
+
+type audioAlignment struct {
+	Offset  int
+	Matched int
+	Overlap int
+}
+
+// alignAudioFingerprints finds where ref sits inside the indexed fingerprint, frame j of ref lines up
+// with frame j+Offset of fp. Landmarks shared by too many frames, like silence, do not vote.
+func alignAudioFingerprints(fp []uint32, idx audioFingerprintIndex, ref []uint32) audioAlignment {
+	votes := map[int]int{}
+	for j, v := range ref {
+		if v == 0 {
+			continue
+		}
+		positions := idx[v>>pmvAudioLandmarkShift]
+		if len(positions) > pmvAudioMaxLandmarkHit {
+			continue
+		}
+		for _, i := range positions {
+			votes[i-j]++
+		}
+	}
+
+	best, bestVotes := 0, 0
+	for offset, n := range votes {
+		if n > bestVotes || (n == bestVotes && offset < best) {
+			best, bestVotes = offset, n
+		}
+	}
+	if bestVotes < pmvAudioMinVotes {
+		return audioAlignment{}
+	}
+
+	a := audioAlignment{Offset: best}
+	for j, v := range ref {
+		i := j + best
+		if i < 0 || i >= len(fp) {
+			continue
+		}
+		a.Overlap++
+		if bits.OnesCount32(fp[i]^v) <= pmvAudioMaxBitErrors {
+			a.Matched++
+		}
+	}
+	return a
+}
+
+// audioSceneSimilarity scores two whole soundtracks, unrelated audio agrees on almost no frames
+func audioSceneSimilarity(a audioAlignment, fpLen int, refLen int) float64 {
+	shorter := fpLen
+	if refLen < shorter {
+		shorter = refLen
+	}
+	if shorter == 0 {
+		return 0
+	}
+	return clampScore(float64(a.Matched) / float64(shorter) / pmvAudioFullMatchRatio)
+}
+
+// audioSongFound reports whether enough of a song was heard, a PMV often only uses part of a song
+func audioSongFound(a audioAlignment, refLen int) bool {
+	need := pmvAudioMinSongFrames
+	if refLen/2 < need {
+		need = refLen / 2
+	}
+	return need > 0 && a.Matched >= need
+}
+
+func encodeAudioFingerprint(fp []uint32) string {
+	b := make([]byte, len(fp)*4)
+	for i, v := range fp {
+		binary.LittleEndian.PutUint32(b[i*4:], v)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func decodeAudioFingerprint(s string) []uint32 {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	fp := make([]uint32, len(b)/4)
+	for i := range fp {
+		fp[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	return fp
+}
+
+// identifyPMVFileAudio looks the soundtrack of a file up in the local fingerprint database.
+// Audio is best effort, a failure only means the file is matched without it.
+func identifyPMVFileAudio(db *gorm.DB, file *models.File, dryRun bool, tlog *logrus.Entry) pmvAudioIdentification {
+	var out pmvAudioIdentification
+	if !config.Config.PMVMatch.AudioMatching {
+		return out
+	}
+
+	fp, err := ensureFileAudioFingerprint(db, file, dryRun)
+	if err != nil {
+		tlog.Warnf("audio matching skipped err=%v", err)
+		return out
+	}
+
+	if err := indexAudioFingerprints(db); err != nil {
+		tlog.Warnf("audio matching skipped err=%v", err)
+		return out
+	}
+	idx := newAudioFingerprintIndex(fp)
+	entries, err := audioFingerprintCandidates(db, file.ID, idx)
+	if err != nil {
+		tlog.Warnf("audio matching skipped err=%v", err)
+		return out
+	}
+
+	best := map[string]float64{}
+	var hitSceneIDs []string
+	for _, entry := range entries {
+		ref := decodeAudioFingerprint(entry.Fingerprint)
+		if len(ref) == 0 {
+			continue
+		}
+		a := alignAudioFingerprints(fp, idx, ref)
+		switch entry.Kind {
+		case pmvAudioKindScene:
+			score := audioSceneSimilarity(a, len(fp), len(ref))
+			if score < pmvAudioMinSceneScore || entry.SceneID == "" {
+				continue
+			}
+			if _, ok := best[entry.SceneID]; !ok {
+				hitSceneIDs = append(hitSceneIDs, entry.SceneID)
+			}
+			if score > best[entry.SceneID] {
+				best[entry.SceneID] = score
+			}
+		case pmvAudioKindSong:
+			if audioSongFound(a, len(ref)) {
+				out.Songs = mergePMVSongs(out.Songs, scrape.PMVSong{Artist: entry.Artist, Title: entry.Title})
+				tlog.Infof("audio song found artist=%q title=%q offset=%.1fs", entry.Artist, entry.Title, float64(a.Offset)/pmvAudioFramesPerSecond())
+			}
+		}
+	}
+
+	for _, sceneID := range hitSceneIDs {
+		out.SceneHits = append(out.SceneHits, pmvAudioSceneHit{SceneID: sceneID, Score: best[sceneID]})
+		tlog.Infof("audio scene hit scene_id=%s score=%.2f", sceneID, best[sceneID])
+
+		var scene models.Scene
+		if err := db.Where("scene_id = ?", sceneID).First(&scene).Error; err == nil {
+			out.Songs = mergePMVSongs(out.Songs, parsePMVSoundtrack(scene.Soundtrack)...)
+		}
+	}
+	return out
+}
+
+// scorePMVCandidatesByAudio blends soundtrack evidence into the ranked candidates. Candidates already in the
+// library get the whole-soundtrack score, others are compared on identified songs when both sides have any.
+// Known PMVs the soundtrack matches but the search did not return are added, this catches renamed files.
+func scorePMVCandidatesByAudio(db *gorm.DB, audio pmvAudioIdentification, ranked []PMVMatchCandidate) []PMVMatchCandidate {
+	weight := config.Config.PMVMatch.AudioWeight
+	hits := map[string]float64{}
+	for _, hit := range audio.SceneHits {
+		hits[hit.SceneID] = hit.Score
+	}
+
+	seen := map[string]bool{}
+	for i := range ranked {
+		sceneID := buildPMVSceneID(ranked[i].Provider, ranked[i].PMVID)
+		seen[sceneID] = true
+		if score, ok := hits[sceneID]; ok {
+			ranked[i] = blendPMVAudioScore(ranked[i], score, weight)
+			continue
+		}
+		if len(audio.Songs) > 0 && len(ranked[i].Metadata.Songs) > 0 {
+			ranked[i] = blendPMVAudioScore(ranked[i], pmvSongOverlap(audio.Songs, ranked[i].Metadata.Songs), weight)
+		}
+	}
+
+	for _, hit := range audio.SceneHits {
+		if seen[hit.SceneID] {
+			continue
+		}
+		var scene models.Scene
+		if err := db.Where("scene_id = ?", hit.SceneID).First(&scene).Error; err != nil {
+			continue
+		}
+		c, ok := pmvCandidateFromScene(scene)
+		if !ok {
+			continue
+		}
+		c.AudioScore = hit.Score
+		c.Confidence = hit.Score
+		c.Reason = "soundtrack matches a known PMV"
+		ranked = append(ranked, c)
+	}
+
+	sortCandidates(ranked)
+	return ranked
+}
+
+func blendPMVAudioScore(c PMVMatchCandidate, audio float64, weight float64) PMVMatchCandidate {
+	if weight < 0 || weight > 1 {
+		weight = 0.5
+	}
+	c.AudioScore = audio
+	c.Confidence = clampScore(c.Confidence*(1-weight) + audio*weight)
+	c.Reason += " and soundtrack"
+	return c
+}
+
+// pmvCandidateFromScene turns a PMV scene already in the library back into a match candidate
+func pmvCandidateFromScene(scene models.Scene) (PMVMatchCandidate, bool) {
+	c := PMVMatchCandidate{
+		Title:        scene.Title,
+		SceneURL:     scene.SceneURL,
+		ThumbnailURL: scene.CoverURL,
+		Metadata: scrape.PMVSceneMetadata{
+			Songs: parsePMVSoundtrack(scene.Soundtrack),
+		},
+	}
+	for _, p := range scrape.GetPMVProviders() {
+		if strings.HasPrefix(scene.SceneID, p.ID()+"-") {
+			c.Provider = p.ID()
+			c.PMVID = strings.TrimPrefix(scene.SceneID, p.ID()+"-")
+			return c, true
+		}
+	}
+	return c, false
+}
+
+// pmvSongOverlap is the share of the smaller song list found in the other, compared on normalised titles
+func pmvSongOverlap(a, b []scrape.PMVSong) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	titles := map[string]bool{}
+	for _, s := range b {
+		titles[normalizePMVSongTitle(s.Title)] = true
+	}
+	found := 0
+	for _, s := range a {
+		if titles[normalizePMVSongTitle(s.Title)] {
+			found++
+		}
+	}
+	shorter := len(a)
+	if len(b) < shorter {
+		shorter = len(b)
+	}
+	return clampScore(float64(found) / float64(shorter))
+}
+
+func normalizePMVSongTitle(title string) string {
+	return strings.Join(strings.Fields(pmvSceneIDCleaner.ReplaceAllString(strings.ToLower(title), " ")), " ")
+}
+
+func mergePMVSongs(list []scrape.PMVSong, songs ...scrape.PMVSong) []scrape.PMVSong {
+	for _, song := range songs {
+		song.Artist = strings.TrimSpace(song.Artist)
+		song.Title = strings.TrimSpace(song.Title)
+		if song.Title == "" {
+			continue
+		}
+		exists := false
+		for _, existing := range list {
+			if strings.EqualFold(existing.Artist, song.Artist) && strings.EqualFold(existing.Title, song.Title) {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			list = append(list, song)
+		}
+	}
+	return list
+}
+
+func parsePMVSoundtrack(soundtrack string) []scrape.PMVSong {
+	var songs []scrape.PMVSong
+	_ = json.Unmarshal([]byte(soundtrack), &songs)
+	return songs
+}
+
+// mergeSceneSoundtrack adds songs to the soundtrack of a scene, keeping the songs already there
+func mergeSceneSoundtrack(db *gorm.DB, scene *models.Scene, songs []scrape.PMVSong) error {
+	existing := parsePMVSoundtrack(scene.Soundtrack)
+	merged := mergePMVSongs(existing, songs...)
+	if len(merged) == len(existing) {
+		return nil
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	scene.Soundtrack = string(b)
+	return db.Model(scene).Update("soundtrack", scene.Soundtrack).Error
+}
+
+// addSceneAudioFingerprint records the soundtrack of a matched file, one scene entry is kept per file
+func addSceneAudioFingerprint(db *gorm.DB, file *models.File, sceneID string) error {
+	if file.AudioFingerprint == "" || sceneID == "" {
+		return nil
+	}
+
+	var entry models.AudioFingerprint
+	err := db.Where("file_id = ? and kind = ?", file.ID, pmvAudioKindScene).First(&entry).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	entry.Kind = pmvAudioKindScene
+	entry.FileID = file.ID
+	entry.SceneID = sceneID
+	entry.Fingerprint = file.AudioFingerprint
+	entry.Indexed = false
+	entry.TimeEnd = file.VideoDuration
+	return db.Save(&entry).Error
+}
+
+var pmvAudioLockMutex sync.Mutex
+
+// lockPMVAudio takes the pmv-audio lock, it fails when fingerprinting is already running
+func lockPMVAudio() bool {
+	pmvAudioLockMutex.Lock()
+	defer pmvAudioLockMutex.Unlock()
+
+	if models.CheckLock("pmv-audio") {
+		return false
+	}
+	models.CreateLock("pmv-audio")
+	return true
+}
+
+// StartPMVAudioFingerprints takes the pmv-audio lock and populates the fingerprint database in the background
+func StartPMVAudioFingerprints(limit int) error {
+	if !lockPMVAudio() {
+		return errors.New("audio fingerprinting is already running")
+	}
+	go func() {
+		defer models.RemoveLock("pmv-audio")
+		if _, statusCode, err := populatePMVAudioFingerprints(limit); err != nil {
+			log.WithField("task", "pmv-audio").Errorf("failed status=%d err=%v", statusCode, err)
+		}
+	}()
+	return nil
+}
+
+// populatePMVAudioFingerprints adds the soundtrack of every file matched to a PMV scene to the local
+// fingerprint database, so renamed copies of those PMVs are recognised by ear
+func populatePMVAudioFingerprints(limit int) (*PMVAudioPopulateResult, int, error) {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var prefixes []string
+	var args []interface{}
+	for _, p := range scrape.GetPMVProviders() {
+		prefixes = append(prefixes, "scenes.scene_id like ?")
+		args = append(args, p.ID()+"-%")
+	}
+	if len(prefixes) == 0 {
+		return nil, 400, errors.New("no PMV providers are registered")
+	}
+
+	var files []models.File
+	err := db.Preload("Volume").
+		Select("files.*").
+		Joins("join scenes on scenes.id = files.scene_id").
+		Where("files.type = ? and scenes.deleted_at is null", "video").
+		Where(strings.Join(prefixes, " or "), args...).
+		Where("files.id not in (select file_id from audio_fingerprints where kind = ?)", pmvAudioKindScene).
+		Order("files.id").
+		Limit(normalizePMVBatchLimit(limit)).
+		Find(&files).Error
+	if err != nil {
+		return nil, 500, err
+	}
+
+	tlog := log.WithField("task", "pmv-audio")
+	out := &PMVAudioPopulateResult{Scanned: len(files)}
+	for i := range files {
+		file := &files[i]
+		if _, err := ensureFileAudioFingerprint(db, file, false); err != nil {
+			tlog.Warnf("fingerprint failed file_id=%d err=%v", file.ID, err)
+			out.Errors++
+			continue
+		}
+		var scene models.Scene
+		if err := db.First(&scene, file.SceneID).Error; err != nil {
+			out.Errors++
+			continue
+		}
+		if err := addSceneAudioFingerprint(db, file, scene.SceneID); err != nil {
+			tlog.Warnf("saving fingerprint failed file_id=%d err=%v", file.ID, err)
+			out.Errors++
+			continue
+		}
+		out.Added++
+	}
+	tlog.Infof("fingerprint database populated scanned=%d added=%d errors=%d", out.Scanned, out.Added, out.Errors)
+	return out, 200, nil
+}
+
+// AddPMVAudioSong cuts a song out of the soundtrack of a file and adds it to the local fingerprint database.
+// When the file is matched the song is added to the soundtrack of its scene as well.
+func AddPMVAudioSong(req PMVAudioSongRequest) (*models.AudioFingerprint, int, error) {
+	if req.FileID == 0 {
+		return nil, 400, errors.New("file_id is required")
+	}
+	if strings.TrimSpace(req.Title) == "" {
+		return nil, 400, errors.New("title is required")
+	}
+	if req.Start < 0 || req.End-req.Start < pmvAudioMinSongSeconds {
+		return nil, 400, fmt.Errorf("a song needs to be at least %.0f seconds long", pmvAudioMinSongSeconds)
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var file models.File
+	err := db.Preload("Volume").Where(&models.File{ID: req.FileID}).First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, 404, fmt.Errorf("file_id %d was not found", req.FileID)
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+
+	fp, err := ensureFileAudioFingerprint(db, &file, false)
+	if err != nil {
+		return nil, 424, err
+	}
+	from := int(req.Start * pmvAudioFramesPerSecond())
+	to := int(req.End * pmvAudioFramesPerSecond())
+	if to > len(fp) {
+		to = len(fp)
+	}
+	if from >= to {
+		return nil, 400, errors.New("the song is outside of the fingerprinted audio")
+	}
+
+	entry := models.AudioFingerprint{
+		Kind:        pmvAudioKindSong,
+		Artist:      strings.TrimSpace(req.Artist),
+		Title:       strings.TrimSpace(req.Title),
+		FileID:      file.ID,
+		TimeStart:   req.Start,
+		TimeEnd:     req.End,
+		Fingerprint: encodeAudioFingerprint(fp[from:to]),
+	}
+
+	if file.SceneID != 0 {
+		var scene models.Scene
+		if err := db.First(&scene, file.SceneID).Error; err == nil {
+			entry.SceneID = scene.SceneID
+			if err := mergeSceneSoundtrack(db, &scene, []scrape.PMVSong{{Artist: entry.Artist, Title: entry.Title}}); err != nil {
+				return nil, 500, err
+			}
+		}
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		return nil, 500, err
+	}
+	return &entry, 200, nil
+}
+
+func ListPMVAudioFingerprints(kind string) ([]models.AudioFingerprint, int, error) {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	q := db.Select("id, created_at, updated_at, kind, artist, title, scene_id, file_id, time_start, time_end").Order("id desc")
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	var entries []models.AudioFingerprint
+	if err := q.Find(&entries).Error; err != nil {
+		return nil, 500, err
+	}
+	return entries, 200, nil
+}
+
+func DeletePMVAudioFingerprint(id uint) (int, error) {
+	var entry models.AudioFingerprint
+	if err := entry.GetIfExist(id); err != nil {
+		return 404, fmt.Errorf("audio fingerprint %d was not found", id)
+	}
+	entry.Delete()
+	return 200, nil
+}
@@ -0,0 +1,98 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xbapps/xbvr/pkg/common"
+)
+
+var (
+	pmvBatchMutex sync.Mutex
+	pmvBatchRuns  = map[string]context.CancelFunc{}
+)
+
+func startPMVBatchRun() (string, context.Context) {
+	pmvBatchMutex.Lock()
+	defer pmvBatchMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runID := fmt.Sprintf("%d", time.Now().UnixNano())
+	pmvBatchRuns[runID] = cancel
+	return runID, ctx
+}
+
+func finishPMVBatchRun(runID string) {
+	pmvBatchMutex.Lock()
+	defer pmvBatchMutex.Unlock()
+
+	if cancel, ok := pmvBatchRuns[runID]; ok {
+		cancel()
+		delete(pmvBatchRuns, runID)
+	}
+}
+
+// CancelPMVMatchBatch stops all running pmv-match batches. Workers finish the file they are on and no new
+// files are started. The pmv-match lock is left to the batches, each releases it when it stops.
+func CancelPMVMatchBatch() int {
+	pmvBatchMutex.Lock()
+	cancelled := 0
+	for _, cancel := range pmvBatchRuns {
+		cancel()
+		cancelled++
+	}
+	pmvBatchMutex.Unlock()
+
+	log.WithField("task", "pmv-match-unmatched").Infof("cancel requested runs=%d", cancelled)
+	return cancelled
+}
+
+func publishPMVBatchProgress(out *PMVMatchBatchResult, item *PMVMatchBatchItem, running bool) {
+	eta := 0.0
+	if running && out.Processed > 0 {
+		elapsed := time.Since(out.StartedAt).Seconds()
+		eta = elapsed / float64(out.Processed) * float64(out.Scanned-out.Processed)
+	}
+
+	msg := map[string]interface{}{
+		"run_id":                  out.RunID,
+		"running":                 running,
+		"cancelled":               out.Cancelled,
+		"dry_run":                 out.DryRun,
+		"scanned":                 out.Scanned,
+		"processed":               out.Processed,
+		"matched":                 out.Matched,
+		"pending_review":          out.PendingReview,
+		"skipped_already_matched": out.SkippedAlreadyMatch,
+		"errors":                  out.Errors,
+		"eta_seconds":             int(eta),
+		"report_file":             out.ReportFile,
+	}
+	if item != nil {
+		msg["file_id"] = item.FileID
+		msg["filename"] = item.Filename
+		msg["status_code"] = item.StatusCode
+		msg["error"] = item.Error
+	}
+	common.PublishWS("pmv.match.progress", msg)
+}
+
+func writePMVBatchReport(out *PMVMatchBatchResult) (string, error) {
+	report := *out
+	report.ReportFile = pmvBatchReports.fileName(out.RunID)
+	if err := pmvBatchReports.write(report.ReportFile, report); err != nil {
+		return "", err
+	}
+	return report.ReportFile, nil
+}
+
+// GetLastPMVBatchReport loads the report of the most recent batch run, it can also be downloaded from /download/<report_file>
+func GetLastPMVBatchReport() (*PMVMatchBatchResult, int, error) {
+	var report PMVMatchBatchResult
+	if statusCode, err := pmvBatchReports.readLast(&report); err != nil {
+		return nil, statusCode, err
+	}
+	return &report, 200, nil
+}
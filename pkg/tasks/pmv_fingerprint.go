@@ -0,0 +1,268 @@
+package tasks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jinzhu/gorm"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
+	"github.com/xbapps/xbvr/pkg/scrape"
+)
+
+// Perceptual hashes are 64 bit difference hashes (dHash) computed from a 9x8 grayscale frame,
+// each bit records whether a pixel is brighter than its right hand neighbour
+const (
+	pmvHashWidth          = 9
+	pmvHashHeight         = 8
+	pmvHashFrameSize      = pmvHashWidth * pmvHashHeight
+	pmvPreviewFrameCount  = 6
+	pmvFingerprintTimeout = 30 * time.Second
+)
+
+var errPMVNoFrames = errors.New("ffmpeg returned no frames")
+
+// ensureFilePerceptualHashes returns the stored keyframe hashes of a file, extracting them with ffmpeg on first use.
+// Dry runs extract them without storing them.
+func ensureFilePerceptualHashes(db *gorm.DB, file *models.File, dryRun bool) ([]uint64, error) {
+	if hashes := decodePerceptualHashes(file.PerceptualHash); len(hashes) > 0 {
+		return hashes, nil
+	}
+	if file.Volume.Type != "local" || !file.Exists() {
+		return nil, fmt.Errorf("file %s is not accessible", file.GetPath())
+	}
+
+	hashes, err := extractVideoPerceptualHashes(file.GetPath(), file.VideoDuration, file.VideoWidth >= file.VideoHeight*2, config.Config.PMVMatch.KeyframeCount)
+	if err != nil {
+		return nil, err
+	}
+	file.PerceptualHash = encodePerceptualHashes(hashes)
+	if dryRun {
+		return hashes, nil
+	}
+	if err := db.Model(file).Update("perceptual_hash", file.PerceptualHash).Error; err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// extractVideoPerceptualHashes samples frames spread evenly over the video, seeking before the input
+// so ffmpeg decodes from the nearest keyframe. Side by side videos only use the left eye.
+func extractVideoPerceptualHashes(path string, duration float64, sbs bool, frames int) ([]uint64, error) {
+	if frames <= 0 {
+		frames = 8
+	}
+	if duration <= 0 {
+		return nil, errors.New("unknown video duration")
+	}
+
+	vf := fmt.Sprintf("scale=%d:%d:flags=area,format=gray", pmvHashWidth, pmvHashHeight)
+	if sbs {
+		vf = "crop=iw/2:ih:0:0," + vf
+	}
+
+	var hashes []uint64
+	interval := duration / float64(frames+1)
+	for i := 1; i <= frames; i++ {
+		args := []string{
+			"-v", "error",
+			"-ss", strconv.FormatFloat(interval*float64(i), 'f', 2, 64),
+			"-i", path,
+			"-frames:v", "1",
+			"-vf", vf,
+			"-f", "rawvideo",
+			"pipe:1",
+		}
+		out, err := runFFmpegOutput(nil, args...)
+		if err != nil {
+			log.Debugf("pmv fingerprint: frame %d of %s failed: %v", i, path, err)
+			continue
+		}
+		hashes = append(hashes, hashesFromRawFrames(out)...)
+	}
+	if len(hashes) == 0 {
+		return nil, errPMVNoFrames
+	}
+	return hashes, nil
+}
+
+// candidatePerceptualHashes hashes the candidate thumbnail and a few frames from its preview video, if there is one
+func candidatePerceptualHashes(c scrape.PMVCandidate) []uint64 {
+	var hashes []uint64
+	vf := fmt.Sprintf("scale=%d:%d:flags=area,format=gray", pmvHashWidth, pmvHashHeight)
+
+	if thumb := strings.TrimSpace(c.ThumbnailURL); thumb != "" {
+		req := resty.New().
+			SetTimeout(25*time.Second).
+			SetRetryCount(1).
+			SetHeader("User-Agent", scrape.UserAgent).
+			R()
+		scrape.SetupRestyRequest(c.Provider+"-scraper", req)
+		resp, err := req.Get(thumb)
+		if err == nil && resp.StatusCode() >= 200 && resp.StatusCode() < 300 && len(resp.Body()) > 0 {
+			out, err := runFFmpegOutput(resp.Body(), "-v", "error", "-i", "pipe:0", "-frames:v", "1", "-vf", vf, "-f", "rawvideo", "pipe:1")
+			if err == nil {
+				hashes = append(hashes, hashesFromRawFrames(out)...)
+			} else {
+				log.Debugf("pmv fingerprint: thumbnail %s could not be decoded: %v", thumb, err)
+			}
+		}
+	}
+
+	if preview := strings.TrimSpace(c.PreviewURL); preview != "" {
+		out, err := runFFmpegOutput(nil,
+			"-v", "error",
+			"-user_agent", scrape.UserAgent,
+			"-i", preview,
+			"-vf", "fps=1/2,"+vf,
+			"-frames:v", strconv.Itoa(pmvPreviewFrameCount),
+			"-f", "rawvideo",
+			"pipe:1",
+		)
+		if err == nil {
+			hashes = append(hashes, hashesFromRawFrames(out)...)
+		} else {
+			log.Debugf("pmv fingerprint: preview %s could not be sampled: %v", preview, err)
+		}
+	}
+	return hashes
+}
+
+// scorePMVCandidatesVisually blends the visual similarity of each candidate into its text confidence.
+// Candidates whose images could not be fetched keep their text score.
+func scorePMVCandidatesVisually(fileHashes []uint64, candidates []scrape.PMVCandidate, ranked []PMVMatchCandidate) {
+	byKey := map[string]scrape.PMVCandidate{}
+	for _, c := range candidates {
+		byKey[pmvCandidateKey(c.Provider, c.ID)] = c
+	}
+
+	weight := config.Config.PMVMatch.VisualWeight
+	for i := range ranked {
+		c, ok := byKey[pmvCandidateKey(ranked[i].Provider, ranked[i].PMVID)]
+		if !ok {
+			continue
+		}
+		candidateHashes := candidatePerceptualHashes(c)
+		if len(candidateHashes) == 0 {
+			continue
+		}
+		ranked[i] = blendPMVVisualScore(ranked[i], visualSimilarity(fileHashes, candidateHashes), weight)
+	}
+	sortCandidates(ranked)
+}
+
+func blendPMVVisualScore(c PMVMatchCandidate, visual float64, weight float64) PMVMatchCandidate {
+	if weight < 0 || weight > 1 {
+		weight = 0.6
+	}
+	c.VisualScore = visual
+	c.Confidence = clampScore(c.TextScore*(1-weight) + visual*weight)
+	c.Reason = "text and visual similarity"
+	return c
+}
+
+func runFFmpegOutput(stdin []byte, args ...string) ([]byte, error) {
+	return runFFmpegOutputTimeout(pmvFingerprintTimeout, stdin, args...)
+}
+
+func runFFmpegOutputTimeout(timeout time.Duration, stdin []byte, args ...string) ([]byte, error) {
+	cmd := buildCmd(GetBinPath("ffmpeg"), args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	timer.Stop()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func hashesFromRawFrames(raw []byte) []uint64 {
+	var hashes []uint64
+	for len(raw) >= pmvHashFrameSize {
+		hashes = append(hashes, dHashFromGray(raw[:pmvHashFrameSize]))
+		raw = raw[pmvHashFrameSize:]
+	}
+	return hashes
+}
+
+func dHashFromGray(pixels []byte) uint64 {
+	var hash uint64
+	for y := 0; y < pmvHashHeight; y++ {
+		row := pixels[y*pmvHashWidth : (y+1)*pmvHashWidth]
+		for x := 0; x < pmvHashWidth-1; x++ {
+			hash <<= 1
+			if row[x] > row[x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// visualSimilarity scores how closely the best candidate frame matches any keyframe of the file.
+// Unrelated images sit around 32 differing bits, so that is treated as zero similarity.
+func visualSimilarity(fileHashes, candidateHashes []uint64) float64 {
+	if len(fileHashes) == 0 || len(candidateHashes) == 0 {
+		return 0
+	}
+	best := 64
+	for _, c := range candidateHashes {
+		for _, f := range fileHashes {
+			if d := hammingDistance(c, f); d < best {
+				best = d
+			}
+		}
+	}
+	return clampScore(float64(32-best) / 32)
+}
+
+func encodePerceptualHashes(hashes []uint64) string {
+	out := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		out = append(out, fmt.Sprintf("%016x", h))
+	}
+	return strings.Join(out, ",")
+}
+
+func decodePerceptualHashes(s string) []uint64 {
+	var out []uint64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		h, err := strconv.ParseUint(part, 16, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
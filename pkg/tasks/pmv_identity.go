@@ -0,0 +1,186 @@
+package tasks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/models"
+	"github.com/xbapps/xbvr/pkg/scrape"
+)
+
+const pmvDefaultProvider = "pmvhaven"
+
+var pmvSceneIDCleaner = regexp.MustCompile(`[^a-z0-9\-_]+`)
+
+// buildPMVSceneID keys PMV scenes on the source's own id, so every copy of the same PMV ends up on one scene.
+// For PMVHaven this is the same scene id the PMVHaven scraper uses.
+func buildPMVSceneID(provider string, pmvID string) string {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	if provider == "" {
+		provider = pmvDefaultProvider
+	}
+	pmvID = strings.Trim(pmvSceneIDCleaner.ReplaceAllString(strings.ToLower(strings.TrimSpace(pmvID)), "-"), "-")
+	if pmvID == "" {
+		return ""
+	}
+	return provider + "-" + pmvID
+}
+
+func pmvProviderByID(providerID string) (scrape.PMVProvider, bool) {
+	providerID = strings.ToLower(strings.TrimSpace(providerID))
+	if providerID == "" {
+		providerID = pmvDefaultProvider
+	}
+	for _, p := range scrape.GetPMVProviders() {
+		if p.ID() == providerID {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func pmvProviderCandidateID(providerID string, sceneURL string) string {
+	if strings.TrimSpace(sceneURL) == "" {
+		return ""
+	}
+	if p, ok := pmvProviderByID(providerID); ok {
+		return p.CandidateID(sceneURL)
+	}
+	return ""
+}
+
+// MergeCustomPMVScenes moves the per-file custom-pmv-<fileID> scenes onto stable PMV scene ids,
+// merging scenes that turn out to be copies of the same PMV
+func MergeCustomPMVScenes(db *gorm.DB) (int, error) {
+	var scenes []models.Scene
+	if err := db.Where("scene_id like ?", "custom-pmv-%").Order("id").Find(&scenes).Error; err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, scene := range scenes {
+		stableID := buildPMVSceneID(pmvDefaultProvider, pmvProviderCandidateID(pmvDefaultProvider, scene.SceneURL))
+		if stableID == "" {
+			common.Log.Warnf("Could not build a stable PMV scene id for %s, scene url %q", scene.SceneID, scene.SceneURL)
+			continue
+		}
+
+		var target models.Scene
+		err := db.Where("scene_id = ?", stableID).First(&target).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return changed, err
+		}
+
+		if err == gorm.ErrRecordNotFound {
+			if err := renamePMVScene(db, &scene, stableID); err != nil {
+				return changed, err
+			}
+		} else {
+			if err := mergePMVScene(db, &target, &scene); err != nil {
+				return changed, err
+			}
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+func renamePMVScene(db *gorm.DB, scene *models.Scene, sceneID string) error {
+	err := db.Model(&models.Action{}).Where("scene_id = ?", scene.SceneID).Update("scene_id", sceneID).Error
+	if err != nil {
+		return err
+	}
+
+	if scene.HasVideoPreview {
+		err := os.Rename(filepath.Join(common.VideoPreviewDir, scene.SceneID+".mp4"), filepath.Join(common.VideoPreviewDir, sceneID+".mp4"))
+		if err != nil {
+			common.Log.Warnf("Could not update preview %s", scene.SceneID)
+		}
+	}
+
+	// the scene now belongs to the source, as if its scraper had created it
+	updates := map[string]interface{}{"scene_id": sceneID, "scene_type": "2D"}
+	if p, ok := pmvProviderByID(pmvDefaultProvider); ok {
+		updates["scraper_id"] = p.ScraperID()
+		updates["site"] = p.Site()
+	}
+
+	common.Log.Infoln("Updating sceneid:", scene.SceneID, "to", sceneID)
+	return db.Model(scene).Updates(updates).Error
+}
+
+// mergePMVScene moves files, history, cuepoints and user data from dup onto keep and deletes dup
+func mergePMVScene(db *gorm.DB, keep *models.Scene, dup *models.Scene) error {
+	for _, table := range []string{"files", "histories", "scene_cuepoints"} {
+		if err := db.Table(table).Where("scene_id = ?", dup.ID).Update("scene_id", keep.ID).Error; err != nil {
+			return err
+		}
+	}
+	if err := db.Model(&models.Action{}).Where("scene_id = ?", dup.SceneID).Update("scene_id", keep.SceneID).Error; err != nil {
+		return err
+	}
+	if err := models.MergeUserScenes(db, keep.ID, dup.ID); err != nil {
+		return err
+	}
+
+	if dup.StarRating > keep.StarRating {
+		keep.StarRating = dup.StarRating
+	}
+	keep.Favourite = keep.Favourite || dup.Favourite
+	keep.Watchlist = keep.Watchlist || dup.Watchlist
+	keep.IsWatched = keep.IsWatched || dup.IsWatched
+	keep.IsAvailable = keep.IsAvailable || dup.IsAvailable
+	keep.IsAccessible = keep.IsAccessible || dup.IsAccessible
+	keep.TotalWatchTime += dup.TotalWatchTime
+	keep.TotalFileSize += dup.TotalFileSize
+	if dup.LastOpened.After(keep.LastOpened) {
+		keep.LastOpened = dup.LastOpened
+	}
+	if !dup.AddedDate.IsZero() && (keep.AddedDate.IsZero() || dup.AddedDate.Before(keep.AddedDate)) {
+		keep.AddedDate = dup.AddedDate
+	}
+
+	var filenames, dupFilenames []string
+	_ = json.Unmarshal([]byte(keep.FilenamesArr), &filenames)
+	_ = json.Unmarshal([]byte(dup.FilenamesArr), &dupFilenames)
+	for _, fn := range dupFilenames {
+		exists := false
+		for _, existing := range filenames {
+			if existing == fn {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			filenames = append(filenames, fn)
+		}
+	}
+	if b, err := json.Marshal(filenames); err == nil {
+		keep.FilenamesArr = string(b)
+	}
+
+	err := db.Model(keep).Updates(map[string]interface{}{
+		"star_rating":      keep.StarRating,
+		"favourite":        keep.Favourite,
+		"watchlist":        keep.Watchlist,
+		"is_watched":       keep.IsWatched,
+		"is_available":     keep.IsAvailable,
+		"is_accessible":    keep.IsAccessible,
+		"total_watch_time": keep.TotalWatchTime,
+		"total_file_size":  keep.TotalFileSize,
+		"last_opened":      keep.LastOpened,
+		"added_date":       keep.AddedDate,
+		"filenames_arr":    keep.FilenamesArr,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	common.Log.Infoln("Merging scene", dup.SceneID, "into", keep.SceneID)
+	return db.Delete(dup).Error
+}
@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/config"
 	"github.com/xbapps/xbvr/pkg/models"
 	"github.com/xbapps/xbvr/pkg/scrape"
 )
@@ -26,8 +28,14 @@ type PMVMatchCandidate struct {
 	Title        string  `json:"title"`
 	SceneURL     string  `json:"scene_url"`
 	ThumbnailURL string  `json:"thumbnail_url"`
-	Confidence   float64 `json:"-"`
+	Confidence   float64 `json:"confidence"`
+	TextScore    float64 `json:"text_score"`
+	VisualScore  float64 `json:"visual_score,omitempty"`
+	AudioScore   float64 `json:"audio_score,omitempty"`
 	Reason       string  `json:"reason"`
+	Provider     string  `json:"provider"`
+
+	Metadata scrape.PMVSceneMetadata `json:"metadata"`
 }
 
 type PMVMatchResult struct {
@@ -36,6 +44,7 @@ type PMVMatchResult struct {
 	Filename       string              `json:"filename"`
 	Query          string              `json:"query"`
 	Autolinked     bool                `json:"autolinked"`
+	PendingReview  bool                `json:"pending_review"`
 	MatchedSceneID string              `json:"matched_scene_id,omitempty"`
 	Candidates     []PMVMatchCandidate `json:"candidates"`
 	Message        string              `json:"message,omitempty"`
@@ -58,9 +67,17 @@ type PMVMatchBatchItem struct {
 }
 
 type PMVMatchBatchResult struct {
+	RunID               string              `json:"run_id"`
+	DryRun              bool                `json:"dry_run"`
+	StartedAt           time.Time           `json:"started_at"`
+	FinishedAt          time.Time           `json:"finished_at"`
+	Cancelled           bool                `json:"cancelled"`
+	ReportFile          string              `json:"report_file,omitempty"`
 	Scanned             int                 `json:"scanned"`
+	Processed           int                 `json:"processed"`
 	Matched             int                 `json:"matched"`
 	SkippedAlreadyMatch int                 `json:"skipped_already_matched"`
+	PendingReview       int                 `json:"pending_review"`
 	Errors              int                 `json:"errors"`
 	Results             []PMVMatchBatchItem `json:"results"`
 }
@@ -74,7 +91,7 @@ func MatchPMVFile(fileID uint, dryRun bool) (*PMVMatchResult, int, error) {
 	defer db.Close()
 
 	var file models.File
-	err := db.Where(&models.File{ID: fileID}).First(&file).Error
+	err := db.Preload("Volume").Where(&models.File{ID: fileID}).First(&file).Error
 	if err == gorm.ErrRecordNotFound {
 		return nil, 404, fmt.Errorf("file_id %d was not found", fileID)
 	}
@@ -100,88 +117,98 @@ func MatchPMVFile(fileID uint, dryRun bool) (*PMVMatchResult, int, error) {
 		Candidates: []PMVMatchCandidate{},
 	}
 
-	searchQueries := buildPMVSearchQueries(file.Filename, query)
-	var candidates []scrape.PMVHavenCandidate
-	var searchErr error
-	usedQuery := query
-	for i, q := range searchQueries {
-		tlog.Infof("search attempt=%d/%d query=%q", i+1, len(searchQueries), q)
-		candidates, searchErr = scrape.SearchPMVHaven(q, pmvMatchCandidateLimit)
-		if searchErr != nil {
-			tlog.Warnf("search failed query=%q err=%v", q, searchErr)
-			continue
-		}
-		if len(candidates) > 0 {
-			usedQuery = q
-			break
-		}
+	providers := pmvMatchProviders()
+	if len(providers) == 0 {
+		return nil, 400, errors.New("no PMV providers are enabled")
 	}
-	if searchErr != nil && len(candidates) == 0 {
+
+	candidates, usedQuery, searchErr := searchPMVProviders(providers, buildPMVSearchQueries(file.Filename, query, loadPMVTokenWeights(db, &file)), tlog)
+	audio := identifyPMVFileAudio(db, &file, dryRun, tlog)
+	if searchErr != nil && len(candidates) == 0 && len(audio.SceneHits) == 0 {
 		return nil, 424, searchErr
 	}
-	if len(candidates) == 0 {
+	if len(candidates) == 0 && len(audio.SceneHits) == 0 {
 		tlog.Infof("search returned 0 candidates")
-		result.Message = "no PMVHaven candidates found"
+		result.Message = "no PMV candidates found"
 		return result, 200, nil
 	}
-	result.Query = usedQuery
-	if usedQuery != query {
-		tlog.Infof("fallback query selected used_query=%q base_query=%q", usedQuery, query)
+	if len(candidates) > 0 {
+		result.Query = usedQuery
+		if usedQuery != query {
+			tlog.Infof("fallback query selected used_query=%q base_query=%q", usedQuery, query)
+		}
 	}
 	for i, c := range candidates {
-		tlog.Infof("parsed candidate #%d title=%q scene_url=%q thumbnail_url=%q", i+1, c.Title, c.SceneURL, c.ThumbnailURL)
+		tlog.Infof("parsed candidate #%d provider=%s title=%q scene_url=%q thumbnail_url=%q", i+1, c.Provider, c.Title, c.SceneURL, c.ThumbnailURL)
 	}
 
-	thumbCache := map[string]string{}
-	for i := range candidates {
-		cacheKey := candidates[i].SceneURL
-		if cachedThumb, ok := thumbCache[cacheKey]; ok {
-			if strings.TrimSpace(candidates[i].ThumbnailURL) == "" && cachedThumb != "" {
-				candidates[i].ThumbnailURL = cachedThumb
-			}
-			continue
-		}
-
-		prevThumb := strings.TrimSpace(candidates[i].ThumbnailURL)
-		enriched, enrichErr := scrape.EnrichPMVHavenCandidateThumbnail(candidates[i])
-		if enrichErr != nil {
-			tlog.Warnf("candidate #%d scene-page thumbnail enrichment failed scene_url=%q err=%v", i+1, candidates[i].SceneURL, enrichErr)
-			thumbCache[cacheKey] = prevThumb
-			continue
-		}
+	enrichPMVCandidates(providers, candidates, tlog)
 
-		thumbCache[cacheKey] = strings.TrimSpace(enriched.ThumbnailURL)
-		candidates[i] = enriched
+	ranked := scorePMVCandidatesByText(query, candidates)
+	if len(ranked) > 0 {
+		tlog.Infof("baseline top title=%q pmv_id=%s", ranked[0].Title, ranked[0].PMVID)
+	}
 
-		source := "search_html"
-		if strings.TrimSpace(enriched.ThumbnailURL) != "" && strings.TrimSpace(enriched.ThumbnailURL) != prevThumb {
-			source = "scene_html"
+	if config.Config.PMVMatch.VisualMatching && len(ranked) > 0 {
+		fileHashes, hashErr := ensureFilePerceptualHashes(db, &file, dryRun)
+		if hashErr != nil {
+			tlog.Warnf("visual matching skipped err=%v", hashErr)
+		} else {
+			scorePMVCandidatesVisually(fileHashes, candidates, ranked)
+			for i, c := range ranked {
+				tlog.Infof("visual score #%d title=%q text=%.2f visual=%.2f confidence=%.2f", i+1, c.Title, c.TextScore, c.VisualScore, c.Confidence)
+			}
 		}
-		tlog.Infof("candidate #%d thumbnail source=%s thumbnail_url=%q", i+1, source, enriched.ThumbnailURL)
 	}
 
-	ranked := scorePMVCandidatesByText(query, candidates)
-	if len(ranked) > 0 {
-		tlog.Infof("baseline top title=%q pmv_id=%s", ranked[0].Title, ranked[0].PMVID)
+	if len(audio.SceneHits) > 0 || len(audio.Songs) > 0 {
+		ranked = scorePMVCandidatesByAudio(db, audio, ranked)
+		for i, c := range ranked {
+			tlog.Infof("audio score #%d title=%q audio=%.2f confidence=%.2f", i+1, c.Title, c.AudioScore, c.Confidence)
+		}
+	}
+	if len(ranked) == 0 {
+		result.Message = "no PMV candidates found"
+		return result, 200, nil
 	}
 
 	sortCandidates(ranked)
 
 	for i := range ranked {
 		ranked[i].Rank = i + 1
+		// songs heard in the file belong on whichever candidate ends up linked
+		ranked[i].Metadata.Songs = mergePMVSongs(ranked[i].Metadata.Songs, audio.Songs...)
 	}
 	result.Candidates = ranked
 	if len(ranked) > 0 {
-		result.MatchedSceneID = buildPMVCustomSceneID(fileID)
+		result.MatchedSceneID = buildPMVSceneID(ranked[0].Provider, ranked[0].PMVID)
 		tlog.Infof("final top parsed title=%q pmv_id=%s thumbnail=%q", ranked[0].Title, ranked[0].PMVID, ranked[0].ThumbnailURL)
 	}
 
+	meetsThreshold, reviewReason := pmvMatchMeetsThreshold(ranked, config.Config.PMVMatch.MinConfidence, config.Config.PMVMatch.MinMargin)
+
 	if dryRun {
 		result.Message = "dry run: best candidate found, no database changes applied"
+		if !meetsThreshold {
+			result.Message = "dry run: best candidate would be queued for review, " + reviewReason
+		}
 		tlog.Infof("dry run success candidate_title=%q pmv_id=%s", ranked[0].Title, ranked[0].PMVID)
 		return result, 200, nil
 	}
 
+	if !meetsThreshold {
+		if err := queuePMVReviewItem(db, &file, result.Query, ranked, reviewReason); err != nil {
+			tlog.Errorf("queue for review failed err=%v", err)
+			return nil, 500, err
+		}
+		result.Status = "pending_review"
+		result.PendingReview = true
+		result.MatchedSceneID = ""
+		result.Message = "queued for review, " + reviewReason
+		tlog.Infof("queued for review candidate_title=%q confidence=%.2f reason=%q", ranked[0].Title, ranked[0].Confidence, reviewReason)
+		return result, 200, nil
+	}
+
 	matchedSceneID, err := applyPMVMatch(db, &file, ranked[0])
 	if err != nil {
 		tlog.Errorf("apply match failed candidate_title=%q pmv_id=%s err=%v", ranked[0].Title, ranked[0].PMVID, err)
@@ -195,6 +222,93 @@ func MatchPMVFile(fileID uint, dryRun bool) (*PMVMatchResult, int, error) {
 	return result, 200, nil
 }
 
+// pmvMatchProviders can be replaced with test doubles to run the matcher offline
+var pmvMatchProviders = scrape.EnabledPMVProviders
+
+// searchPMVProviders runs the search queries in order against every provider, stopping at the first query
+// that gives any candidates. Results are merged in provider order and de-duplicated by scene url.
+func searchPMVProviders(providers []scrape.PMVProvider, queries []string, tlog *logrus.Entry) ([]scrape.PMVCandidate, string, error) {
+	var lastErr error
+	for i, q := range queries {
+		tlog.Infof("search attempt=%d/%d query=%q", i+1, len(queries), q)
+
+		seen := map[string]bool{}
+		var merged []scrape.PMVCandidate
+		for _, p := range providers {
+			found, err := p.Search(q, pmvMatchCandidateLimit)
+			if err != nil {
+				tlog.Warnf("search failed provider=%s query=%q err=%v", p.ID(), q, err)
+				lastErr = err
+				continue
+			}
+			for _, c := range found {
+				c.Provider = p.ID()
+				if c.ID == "" {
+					c.ID = p.CandidateID(c.SceneURL)
+				}
+				key := strings.ToLower(strings.TrimSpace(c.SceneURL))
+				if key == "" {
+					key = pmvCandidateKey(c.Provider, c.ID)
+				}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				merged = append(merged, c)
+			}
+		}
+		if len(merged) > 0 {
+			return merged, q, nil
+		}
+	}
+	return nil, "", lastErr
+}
+
+func enrichPMVCandidates(providers []scrape.PMVProvider, candidates []scrape.PMVCandidate, tlog *logrus.Entry) {
+	byID := map[string]scrape.PMVProvider{}
+	for _, p := range providers {
+		byID[p.ID()] = p
+	}
+
+	thumbCache := map[string]string{}
+	for i := range candidates {
+		provider, ok := byID[candidates[i].Provider]
+		if !ok {
+			continue
+		}
+
+		cacheKey := candidates[i].SceneURL
+		if cachedThumb, ok := thumbCache[cacheKey]; ok {
+			if strings.TrimSpace(candidates[i].ThumbnailURL) == "" && cachedThumb != "" {
+				candidates[i].ThumbnailURL = cachedThumb
+			}
+			continue
+		}
+
+		prevThumb := strings.TrimSpace(candidates[i].ThumbnailURL)
+		enriched, enrichErr := provider.Enrich(candidates[i])
+		if enrichErr != nil {
+			tlog.Warnf("candidate #%d scene-page thumbnail enrichment failed provider=%s scene_url=%q err=%v", i+1, provider.ID(), candidates[i].SceneURL, enrichErr)
+			thumbCache[cacheKey] = prevThumb
+			continue
+		}
+
+		enriched.Provider = provider.ID()
+		thumbCache[cacheKey] = strings.TrimSpace(enriched.ThumbnailURL)
+		candidates[i] = enriched
+
+		source := "search_html"
+		if strings.TrimSpace(enriched.ThumbnailURL) != "" && strings.TrimSpace(enriched.ThumbnailURL) != prevThumb {
+			source = "scene_html"
+		}
+		tlog.Infof("candidate #%d thumbnail source=%s thumbnail_url=%q", i+1, source, enriched.ThumbnailURL)
+	}
+}
+
+func pmvCandidateKey(provider string, id string) string {
+	return provider + ":" + id
+}
+
 func normalizePMVQuery(filename string) string {
 	name := strings.TrimSpace(filename)
 	name = strings.TrimSuffix(name, filepath.Ext(name))
@@ -260,7 +374,9 @@ func normalizePMVQuery(filename string) string {
 	return strings.Join(out, " ")
 }
 
-func buildPMVSearchQueries(filename, baseQuery string) []string {
+// buildPMVSearchQueries lists the queries to try in order, weights learned from earlier matches of files
+// in the same place give the first fallback
+func buildPMVSearchQueries(filename, baseQuery string, weights pmvTokenWeights) []string {
 	added := map[string]bool{}
 	out := make([]string, 0, 8)
 	add := func(q string) {
@@ -274,6 +390,9 @@ func buildPMVSearchQueries(filename, baseQuery string) []string {
 
 	add(baseQuery)
 	baseTokens := strings.Fields(baseQuery)
+	if len(weights) > 0 {
+		add(strings.Join(stripLearnedPMVNoise(filename, baseTokens, weights), " "))
+	}
 	if len(baseTokens) >= 2 {
 		add(strings.Join(baseTokens[1:], " "))
 	}
@@ -378,7 +497,7 @@ func isLikelyNoiseToken(tok string) bool {
 	return false
 }
 
-func scorePMVCandidatesByText(query string, candidates []scrape.PMVHavenCandidate) []PMVMatchCandidate {
+func scorePMVCandidatesByText(query string, candidates []scrape.PMVCandidate) []PMVMatchCandidate {
 	queryTokens := tokenSet(query)
 	out := make([]PMVMatchCandidate, 0, len(candidates))
 	for _, c := range candidates {
@@ -399,7 +518,10 @@ func scorePMVCandidatesByText(query string, candidates []scrape.PMVHavenCandidat
 			SceneURL:     c.SceneURL,
 			ThumbnailURL: c.ThumbnailURL,
 			Confidence:   confidence,
+			TextScore:    confidence,
 			Reason:       "baseline text similarity",
+			Provider:     c.Provider,
+			Metadata:     c.PMVSceneMetadata,
 		})
 	}
 	sortCandidates(out)
@@ -475,12 +597,26 @@ func cleanStudioToken(s string) string {
 }
 
 func applyPMVMatch(db *gorm.DB, file *models.File, candidate PMVMatchCandidate) (string, error) {
-	sceneID := buildPMVCustomSceneID(file.ID)
-	studio := inferPMVStudio(file.Filename, candidate.Title)
+	// PMVs are flat videos, a scene of a known source is created the way the source's scraper creates it
+	scraperID, site := "custom", "CustomVR"
+	sceneID := buildPMVSceneID(candidate.Provider, candidate.PMVID)
+	if sceneID == "" {
+		sceneID = buildPMVCustomSceneID(file.ID)
+	} else if p, ok := pmvProviderByID(candidate.Provider); ok {
+		scraperID, site = p.ScraperID(), p.Site()
+	}
+	studio := strings.TrimSpace(candidate.Metadata.Creator)
+	if studio == "" {
+		studio = inferPMVStudio(file.Filename, candidate.Title)
+	}
 	if studio == "" {
 		studio = "Custom"
 	}
 	now := time.Now()
+	released := candidate.Metadata.UploadDate
+	if released == "" {
+		released = now.Format("2006-01-02")
+	}
 	sceneWasCreated := false
 	var existing models.Scene
 	if err := db.Where(&models.Scene{SceneID: sceneID}).First(&existing).Error; err == gorm.ErrRecordNotFound {
@@ -489,22 +625,29 @@ func applyPMVMatch(db *gorm.DB, file *models.File, candidate PMVMatchCandidate)
 
 	ext := models.ScrapedScene{
 		SceneID:     sceneID,
-		ScraperID:   "custom",
-		SceneType:   "VR",
+		ScraperID:   scraperID,
+		SceneType:   "2D",
 		Title:       strings.TrimSpace(candidate.Title),
 		Studio:      studio,
-		Site:        "CustomVR",
+		Site:        site,
 		HomepageURL: strings.TrimSpace(candidate.SceneURL),
 		MembersUrl:  strings.TrimSpace(candidate.SceneURL),
-		Released:    now.Format("2006-01-02"),
+		Released:    released,
 		Filenames:   []string{file.Filename},
+		Tags:        candidate.Metadata.Tags,
+		Cast:        candidate.Metadata.Performers,
+		Duration:    candidate.Metadata.Duration / 60,
+		Synopsis:    candidate.Metadata.Synopsis(),
 	}
 	if strings.TrimSpace(candidate.ThumbnailURL) != "" {
 		ext.Covers = append(ext.Covers, strings.TrimSpace(candidate.ThumbnailURL))
 	}
 
-	if err := models.SceneCreateUpdateFromExternal(db, ext); err != nil {
-		return "", err
+	// a known PMV only gets the file attached, the scene data already came from the first match or the scraper
+	if sceneWasCreated {
+		if err := models.SceneCreateUpdateFromExternal(db, ext); err != nil {
+			return "", err
+		}
 	}
 
 	var scene models.Scene
@@ -546,6 +689,20 @@ func applyPMVMatch(db *gorm.DB, file *models.File, candidate PMVMatchCandidate)
 	models.AddAction(scene.SceneID, "match", "filenames_arr", scene.FilenamesArr)
 	scene.UpdateStatus()
 
+	if err := learnPMVTokenWeights(db, file, candidate.Title); err != nil {
+		log.WithField("task", "pmv-match").Warnf("could not learn token weights file_id=%d err=%v", file.ID, err)
+	}
+	if err := mergeSceneSoundtrack(db, &scene, candidate.Metadata.Songs); err != nil {
+		log.WithField("task", "pmv-match").Warnf("could not update soundtrack scene_id=%s err=%v", scene.SceneID, err)
+	}
+	if err := addSceneAudioFingerprint(db, file, scene.SceneID); err != nil {
+		log.WithField("task", "pmv-match").Warnf("could not save audio fingerprint file_id=%d err=%v", file.ID, err)
+	}
+
+	if err := db.Where("file_id = ?", file.ID).Delete(&models.PMVReviewItem{}).Error; err != nil {
+		log.WithField("task", "pmv-match").Warnf("could not clear review item file_id=%d err=%v", file.ID, err)
+	}
+
 	IndexScenes(&[]models.Scene{scene})
 	return scene.SceneID, nil
 }
@@ -602,7 +759,9 @@ func MatchPMVUnmatchedFiles(req PMVMatchBatchRequest) (*PMVMatchBatchResult, int
 	db, _ := models.GetDB()
 	defer db.Close()
 
-	query := db.Model(&models.File{}).Where("type = ? AND scene_id = 0", "video")
+	query := db.Model(&models.File{}).Where("type = ? AND scene_id = 0", "video").
+		Where("id NOT IN (SELECT file_id FROM pmv_review_items)").
+		Where("volume_id NOT IN (SELECT id FROM volumes WHERE content_type IN (?))", []string{models.VolumeContentVR, models.VolumeContentFlat})
 	if req.VolumeID != 0 {
 		query = query.Where("volume_id = ?", req.VolumeID)
 	}
@@ -615,12 +774,20 @@ func MatchPMVUnmatchedFiles(req PMVMatchBatchRequest) (*PMVMatchBatchResult, int
 		return nil, 500, err
 	}
 
+	runID, ctx := startPMVBatchRun()
+	defer finishPMVBatchRun(runID)
+
 	out := &PMVMatchBatchResult{
-		Scanned: len(files),
-		Results: make([]PMVMatchBatchItem, len(files)),
+		RunID:     runID,
+		DryRun:    req.DryRun,
+		StartedAt: time.Now(),
+		Scanned:   len(files),
+		Results:   make([]PMVMatchBatchItem, len(files)),
 	}
 
 	if len(files) == 0 {
+		out.FinishedAt = time.Now()
+		publishPMVBatchProgress(out, nil, false)
 		return out, 200, nil
 	}
 
@@ -671,39 +838,72 @@ func MatchPMVUnmatchedFiles(req PMVMatchBatchRequest) (*PMVMatchBatchResult, int
 	}
 
 	go func() {
+	feed:
 		for i, file := range files {
-			jobs <- batchJob{Index: i, File: file}
+			select {
+			case <-ctx.Done():
+				break feed
+			case jobs <- batchJob{Index: i, File: file}:
+			}
 		}
 		close(jobs)
 		wg.Wait()
 		close(results)
 	}()
 
+	processed := make([]bool, len(files))
 	for r := range results {
 		out.Results[r.Index] = r.Item
-	}
-
-	for _, item := range out.Results {
-		if item.Error != "" {
-			if item.StatusCode == 409 {
-				out.SkippedAlreadyMatch++
-			} else {
-				out.Errors++
+		processed[r.Index] = true
+		out.tally(r.Item)
+		publishPMVBatchProgress(out, &r.Item, true)
+	}
+
+	// files never handed to a worker after a cancel are left out of the results
+	if ctx.Err() != nil {
+		out.Cancelled = true
+		kept := make([]PMVMatchBatchItem, 0, out.Processed)
+		for i, item := range out.Results {
+			if processed[i] {
+				kept = append(kept, item)
 			}
-			continue
-		}
-		if item.Result == nil {
-			out.Errors++
-			continue
-		}
-		if item.Result.Autolinked {
-			out.Matched++
 		}
+		out.Results = kept
 	}
+	out.FinishedAt = time.Now()
+
+	if reportFile, err := writePMVBatchReport(out); err != nil {
+		log.WithField("task", "pmv-match-unmatched").Warnf("could not write run report err=%v", err)
+	} else {
+		out.ReportFile = reportFile
+	}
+	publishPMVBatchProgress(out, nil, false)
 
 	return out, 200, nil
 }
 
+func (out *PMVMatchBatchResult) tally(item PMVMatchBatchItem) {
+	out.Processed++
+	if item.Error != "" {
+		if item.StatusCode == 409 {
+			out.SkippedAlreadyMatch++
+		} else {
+			out.Errors++
+		}
+		return
+	}
+	if item.Result == nil {
+		out.Errors++
+		return
+	}
+	if item.Result.Autolinked {
+		out.Matched++
+	}
+	if item.Result.PendingReview {
+		out.PendingReview++
+	}
+}
+
 func RunPMVMatchUnmatchedTask(req PMVMatchBatchRequest) {
 	tlog := log.WithField("task", "pmv-match-unmatched")
 	if models.CheckLock("pmv-match") {
@@ -722,8 +922,8 @@ func RunPMVMatchUnmatchedTask(req PMVMatchBatchRequest) {
 		return
 	}
 
-	tlog.Infof("done status=%d scanned=%d matched=%d skipped_already_matched=%d errors=%d",
-		statusCode, result.Scanned, result.Matched, result.SkippedAlreadyMatch, result.Errors)
+	tlog.Infof("done status=%d run_id=%s cancelled=%v scanned=%d processed=%d matched=%d pending_review=%d skipped_already_matched=%d errors=%d report=%s",
+		statusCode, result.RunID, result.Cancelled, result.Scanned, result.Processed, result.Matched, result.PendingReview, result.SkippedAlreadyMatch, result.Errors, result.ReportFile)
 }
 
 func normalizePMVBatchLimit(limit int) int {
@@ -1,6 +1,10 @@
 package tasks
 
 import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/xbapps/xbvr/pkg/scrape"
@@ -17,7 +21,7 @@ func TestNormalizePMVQuery(t *testing.T) {
 
 func TestScorePMVCandidatesByText(t *testing.T) {
 	query := "amazing sunset remix"
-	candidates := []scrape.PMVHavenCandidate{
+	candidates := []scrape.PMVCandidate{
 		{ID: "a", Title: "Random Compilation", SceneURL: "https://pmvhaven.com/random"},
 		{ID: "b", Title: "Amazing Sunset Remix", SceneURL: "https://pmvhaven.com/amazing-sunset-remix"},
 	}
@@ -76,7 +80,7 @@ func TestBuildPMVSearchQueries_DropsLeadingChannelToken(t *testing.T) {
 	if base != "adhdpmv feminist to daddy's girl world pmv games" {
 		t.Fatalf("unexpected base query %q", base)
 	}
-	queries := buildPMVSearchQueries(filename, base)
+	queries := buildPMVSearchQueries(filename, base, nil)
 
 	found := false
 	for _, q := range queries {
@@ -134,3 +138,429 @@ func TestNormalizePMVBatchConcurrency(t *testing.T) {
 		t.Fatalf("expected passthrough concurrency, got %d", got)
 	}
 }
+
+func TestDHashFromGray_GradientAndIdentity(t *testing.T) {
+	frame := make([]byte, pmvHashFrameSize)
+	for y := 0; y < pmvHashHeight; y++ {
+		for x := 0; x < pmvHashWidth; x++ {
+			frame[y*pmvHashWidth+x] = byte(255 - x*20)
+		}
+	}
+	if got := dHashFromGray(frame); got != ^uint64(0) {
+		t.Fatalf("expected all bits set for a falling gradient, got %016x", got)
+	}
+
+	hashes := hashesFromRawFrames(append(append([]byte{}, frame...), frame...))
+	if len(hashes) != 2 || hashes[0] != hashes[1] {
+		t.Fatalf("expected 2 identical hashes, got %v", hashes)
+	}
+}
+
+func TestVisualSimilarity(t *testing.T) {
+	file := []uint64{0x0f0f0f0f0f0f0f0f, 0xffff0000ffff0000}
+	if got := visualSimilarity(file, []uint64{0xffff0000ffff0000}); got != 1 {
+		t.Fatalf("expected exact frame match to score 1, got %v", got)
+	}
+	if got := visualSimilarity(file, []uint64{0x0f0f0f0f0f0f0f00}); got <= 0.8 {
+		t.Fatalf("expected near match to score high, got %v", got)
+	}
+	if got := visualSimilarity(file, []uint64{0xf0f0f0f0f0f0f0f0 ^ 0xffff0000ffff0000}); got != 0 {
+		t.Fatalf("expected unrelated frame to score 0, got %v", got)
+	}
+	if got := visualSimilarity(nil, []uint64{1}); got != 0 {
+		t.Fatalf("expected missing file hashes to score 0, got %v", got)
+	}
+}
+
+func TestPerceptualHashesRoundTrip(t *testing.T) {
+	in := []uint64{0, 1, 0xdeadbeefcafebabe}
+	encoded := encodePerceptualHashes(in)
+	if encoded != "0000000000000000,0000000000000001,deadbeefcafebabe" {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+	out := decodePerceptualHashes(encoded)
+	if len(out) != len(in) || out[2] != in[2] {
+		t.Fatalf("expected %v, got %v", in, out)
+	}
+	if got := decodePerceptualHashes(""); len(got) != 0 {
+		t.Fatalf("expected no hashes from empty string, got %v", got)
+	}
+}
+
+func TestBlendPMVVisualScore_RenamedFileRanksByVisual(t *testing.T) {
+	ranked := scorePMVCandidatesByText("final v3", []scrape.PMVCandidate{
+		{ID: "a", Title: "Final Countdown PMV", SceneURL: "https://pmvhaven.com/a"},
+		{ID: "b", Title: "Neon Nights", SceneURL: "https://pmvhaven.com/b"},
+	})
+	for i := range ranked {
+		visual := 0.0
+		if ranked[i].PMVID == "b" {
+			visual = 0.95
+		}
+		ranked[i] = blendPMVVisualScore(ranked[i], visual, 0.6)
+	}
+	sortCandidates(ranked)
+	if ranked[0].PMVID != "b" {
+		t.Fatalf("expected visually matching candidate b first, got %s", ranked[0].PMVID)
+	}
+	if ranked[0].TextScore >= ranked[1].TextScore {
+		t.Fatalf("expected candidate b to have the weaker text score")
+	}
+}
+
+func TestPMVMatchMeetsThreshold(t *testing.T) {
+	cases := []struct {
+		name   string
+		ranked []PMVMatchCandidate
+		want   bool
+	}{
+		{name: "empty", ranked: nil, want: false},
+		{name: "single confident", ranked: []PMVMatchCandidate{{Confidence: 0.8}}, want: true},
+		{name: "single weak", ranked: []PMVMatchCandidate{{Confidence: 0.4}}, want: false},
+		{name: "clear lead", ranked: []PMVMatchCandidate{{Confidence: 0.85}, {Confidence: 0.5}}, want: true},
+		{name: "ambiguous", ranked: []PMVMatchCandidate{{Confidence: 0.85}, {Confidence: 0.8}}, want: false},
+	}
+
+	for _, tc := range cases {
+		got, reason := pmvMatchMeetsThreshold(tc.ranked, 0.6, 0.1)
+		if got != tc.want {
+			t.Fatalf("%s: expected %v, got %v (reason %q)", tc.name, tc.want, got, reason)
+		}
+		if !got && reason == "" {
+			t.Fatalf("%s: expected a reason when below threshold", tc.name)
+		}
+	}
+}
+
+type fakePMVProvider struct {
+	id      string
+	results map[string][]scrape.PMVCandidate
+	err     error
+	titles  map[string]string
+}
+
+func (p fakePMVProvider) ID() string {
+	return p.id
+}
+
+func (p fakePMVProvider) ScraperID() string {
+	return p.id
+}
+
+func (p fakePMVProvider) Site() string {
+	return p.id
+}
+
+func (p fakePMVProvider) Search(query string, limit int) ([]scrape.PMVCandidate, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.results[query], nil
+}
+
+func (p fakePMVProvider) Enrich(c scrape.PMVCandidate) (scrape.PMVCandidate, error) {
+	if title, ok := p.titles[c.SceneURL]; ok {
+		c.Title = title
+	}
+	return c, nil
+}
+
+func (p fakePMVProvider) CandidateID(sceneURL string) string {
+	return p.id + "-" + sceneURL[len(sceneURL)-1:]
+}
+
+func TestSearchPMVProviders_MergesAndDedupes(t *testing.T) {
+	tlog := log.WithField("task", "pmv-match-test")
+	haven := fakePMVProvider{id: "haven", results: map[string][]scrape.PMVCandidate{
+		"neon nights": {
+			{ID: "h1", Title: "Neon Nights", SceneURL: "https://haven.example/1"},
+			{ID: "h2", Title: "Shared Upload", SceneURL: "https://shared.example/x"},
+		},
+	}}
+	hub := fakePMVProvider{id: "hub", results: map[string][]scrape.PMVCandidate{
+		"neon nights": {
+			{Title: "Shared Upload", SceneURL: "https://shared.example/x"},
+			{Title: "Neon Nights Remix", SceneURL: "https://hub.example/7"},
+		},
+	}}
+	broken := fakePMVProvider{id: "broken", err: errors.New("offline")}
+
+	got, usedQuery, err := searchPMVProviders([]scrape.PMVProvider{broken, haven, hub}, []string{"neon nights"}, tlog)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usedQuery != "neon nights" {
+		t.Fatalf("unexpected used query %q", usedQuery)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 merged candidates, got %d", len(got))
+	}
+	if got[0].Provider != "haven" || got[2].Provider != "hub" {
+		t.Fatalf("expected candidates to record their provider, got %q and %q", got[0].Provider, got[2].Provider)
+	}
+	if got[2].ID != "hub-7" {
+		t.Fatalf("expected missing id to come from the provider, got %q", got[2].ID)
+	}
+}
+
+func TestSearchPMVProviders_FallsBackToNextQuery(t *testing.T) {
+	tlog := log.WithField("task", "pmv-match-test")
+	hub := fakePMVProvider{id: "hub", results: map[string][]scrape.PMVCandidate{
+		"neon": {{ID: "n", Title: "Neon", SceneURL: "https://hub.example/n"}},
+	}}
+
+	got, usedQuery, err := searchPMVProviders([]scrape.PMVProvider{hub}, []string{"arckom neon", "neon"}, tlog)
+	if err != nil || len(got) != 1 || usedQuery != "neon" {
+		t.Fatalf("expected fallback query match, got %d candidates query=%q err=%v", len(got), usedQuery, err)
+	}
+
+	_, _, err = searchPMVProviders([]scrape.PMVProvider{fakePMVProvider{id: "broken", err: errors.New("offline")}}, []string{"neon"}, tlog)
+	if err == nil {
+		t.Fatalf("expected provider error when nothing was found")
+	}
+}
+
+func TestEnrichPMVCandidates_UsesOwningProvider(t *testing.T) {
+	tlog := log.WithField("task", "pmv-match-test")
+	haven := fakePMVProvider{id: "haven", titles: map[string]string{"https://haven.example/1": "Enriched Haven"}}
+	hub := fakePMVProvider{id: "hub", titles: map[string]string{"https://hub.example/2": "Enriched Hub"}}
+	candidates := []scrape.PMVCandidate{
+		{ID: "1", Title: "a", SceneURL: "https://haven.example/1", Provider: "haven"},
+		{ID: "2", Title: "b", SceneURL: "https://hub.example/2", Provider: "hub"},
+	}
+
+	enrichPMVCandidates([]scrape.PMVProvider{haven, hub}, candidates, tlog)
+	if candidates[0].Title != "Enriched Haven" || candidates[1].Title != "Enriched Hub" {
+		t.Fatalf("unexpected enrichment %q %q", candidates[0].Title, candidates[1].Title)
+	}
+
+	ranked := scorePMVCandidatesByText("enriched hub", candidates)
+	if ranked[0].Provider != "hub" {
+		t.Fatalf("expected ranked candidate to keep its provider, got %q", ranked[0].Provider)
+	}
+}
+
+func TestBuildPMVSceneID(t *testing.T) {
+	cases := []struct {
+		provider string
+		pmvID    string
+		want     string
+	}{
+		{provider: "pmvhaven", pmvID: "65f0c1d2e3a4b5c6d7e8f901", want: "pmvhaven-65f0c1d2e3a4b5c6d7e8f901"},
+		{provider: "", pmvID: "Neon Nights", want: "pmvhaven-neon-nights"},
+		{provider: "Hub", pmvID: "abc_1", want: "hub-abc_1"},
+		{provider: "hub", pmvID: "  ", want: ""},
+	}
+	for _, tc := range cases {
+		if got := buildPMVSceneID(tc.provider, tc.pmvID); got != tc.want {
+			t.Fatalf("buildPMVSceneID(%q, %q): expected %q, got %q", tc.provider, tc.pmvID, tc.want, got)
+		}
+	}
+
+	if got := buildPMVSceneID("pmvhaven", pmvProviderCandidateID("pmvhaven", "https://pmvhaven.com/video/neon-nights_65f0c1d2e3a4b5c6d7e8f901/")); got != "pmvhaven-65f0c1d2e3a4b5c6d7e8f901" {
+		t.Fatalf("expected the stable id to match the PMVHaven scraper scene id, got %q", got)
+	}
+}
+
+func TestPMVMatchBatchResultTally(t *testing.T) {
+	out := &PMVMatchBatchResult{}
+	out.tally(PMVMatchBatchItem{StatusCode: 200, Result: &PMVMatchResult{Autolinked: true}})
+	out.tally(PMVMatchBatchItem{StatusCode: 200, Result: &PMVMatchResult{PendingReview: true}})
+	out.tally(PMVMatchBatchItem{StatusCode: 409, Error: "already matched"})
+	out.tally(PMVMatchBatchItem{StatusCode: 424, Error: "search failed"})
+	out.tally(PMVMatchBatchItem{StatusCode: 200})
+
+	if out.Processed != 5 || out.Matched != 1 || out.PendingReview != 1 || out.SkippedAlreadyMatch != 1 || out.Errors != 2 {
+		t.Fatalf("unexpected counts %+v", out)
+	}
+}
+
+func TestCancelPMVMatchBatch_CancelsRunningRuns(t *testing.T) {
+	runID, ctx := startPMVBatchRun()
+	defer finishPMVBatchRun(runID)
+
+	if cancelled := CancelPMVMatchBatch(); cancelled != 1 {
+		t.Fatalf("expected 1 cancelled run, got %d", cancelled)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatalf("expected run context to be cancelled")
+	}
+}
+
+// synthMelodyPCM renders a tone sequence as 16 bit mono PCM, every seed gives a different tune
+func synthMelodyPCM(seed uint32, seconds float64) []byte {
+	samples := int(seconds * pmvAudioSampleRate)
+	noteLen := pmvAudioSampleRate * 3 / 8
+	pcm := make([]byte, samples*2)
+	freq := 0.0
+	for i := 0; i < samples; i++ {
+		if i%noteLen == 0 {
+			seed = seed*1664525 + 1013904223
+			freq = 110 * math.Pow(2, float64(seed>>24%36)/12)
+		}
+		v := 0.4*math.Sin(2*math.Pi*freq*float64(i)/pmvAudioSampleRate) + 0.2*math.Sin(2*math.Pi*freq*1.5*float64(i)/pmvAudioSampleRate)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(v*32767)))
+	}
+	return pcm
+}
+
+func TestAudioFingerprint_FindsSongInsidePMV(t *testing.T) {
+	song := synthMelodyPCM(7, 30)
+	intro := synthMelodyPCM(99, float64(24*pmvAudioFrameStep)/pmvAudioSampleRate)
+	pmv := append(append([]byte{}, intro...), song...)
+
+	songFP := audioFingerprintFromPCM(song)
+	pmvFP := audioFingerprintFromPCM(pmv)
+	a := alignAudioFingerprints(pmvFP, newAudioFingerprintIndex(pmvFP), songFP)
+	if a.Offset != 24 {
+		t.Fatalf("expected song at frame 24, got %d", a.Offset)
+	}
+	if !audioSongFound(a, len(songFP)) {
+		t.Fatalf("expected song to be found, matched %d of %d", a.Matched, len(songFP))
+	}
+
+	other := audioFingerprintFromPCM(synthMelodyPCM(12345, 30))
+	b := alignAudioFingerprints(pmvFP, newAudioFingerprintIndex(pmvFP), other)
+	if audioSongFound(b, len(other)) {
+		t.Fatalf("expected unrelated song not to be found, matched %d of %d", b.Matched, len(other))
+	}
+}
+
+func TestAudioFingerprintLandmarks(t *testing.T) {
+	idx := newAudioFingerprintIndex([]uint32{0, 1 << pmvAudioLandmarkShift, 2 << pmvAudioLandmarkShift, 1<<pmvAudioLandmarkShift | 5})
+	got := idx.landmarks()
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("expected distinct landmarks without silence, got %v", got)
+	}
+
+	busy := make([]uint32, pmvAudioMaxLandmarkHit+1)
+	for i := range busy {
+		busy[i] = 3 << pmvAudioLandmarkShift
+	}
+	if got := newAudioFingerprintIndex(busy).landmarks(); len(got) != 0 {
+		t.Fatalf("expected landmarks too common to vote to be left out, got %v", got)
+	}
+
+	song := newAudioFingerprintIndex(audioFingerprintFromPCM(synthMelodyPCM(7, 30))).landmarks()
+	pmv := map[uint32]bool{}
+	for _, l := range newAudioFingerprintIndex(audioFingerprintFromPCM(synthMelodyPCM(7, 60))).landmarks() {
+		pmv[l] = true
+	}
+	shared := 0
+	for _, l := range song {
+		if pmv[l] {
+			shared++
+		}
+	}
+	if shared < pmvAudioMinVotes {
+		t.Fatalf("expected a song to share landmarks with the PMV using it, got %d", shared)
+	}
+}
+
+func TestAudioSceneSimilarity_SameSoundtrack(t *testing.T) {
+	fp := audioFingerprintFromPCM(synthMelodyPCM(3, 20))
+	a := alignAudioFingerprints(fp, newAudioFingerprintIndex(fp), fp)
+	if got := audioSceneSimilarity(a, len(fp), len(fp)); got < 0.99 {
+		t.Fatalf("expected identical soundtracks to score 1, got %.2f", got)
+	}
+}
+
+func TestAudioFingerprintRoundTrip(t *testing.T) {
+	in := []uint32{0, 1, 0xdeadbeef, 0xffffffff}
+	got := decodeAudioFingerprint(encodeAudioFingerprint(in))
+	if len(got) != len(in) {
+		t.Fatalf("expected %d values, got %d", len(in), len(got))
+	}
+	for i := range in {
+		if got[i] != in[i] {
+			t.Fatalf("value %d: expected %x, got %x", i, in[i], got[i])
+		}
+	}
+	if decodeAudioFingerprint("not base64!") != nil {
+		t.Fatalf("expected invalid input to decode to nil")
+	}
+}
+
+func TestPMVSongOverlap(t *testing.T) {
+	heard := []scrape.PMVSong{{Artist: "M83", Title: "Midnight City"}}
+	listed := []scrape.PMVSong{{Artist: "m83", Title: "Midnight City!"}, {Artist: "Daft Punk", Title: "One More Time"}}
+	if got := pmvSongOverlap(heard, listed); got != 1 {
+		t.Fatalf("expected full overlap, got %.2f", got)
+	}
+	if got := pmvSongOverlap(heard, []scrape.PMVSong{{Title: "Other"}}); got != 0 {
+		t.Fatalf("expected no overlap, got %.2f", got)
+	}
+
+	merged := mergePMVSongs(listed, heard...)
+	if len(merged) != 3 {
+		t.Fatalf("expected differently spelled titles to be kept apart, got %+v", merged)
+	}
+	if len(mergePMVSongs(merged, scrape.PMVSong{Artist: "DAFT PUNK", Title: "one more time"})) != 3 {
+		t.Fatalf("expected case insensitive duplicate to be skipped")
+	}
+}
+
+func TestBlendPMVAudioScore(t *testing.T) {
+	c := PMVMatchCandidate{Confidence: 0.4, TextScore: 0.4, Reason: "baseline text similarity"}
+	got := blendPMVAudioScore(c, 1, 0.5)
+	if math.Abs(got.Confidence-0.7) > 1e-9 || got.AudioScore != 1 {
+		t.Fatalf("unexpected blend: %+v", got)
+	}
+	if got.Reason != "baseline text similarity and soundtrack" {
+		t.Fatalf("unexpected reason: %q", got.Reason)
+	}
+}
+
+func TestPMVFilenameTokens(t *testing.T) {
+	got := pmvFilenameTokens("[RelGroup]_NeonNights.PMV-x265_v2.mp4")
+	want := []string{"relgroup", "neon", "nights", "pmv", "x265", "v2"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestLearnedPMVTokenWeight(t *testing.T) {
+	if w := learnedPMVTokenWeight(0, 0); w != 0 {
+		t.Fatalf("expected unseen token to be neutral, got %.2f", w)
+	}
+	if w := learnedPMVTokenWeight(1, 0); pmvTokenWeights(map[string]float64{"a": w}).isNoise("a") {
+		t.Fatalf("expected a single observation not to mark noise, got %.2f", w)
+	}
+	if w := learnedPMVTokenWeight(3, 0); !pmvTokenWeights(map[string]float64{"a": w}).isNoise("a") {
+		t.Fatalf("expected repeated misses to mark noise, got %.2f", w)
+	}
+	if w := learnedPMVTokenWeight(0, 3); !pmvTokenWeights(map[string]float64{"a": w}).isSignal("a") {
+		t.Fatalf("expected repeated hits to mark signal, got %.2f", w)
+	}
+}
+
+func TestBuildPMVSearchQueries_UsesLearnedWeights(t *testing.T) {
+	filename := "relgroup_neon_nights_hmv_pmv.mp4"
+	base := normalizePMVQuery(filename)
+	weights := pmvTokenWeights{"relgroup": -0.8, "hmv": -0.6, "pmv": 0.2}
+
+	queries := buildPMVSearchQueries(filename, base, weights)
+	if len(queries) < 2 {
+		t.Fatalf("expected a learned fallback query, got %v", queries)
+	}
+	if queries[0] != base {
+		t.Fatalf("expected base query first, got %q", queries[0])
+	}
+	if queries[1] != "neon nights pmv" {
+		t.Fatalf("expected learned noise to be stripped, got %q", queries[1])
+	}
+}
+
+func TestStripLearnedPMVNoise_RestoresSignalTokens(t *testing.T) {
+	got := stripLearnedPMVNoise("chan_neon_2160p_nights.mp4", []string{"chan", "neon", "nights"}, pmvTokenWeights{"chan": -0.7, "2160p": 0.6})
+	if strings.Join(got, " ") != "neon 2160p nights" {
+		t.Fatalf("unexpected tokens: %v", got)
+	}
+}
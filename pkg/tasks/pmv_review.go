@@ -0,0 +1,205 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+const (
+	pmvReviewStatusPending  = "pending"
+	pmvReviewStatusRejected = "rejected"
+)
+
+type PMVReviewQueueItem struct {
+	ID         uint                `json:"id"`
+	FileID     uint                `json:"file_id"`
+	Filename   string              `json:"filename"`
+	Query      string              `json:"query"`
+	Status     string              `json:"status"`
+	Reason     string              `json:"reason"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+	Candidates []PMVMatchCandidate `json:"candidates"`
+}
+
+// pmvMatchMeetsThreshold reports whether the top candidate is safe to autolink,
+// it needs a minimum confidence and a clear lead over the runner-up
+func pmvMatchMeetsThreshold(ranked []PMVMatchCandidate, minConfidence float64, minMargin float64) (bool, string) {
+	if len(ranked) == 0 {
+		return false, "no candidates"
+	}
+	if ranked[0].Confidence < minConfidence {
+		return false, fmt.Sprintf("confidence %.2f is below %.2f", ranked[0].Confidence, minConfidence)
+	}
+	if len(ranked) > 1 {
+		margin := ranked[0].Confidence - ranked[1].Confidence
+		if margin < minMargin {
+			return false, fmt.Sprintf("margin %.2f over runner-up is below %.2f", margin, minMargin)
+		}
+	}
+	return true, ""
+}
+
+func queuePMVReviewItem(db *gorm.DB, file *models.File, query string, ranked []PMVMatchCandidate, reason string) error {
+	candidates, err := json.Marshal(ranked)
+	if err != nil {
+		return err
+	}
+
+	var item models.PMVReviewItem
+	err = db.Where(&models.PMVReviewItem{FileID: file.ID}).First(&item).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+
+	item.FileID = file.ID
+	item.Filename = file.Filename
+	item.Query = query
+	item.Status = pmvReviewStatusPending
+	item.Reason = reason
+	item.Candidates = string(candidates)
+	return db.Save(&item).Error
+}
+
+func toPMVReviewQueueItem(item models.PMVReviewItem) PMVReviewQueueItem {
+	out := PMVReviewQueueItem{
+		ID:         item.ID,
+		FileID:     item.FileID,
+		Filename:   item.Filename,
+		Query:      item.Query,
+		Status:     item.Status,
+		Reason:     item.Reason,
+		CreatedAt:  item.CreatedAt,
+		UpdatedAt:  item.UpdatedAt,
+		Candidates: []PMVMatchCandidate{},
+	}
+	_ = json.Unmarshal([]byte(item.Candidates), &out.Candidates)
+	return out
+}
+
+func ListPMVReviewQueue(status string) ([]PMVReviewQueueItem, int, error) {
+	if status == "" {
+		status = pmvReviewStatusPending
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var items []models.PMVReviewItem
+	query := db.Model(&models.PMVReviewItem{})
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Order("created_at asc").Find(&items).Error; err != nil {
+		return nil, 500, err
+	}
+
+	out := make([]PMVReviewQueueItem, 0, len(items))
+	for _, item := range items {
+		out = append(out, toPMVReviewQueueItem(item))
+	}
+	return out, 200, nil
+}
+
+func AcceptPMVReviewItem(id uint, rank int) (*PMVMatchResult, int, error) {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var item models.PMVReviewItem
+	err := db.Where(&models.PMVReviewItem{ID: id}).First(&item).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, 404, fmt.Errorf("review item %d was not found", id)
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+
+	queued := toPMVReviewQueueItem(item)
+	var candidate *PMVMatchCandidate
+	for i := range queued.Candidates {
+		if queued.Candidates[i].Rank == rank {
+			candidate = &queued.Candidates[i]
+			break
+		}
+	}
+	if candidate == nil {
+		return nil, 400, fmt.Errorf("review item %d has no candidate with rank %d", id, rank)
+	}
+
+	var file models.File
+	err = db.Where(&models.File{ID: item.FileID}).First(&file).Error
+	if err == gorm.ErrRecordNotFound {
+		db.Delete(&item)
+		return nil, 404, fmt.Errorf("file_id %d was not found", item.FileID)
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+	if file.SceneID != 0 {
+		db.Delete(&item)
+		return nil, 409, fmt.Errorf("file_id %d is already matched", file.ID)
+	}
+
+	matchedSceneID, err := applyPMVMatch(db, &file, *candidate)
+	if err != nil {
+		return nil, 500, err
+	}
+	log.WithField("task", "pmv-match").WithField("file_id", file.ID).
+		Infof("review accepted rank=%d scene_id=%s candidate_title=%q", rank, matchedSceneID, candidate.Title)
+
+	return &PMVMatchResult{
+		Status:         "ok",
+		FileID:         file.ID,
+		Filename:       file.Filename,
+		Query:          item.Query,
+		Autolinked:     true,
+		MatchedSceneID: matchedSceneID,
+		Candidates:     queued.Candidates,
+		Message:        "file linked to custom PMV scene",
+	}, 200, nil
+}
+
+func RejectPMVReviewItem(id uint) (int, error) {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var item models.PMVReviewItem
+	err := db.Where(&models.PMVReviewItem{ID: id}).First(&item).Error
+	if err == gorm.ErrRecordNotFound {
+		return 404, fmt.Errorf("review item %d was not found", id)
+	}
+	if err != nil {
+		return 500, err
+	}
+
+	// rejected items stay in the table so the unmatched batch does not queue the file again
+	if err := db.Model(&item).Update("status", pmvReviewStatusRejected).Error; err != nil {
+		return 500, err
+	}
+	log.WithField("task", "pmv-match").WithField("file_id", item.FileID).Infof("review rejected")
+	return 200, nil
+}
+
+func RequeryPMVReviewItem(id uint) (*PMVMatchResult, int, error) {
+	var item models.PMVReviewItem
+	err := item.GetIfExist(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, 404, fmt.Errorf("review item %d was not found", id)
+	}
+	if err != nil {
+		return nil, 500, err
+	}
+
+	result, statusCode, err := MatchPMVFile(item.FileID, false)
+	if err != nil {
+		if statusCode == 404 || statusCode == 409 {
+			item.Delete()
+		}
+		return nil, statusCode, err
+	}
+	return result, statusCode, nil
+}
@@ -0,0 +1,225 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// Learned token weights count how often a filename token showed up in the title of the PMV a file was matched
+// to. Tokens that never do are naming convention noise of whoever released the file, eg channel names or tags.
+const (
+	pmvTokenWeightThreshold = 0.5
+	pmvTokenWeightPrior     = 2
+)
+
+var (
+	pmvFilenameTokenCleaner = regexp.MustCompile(`[^a-z0-9]+`)
+	pmvFilenameBracketed    = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)`)
+	pmvFilenameCamelCase    = regexp.MustCompile(`([a-z])([A-Z])`)
+)
+
+// pmvTokenWeights maps filename tokens to their weight, tokens without one are left to the heuristics
+type pmvTokenWeights map[string]float64
+
+func (w pmvTokenWeights) isNoise(tok string) bool {
+	weight, ok := w[tok]
+	return ok && weight <= -pmvTokenWeightThreshold
+}
+
+func (w pmvTokenWeights) isSignal(tok string) bool {
+	weight, ok := w[tok]
+	return ok && weight >= pmvTokenWeightThreshold
+}
+
+type PMVTokenWeightRequest struct {
+	VolumeID   uint    `json:"volume_id"`
+	PathPrefix string  `json:"path_prefix"`
+	Token      string  `json:"token"`
+	Weight     float64 `json:"weight"`
+}
+
+// pmvFilenameTokens splits a filename the same way titles are tokenised for scoring, keeping the order.
+// CamelCase words are split, but bracketed tags like [RelGroup] are release names and stay one token.
+func pmvFilenameTokens(filename string) []string {
+	name := strings.TrimSpace(filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	var out []string
+	last := 0
+	for _, loc := range pmvFilenameBracketed.FindAllStringIndex(name, -1) {
+		out = append(out, pmvTokenWords(pmvFilenameCamelCase.ReplaceAllString(name[last:loc[0]], "$1 $2"))...)
+		out = append(out, strings.Join(pmvTokenWords(name[loc[0]:loc[1]]), ""))
+		last = loc[1]
+	}
+	out = append(out, pmvTokenWords(pmvFilenameCamelCase.ReplaceAllString(name[last:], "$1 $2"))...)
+
+	tokens := out[:0]
+	for _, tok := range out {
+		if len(tok) >= 2 {
+			tokens = append(tokens, tok)
+		}
+	}
+	return tokens
+}
+
+func pmvTokenWords(s string) []string {
+	return strings.Fields(pmvFilenameTokenCleaner.ReplaceAllString(strings.ToLower(s), " "))
+}
+
+func learnedPMVTokenWeight(noise int, signal int) float64 {
+	return float64(signal-noise) / float64(signal+noise+pmvTokenWeightPrior)
+}
+
+// loadPMVTokenWeights collects the weights that apply to a file. Volume specific weights win over global ones
+// and longer path prefixes over shorter ones.
+func loadPMVTokenWeights(db *gorm.DB, file *models.File) pmvTokenWeights {
+	var rows []models.PMVTokenWeight
+	if err := db.Where("volume_id in (?)", []uint{0, file.VolumeID}).Find(&rows).Error; err != nil {
+		log.WithField("task", "pmv-match").Warnf("could not load token weights err=%v", err)
+		return nil
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if (rows[i].VolumeID == 0) != (rows[j].VolumeID == 0) {
+			return rows[i].VolumeID == 0
+		}
+		return len(rows[i].PathPrefix) < len(rows[j].PathPrefix)
+	})
+
+	weights := pmvTokenWeights{}
+	for _, row := range rows {
+		if !strings.HasPrefix(file.Path, row.PathPrefix) {
+			continue
+		}
+		weights[row.Token] = row.Weight
+	}
+	return weights
+}
+
+// learnPMVTokenWeights records which filename tokens made it into the title of the confirmed PMV, both for the
+// folder of the file and for its whole volume. Weights edited through the API are kept as they are.
+func learnPMVTokenWeights(db *gorm.DB, file *models.File, title string) error {
+	titleTokens := tokenSet(title)
+	tokens := pmvFilenameTokens(file.Filename)
+	if len(titleTokens) == 0 || len(tokens) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, tok := range tokens {
+		if seen[tok] {
+			continue
+		}
+		seen[tok] = true
+
+		for _, prefix := range []string{file.Path, ""} {
+			var row models.PMVTokenWeight
+			err := db.Where("volume_id = ? and path_prefix = ? and token = ?", file.VolumeID, prefix, tok).First(&row).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			row.VolumeID = file.VolumeID
+			row.PathPrefix = prefix
+			row.Token = tok
+			if titleTokens[tok] {
+				row.SignalCount++
+			} else {
+				row.NoiseCount++
+			}
+			if !row.Manual {
+				row.Weight = learnedPMVTokenWeight(row.NoiseCount, row.SignalCount)
+			}
+			if err := db.Save(&row).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// stripLearnedPMVNoise drops learned noise from a query and puts back tokens the heuristics removed
+// from the filename although they are known to be part of titles
+func stripLearnedPMVNoise(filename string, baseTokens []string, weights pmvTokenWeights) []string {
+	inBase := map[string]bool{}
+	for _, tok := range baseTokens {
+		inBase[tok] = true
+	}
+
+	out := make([]string, 0, len(baseTokens))
+	for _, tok := range pmvFilenameTokens(filename) {
+		if weights.isNoise(tok) {
+			continue
+		}
+		if inBase[tok] || weights.isSignal(tok) {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
+
+func ListPMVTokenWeights(volumeID uint, pathPrefix string, token string) ([]models.PMVTokenWeight, int, error) {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	q := db.Order("volume_id, path_prefix, token")
+	if volumeID != 0 {
+		q = q.Where("volume_id = ?", volumeID)
+	}
+	if pathPrefix != "" {
+		q = q.Where("path_prefix like ?", pathPrefix+"%")
+	}
+	if token != "" {
+		q = q.Where("token = ?", strings.ToLower(token))
+	}
+
+	var rows []models.PMVTokenWeight
+	if err := q.Find(&rows).Error; err != nil {
+		return nil, 500, err
+	}
+	return rows, 200, nil
+}
+
+// SavePMVTokenWeight sets the weight of a token by hand, learning keeps counting but no longer changes it
+func SavePMVTokenWeight(req PMVTokenWeightRequest) (*models.PMVTokenWeight, int, error) {
+	tokens := pmvTokenWords(req.Token)
+	if len(tokens) != 1 || len(tokens[0]) < 2 {
+		return nil, 400, errors.New("token must be a single word")
+	}
+	if req.Weight < -1 || req.Weight > 1 {
+		return nil, 400, errors.New("weight must be between -1 and 1")
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var row models.PMVTokenWeight
+	err := db.Where("volume_id = ? and path_prefix = ? and token = ?", req.VolumeID, req.PathPrefix, tokens[0]).First(&row).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, 500, err
+	}
+	row.VolumeID = req.VolumeID
+	row.PathPrefix = req.PathPrefix
+	row.Token = tokens[0]
+	row.Weight = req.Weight
+	row.Manual = true
+	if err := db.Save(&row).Error; err != nil {
+		return nil, 500, err
+	}
+	return &row, 200, nil
+}
+
+func DeletePMVTokenWeight(id uint) (int, error) {
+	var row models.PMVTokenWeight
+	if err := row.GetIfExist(id); err != nil {
+		return 404, fmt.Errorf("token weight %d was not found", id)
+	}
+	row.Delete()
+	return 200, nil
+}
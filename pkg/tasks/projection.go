@@ -0,0 +1,358 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+const (
+	// Frames are squeezed into a square whatever the aspect ratio, so each half of a stereo frame is one eye
+	projectionFrameSize    = 64
+	projectionFrameBytes   = projectionFrameSize * projectionFrameSize
+	projectionSampleFrames = 5
+	projectionFrameTimeout = 20 * time.Second
+
+	// Halves of a stereo frame show the same picture from two eyes, unrelated halves of a flat video
+	// correlate far less
+	projectionStereoCorrelation = 0.7
+	// Analysis results below this confidence fall back to the aspect ratio rule
+	projectionMinConfidence = 0.6
+	// Frames this close to 16:9 are taken for flat video, stereo frames are 1:1 or 2:1
+	projectionFlatAspectTolerance = 0.05
+)
+
+type projectionAnalysis struct {
+	projection string
+	confidence float64
+}
+
+// projectionFromFilename returns the projection named in the filename, e.g. mkx200, fisheye or mono_360,
+// and whether the video has an alpha channel for passthrough
+func projectionFromFilename(path string) (string, bool, bool) {
+	projection := ""
+	nameparts := filenameSeparator.Split(strings.ToLower(filepath.Base(path)), -1)
+	for i, part := range nameparts {
+		if part == "mkx200" || part == "mkx220" || part == "rf52" || part == "fisheye190" || part == "vrca220" || part == "flat" {
+			projection = part
+			break
+		} else if part == "fisheye" || part == "f180" || part == "180f" {
+			projection = "fisheye"
+			break
+		} else if i < len(nameparts)-1 && (part+"_"+nameparts[i+1] == "mono_360" || part+"_"+nameparts[i+1] == "mono_180") {
+			projection = nameparts[i+1] + "_mono"
+			break
+		} else if i < len(nameparts)-1 && (part+"_"+nameparts[i+1] == "360_mono" || part+"_"+nameparts[i+1] == "180_mono") {
+			projection = part + "_mono"
+			break
+		}
+	}
+	if projection == "" {
+		return "", false, false
+	}
+
+	alpha := false
+	if projection == "mkx200" || projection == "mkx220" || projection == "rf52" || projection == "fisheye190" || projection == "vrca220" {
+		// alpha passthrough only works with fisheye projections
+		for _, part := range nameparts {
+			if part == "alpha" {
+				alpha = true
+				break
+			}
+		}
+	}
+	return projection, alpha, true
+}
+
+// projectionFromAspect is the old guess from the frame size, square videos are 360 top/bottom, 16:9 videos are
+// flat and anything else wider than high is 180 side by side. Volumes of PMVs and flat videos default to flat instead.
+func projectionFromAspect(width int, height int, contentType string) string {
+	switch {
+	case contentType == models.VolumeContentPMV || contentType == models.VolumeContentFlat:
+		return "flat"
+	case height > 0 && math.Abs(float64(width)/float64(height)-16.0/9) < projectionFlatAspectTolerance:
+		return "flat"
+	case width == height:
+		return "360_tb"
+	case width > height:
+		return "180_sbs"
+	}
+	return "flat"
+}
+
+// applyVideoProjection sets the projection of a probed video. Projections chosen by the user are kept, names
+// in the filename win over frame analysis, and the aspect ratio is the last resort.
+func applyVideoProjection(fl *models.File, analysis *projectionAnalysis, contentType string) {
+	projection, alpha, ok := projectionFromFilename(fl.Filename)
+	fl.HasAlpha = alpha
+	if fl.ProjectionSource == models.ProjectionSourceUser {
+		return
+	}
+
+	switch {
+	case ok:
+		fl.VideoProjection = projection
+		fl.ProjectionConfidence = 1
+		fl.ProjectionSource = models.ProjectionSourceFilename
+	case analysis != nil && analysis.confidence >= projectionMinConfidence:
+		fl.VideoProjection = analysis.projection
+		fl.ProjectionConfidence = analysis.confidence
+		fl.ProjectionSource = models.ProjectionSourceAnalysis
+	default:
+		fl.VideoProjection = projectionFromAspect(fl.VideoWidth, fl.VideoHeight, contentType)
+		fl.ProjectionConfidence = 0
+		fl.ProjectionSource = models.ProjectionSourceAspect
+	}
+}
+
+// analyseVideoProjection samples frames with ffmpeg and classifies their layout, input is a path or an URL
+func analyseVideoProjection(input string, duration float64, width int, height int) (*projectionAnalysis, error) {
+	if duration <= 0 || width <= 0 || height <= 0 {
+		return nil, errors.New("unknown video size or duration")
+	}
+
+	var frames [][]byte
+	interval := duration / float64(projectionSampleFrames+1)
+	for i := 1; i <= projectionSampleFrames; i++ {
+		out, err := runFFmpegOutputTimeout(projectionFrameTimeout, nil,
+			"-v", "error",
+			"-ss", strconv.FormatFloat(interval*float64(i), 'f', 2, 64),
+			"-i", input,
+			"-frames:v", "1",
+			"-vf", fmt.Sprintf("scale=%d:%d:flags=area,format=gray", projectionFrameSize, projectionFrameSize),
+			"-f", "rawvideo",
+			"pipe:1",
+		)
+		if err != nil {
+			log.Debugf("projection analysis: frame %d of %s failed: %v", i, input, err)
+			continue
+		}
+		if len(out) >= projectionFrameBytes {
+			frames = append(frames, out[:projectionFrameBytes])
+		}
+	}
+
+	projection, confidence, ok := classifyVideoProjection(frames, width, height)
+	if !ok {
+		return nil, errors.New("no usable frames")
+	}
+	return &projectionAnalysis{projection: projection, confidence: confidence}, nil
+}
+
+// classifyVideoProjection compares the halves of square grayscale frames to tell side by side and top/bottom
+// stereo from mono video, and looks for the black corners around the image circle of fisheye lenses.
+// Mono video is told apart by aspect ratio, equirectangular 360 is 2:1 and 180 is square.
+func classifyVideoProjection(frames [][]byte, width int, height int) (string, float64, bool) {
+	n := projectionFrameSize
+	var lr, tb, fisheye float64
+	used := 0
+	for _, f := range frames {
+		if len(f) < projectionFrameBytes || frameDeviation(f, 0, 0, n, n) < 4 {
+			// Black or blank frames, e.g. fades, say nothing about the layout
+			continue
+		}
+		lr += regionCorrelation(f, 0, 0, n/2, 0, n/2, n)
+		tb += regionCorrelation(f, 0, 0, 0, n/2, n, n/2)
+		if hasFisheyeMask(f, 0, 0, n/2, n) {
+			fisheye++
+		}
+		used++
+	}
+	if used == 0 {
+		return "", 0, false
+	}
+	lr /= float64(used)
+	tb /= float64(used)
+	fisheye /= float64(used)
+
+	switch {
+	case lr >= projectionStereoCorrelation && lr >= tb:
+		if fisheye >= 0.5 {
+			return "fisheye", clampScore((lr + fisheye) / 2), true
+		}
+		return "180_sbs", clampScore(lr), true
+	case tb >= projectionStereoCorrelation:
+		return "360_tb", clampScore(tb), true
+	}
+
+	confidence := clampScore(1 - math.Max(math.Max(lr, tb), 0))
+	ratio := float64(width) / float64(height)
+	switch {
+	case ratio > 1.9 && ratio < 2.1:
+		return "360_mono", confidence * 0.75, true
+	case ratio > 0.95 && ratio < 1.05:
+		return "180_mono", confidence * 0.75, true
+	}
+	return "flat", confidence, true
+}
+
+func frameDeviation(f []byte, x0 int, y0 int, w int, h int) float64 {
+	var sum, sq float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			v := float64(f[y*projectionFrameSize+x])
+			sum += v
+			sq += v * v
+		}
+	}
+	count := float64(w * h)
+	mean := sum / count
+	return math.Sqrt(math.Max(sq/count-mean*mean, 0))
+}
+
+// regionCorrelation is the normalised cross-correlation of two equally sized regions of a frame
+func regionCorrelation(f []byte, ax int, ay int, bx int, by int, w int, h int) float64 {
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := float64(f[(ay+y)*projectionFrameSize+ax+x])
+			b := float64(f[(by+y)*projectionFrameSize+bx+x])
+			sumA += a
+			sumB += b
+			sumAA += a * a
+			sumBB += b * b
+			sumAB += a * b
+		}
+	}
+	count := float64(w * h)
+	cov := sumAB/count - sumA/count*sumB/count
+	varA := sumAA/count - sumA/count*sumA/count
+	varB := sumBB/count - sumB/count*sumB/count
+	if varA <= 0 || varB <= 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varA*varB)
+}
+
+// hasFisheyeMask reports whether a region is a bright ellipse on black, as recorded by fisheye lenses
+func hasFisheyeMask(f []byte, x0 int, y0 int, w int, h int) bool {
+	var inside, outside float64
+	var insideCount, outsideCount int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx := (float64(x)+0.5)/float64(w)*2 - 1
+			dy := (float64(y)+0.5)/float64(h)*2 - 1
+			d := dx*dx + dy*dy
+			v := float64(f[(y0+y)*projectionFrameSize+x0+x])
+			if d > 1.1 {
+				outside += v
+				outsideCount++
+			} else if d < 0.8 {
+				inside += v
+				insideCount++
+			}
+		}
+	}
+	if insideCount == 0 || outsideCount == 0 {
+		return false
+	}
+	outside /= float64(outsideCount)
+	inside /= float64(insideCount)
+	return outside < 12 && inside > outside+25
+}
+
+// analyseProbedVideo runs the frame analysis for a probed video, unless its filename already names the projection
+func analyseProbedVideo(p *videoProbe, input string, tlog *logrus.Entry) {
+	if !config.Config.Storage.AnalyseProjection || p.probeErr != nil || p.ffdata == nil {
+		return
+	}
+	if _, _, ok := projectionFromFilename(p.path); ok {
+		return
+	}
+	vs := p.ffdata.GetFirstVideoStream()
+	if vs == nil {
+		return
+	}
+
+	analysis, err := analyseVideoProjection(input, p.duration(), vs.Width, vs.Height)
+	if err != nil {
+		tlog.Debugf("Can't analyse projection of %v: %v", p.path, err)
+		return
+	}
+	p.projection = analysis
+}
+
+// AnalyseProjections classifies local videos whose projection is still a guess from their aspect ratio
+func AnalyseProjections() {
+	tlog := log.WithField("task", "projection")
+	if models.CheckLock("projection") {
+		tlog.Infof("skipped: task already running")
+		return
+	}
+	models.CreateLock("projection")
+	defer models.RemoveLock("projection")
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var files []models.File
+	db.Preload("Volume").
+		Where("type = ? and (projection_source = ? or projection_source = ?)", "video", "", models.ProjectionSourceAspect).
+		Find(&files)
+
+	changed := 0
+	for i := range files {
+		if files[i].Volume.Type != "local" || !files[i].Exists() {
+			continue
+		}
+		tlog.Infof("Analysing projection of %v (%v/%v)", files[i].Filename, i+1, len(files))
+		if updated, err := analyseFileProjection(db, &files[i]); err != nil {
+			tlog.Debugf("Can't analyse projection of %v: %v", files[i].GetPath(), err)
+		} else if updated {
+			changed++
+		}
+	}
+
+	tlog.Infof("Analysed %v videos, %v changed projection", len(files), changed)
+	common.PublishWS("projection.done", map[string]interface{}{"analysed": len(files), "changed": changed})
+}
+
+// ResetFileProjection drops a user override and detects the projection of the file again
+func ResetFileProjection(file *models.File) error {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	file.ProjectionSource = ""
+	if file.Volume.Type == "local" && file.Exists() && config.Config.Storage.AnalyseProjection {
+		if _, err := analyseFileProjection(db, file); err == nil {
+			return nil
+		}
+	}
+	applyVideoProjection(file, nil, file.Volume.ContentType)
+	return saveFileProjection(db, file)
+}
+
+func saveFileProjection(db *gorm.DB, file *models.File) error {
+	return db.Model(file).Updates(map[string]interface{}{
+		"video_projection":      file.VideoProjection,
+		"projection_confidence": file.ProjectionConfidence,
+		"projection_source":     file.ProjectionSource,
+		"has_alpha":             file.HasAlpha,
+	}).Error
+}
+
+func analyseFileProjection(db *gorm.DB, file *models.File) (bool, error) {
+	if _, _, ok := projectionFromFilename(file.Filename); ok {
+		before := file.VideoProjection
+		applyVideoProjection(file, nil, file.Volume.ContentType)
+		return before != file.VideoProjection, saveFileProjection(db, file)
+	}
+
+	analysis, err := analyseVideoProjection(file.GetPath(), file.VideoDuration, file.VideoWidth, file.VideoHeight)
+	if err != nil {
+		return false, err
+	}
+	before := file.VideoProjection
+	applyVideoProjection(file, analysis, file.Volume.ContentType)
+	return before != file.VideoProjection, saveFileProjection(db, file)
+}
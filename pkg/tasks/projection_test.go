@@ -0,0 +1,158 @@
+package tasks
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// texture returns a smooth random picture, neighbouring pixels are similar like in real footage
+func texture(seed int64, w int, h int) []byte {
+	r := rand.New(rand.NewSource(seed))
+	out := make([]byte, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 128.0
+			if x > 0 {
+				v = float64(out[y*w+x-1])
+			}
+			if y > 0 {
+				v = (v + float64(out[(y-1)*w+x])) / 2
+			}
+			v += float64(r.Intn(61) - 30)
+			if v < 30 {
+				v = 30
+			}
+			if v > 230 {
+				v = 230
+			}
+			out[y*w+x] = byte(v)
+		}
+	}
+	return out
+}
+
+// paste copies a w*h picture into a frame, shifted by dx to fake the disparity between eyes
+func paste(frame []byte, pic []byte, x0 int, y0 int, w int, h int, dx int) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx := x + dx
+			if sx < 0 {
+				sx = 0
+			}
+			if sx >= w {
+				sx = w - 1
+			}
+			frame[(y0+y)*projectionFrameSize+x0+x] = pic[y*w+sx]
+		}
+	}
+}
+
+func blackCorners(frame []byte, x0 int, y0 int, w int, h int) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx := (float64(x)+0.5)/float64(w)*2 - 1
+			dy := (float64(y)+0.5)/float64(h)*2 - 1
+			if dx*dx+dy*dy > 1 {
+				frame[(y0+y)*projectionFrameSize+x0+x] = 0
+			}
+		}
+	}
+}
+
+func TestClassifyVideoProjection(t *testing.T) {
+	n := projectionFrameSize
+	var sbs, tb, fisheye, flat [][]byte
+	for i := int64(0); i < 3; i++ {
+		eye := texture(i, n/2, n)
+		f := make([]byte, n*n)
+		paste(f, eye, 0, 0, n/2, n, 0)
+		paste(f, eye, n/2, 0, n/2, n, 1)
+		sbs = append(sbs, f)
+
+		fe := append([]byte{}, f...)
+		blackCorners(fe, 0, 0, n/2, n)
+		blackCorners(fe, n/2, 0, n/2, n)
+		fisheye = append(fisheye, fe)
+
+		half := texture(i+10, n, n/2)
+		f = make([]byte, n*n)
+		paste(f, half, 0, 0, n, n/2, 0)
+		paste(f, half, 0, n/2, n, n/2, 1)
+		tb = append(tb, f)
+
+		flat = append(flat, texture(i+20, n, n))
+	}
+
+	cases := []struct {
+		name   string
+		frames [][]byte
+		width  int
+		height int
+		want   string
+	}{
+		{"side by side", sbs, 5760, 2880, "180_sbs"},
+		{"fisheye", fisheye, 5760, 2880, "fisheye"},
+		{"top bottom", tb, 4096, 4096, "360_tb"},
+		{"flat pmv", flat, 1920, 1080, "flat"},
+		{"mono 360", flat, 3840, 1920, "360_mono"},
+	}
+	for _, c := range cases {
+		got, confidence, ok := classifyVideoProjection(c.frames, c.width, c.height)
+		if !ok {
+			t.Fatalf("%s: expected a result", c.name)
+		}
+		if got != c.want {
+			t.Fatalf("%s: expected %s, got %s (confidence %.2f)", c.name, c.want, got, confidence)
+		}
+		if c.want != "360_mono" && confidence < projectionMinConfidence {
+			t.Fatalf("%s: expected confidence of at least %.2f, got %.2f", c.name, projectionMinConfidence, confidence)
+		}
+	}
+
+	if _, _, ok := classifyVideoProjection([][]byte{make([]byte, n*n)}, 1920, 1080); ok {
+		t.Fatalf("expected black frames to be unusable")
+	}
+}
+
+func TestApplyVideoProjection(t *testing.T) {
+	fl := models.File{Filename: "Some PMV 1080p.mp4", VideoWidth: 1920, VideoHeight: 1080}
+	applyVideoProjection(&fl, &projectionAnalysis{projection: "flat", confidence: 0.9}, "")
+	if fl.VideoProjection != "flat" || fl.ProjectionSource != models.ProjectionSourceAnalysis {
+		t.Fatalf("expected flat from analysis, got %s from %s", fl.VideoProjection, fl.ProjectionSource)
+	}
+
+	fl = models.File{Filename: "scene.mp4", VideoWidth: 4096, VideoHeight: 2048}
+	applyVideoProjection(&fl, &projectionAnalysis{projection: "flat", confidence: 0.3}, "")
+	if fl.VideoProjection != "180_sbs" || fl.ProjectionSource != models.ProjectionSourceAspect {
+		t.Fatalf("expected the aspect ratio guess for an unsure analysis, got %s from %s", fl.VideoProjection, fl.ProjectionSource)
+	}
+	if fl.ProjectionConfidence != 0 {
+		t.Fatalf("expected no confidence for the aspect ratio guess, got %v", fl.ProjectionConfidence)
+	}
+
+	fl = models.File{Filename: "scene.mp4", VideoWidth: 1920, VideoHeight: 1080}
+	applyVideoProjection(&fl, nil, "")
+	if fl.VideoProjection != "flat" {
+		t.Fatalf("expected 16:9 videos to default to flat, got %s", fl.VideoProjection)
+	}
+
+	fl = models.File{Filename: "scene_MKX200_alpha.mp4", VideoWidth: 5760, VideoHeight: 2880}
+	applyVideoProjection(&fl, &projectionAnalysis{projection: "180_sbs", confidence: 0.9}, "")
+	if fl.VideoProjection != "mkx200" || !fl.HasAlpha || fl.ProjectionSource != models.ProjectionSourceFilename {
+		t.Fatalf("expected mkx200 with alpha from the filename, got %s alpha=%v from %s", fl.VideoProjection, fl.HasAlpha, fl.ProjectionSource)
+	}
+
+	fl = models.File{Filename: "Another PMV.mp4", VideoWidth: 1920, VideoHeight: 1080}
+	applyVideoProjection(&fl, nil, models.VolumeContentPMV)
+	if fl.VideoProjection != "flat" {
+		t.Fatalf("expected PMV volumes to default to flat, got %s", fl.VideoProjection)
+	}
+
+	fl = models.File{Filename: "scene.mp4", VideoWidth: 5760, VideoHeight: 2880, VideoProjection: "flat", ProjectionSource: models.ProjectionSourceUser}
+	applyVideoProjection(&fl, &projectionAnalysis{projection: "180_sbs", confidence: 0.9}, "")
+	if fl.VideoProjection != "flat" {
+		t.Fatalf("expected the user override to be kept, got %s", fl.VideoProjection)
+	}
+}
@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// Number of reports of each kind kept in the download folder, older ones are removed when a new one is written
+const keptReports = 5
+
+// taskReport stores the JSON reports of a task in the download folder, the name of the latest one is kept in the KV table
+type taskReport struct {
+	key     string
+	prefix  string
+	missing string
+}
+
+var (
+	pmvBatchReports  = taskReport{key: "pmv-match-report", prefix: "pmv-match-report", missing: "no pmv-match report available"}
+	duplicateReports = taskReport{key: "duplicate-report", prefix: "duplicate-report", missing: "no duplicate report available"}
+	doctorReports    = taskReport{key: "doctor-report", prefix: "doctor-report", missing: "no health check report available"}
+)
+
+// fileName of the report of a run, reports can be downloaded from /download/<report_file>
+func (r taskReport) fileName(runID string) string {
+	return fmt.Sprintf("%s-%s.json", r.prefix, runID)
+}
+
+func (r taskReport) write(file string, report interface{}) error {
+	content, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(common.DownloadDir, file), content, 0644); err != nil {
+		return err
+	}
+
+	kv := models.KV{Key: r.key, Value: file}
+	kv.Save()
+	r.prune(file)
+	return nil
+}
+
+// prune removes all but the newest reports, the latest one is never removed
+func (r taskReport) prune(latest string) {
+	files, _ := filepath.Glob(filepath.Join(common.DownloadDir, r.prefix+"-*.json"))
+	if len(files) <= keptReports {
+		return
+	}
+
+	modTimes := map[string]int64{}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			modTimes[f] = fi.ModTime().UnixNano()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if modTimes[files[i]] != modTimes[files[j]] {
+			return modTimes[files[i]] > modTimes[files[j]]
+		}
+		return files[i] > files[j]
+	})
+	for _, f := range files[keptReports:] {
+		if filepath.Base(f) != latest {
+			os.Remove(f)
+		}
+	}
+}
+
+// readLast loads the latest report into report and returns the status code to answer with
+func (r taskReport) readLast(report interface{}) (int, error) {
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	var kv models.KV
+	if err := db.Where(&models.KV{Key: r.key}).First(&kv).Error; err != nil || kv.Value == "" {
+		return 404, errors.New(r.missing)
+	}
+
+	content, err := os.ReadFile(filepath.Join(common.DownloadDir, kv.Value))
+	if err != nil {
+		return 404, err
+	}
+	if err := json.Unmarshal(content, report); err != nil {
+		return 500, err
+	}
+	return 200, nil
+}
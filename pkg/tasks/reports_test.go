@@ -0,0 +1,37 @@
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xbapps/xbvr/pkg/common"
+)
+
+func TestTaskReportPrune(t *testing.T) {
+	dir := t.TempDir()
+	defer func(old string) { common.DownloadDir = old }(common.DownloadDir)
+	common.DownloadDir = dir
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < keptReports+3; i++ {
+		file := filepath.Join(dir, duplicateReports.fileName(fmt.Sprint(i)))
+		writeTestFile(t, file, "{}")
+		os.Chtimes(file, start.Add(time.Duration(i)*time.Minute), start.Add(time.Duration(i)*time.Minute))
+	}
+	writeTestFile(t, filepath.Join(dir, doctorReports.fileName("0")), "{}")
+
+	duplicateReports.prune(duplicateReports.fileName(fmt.Sprint(keptReports + 2)))
+
+	for i := 0; i < keptReports+3; i++ {
+		_, err := os.Stat(filepath.Join(dir, duplicateReports.fileName(fmt.Sprint(i))))
+		if kept := i >= 3; kept != (err == nil) {
+			t.Fatalf("report %d: expected kept=%v, got %v", i, kept, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, doctorReports.fileName("0"))); err != nil {
+		t.Fatalf("expected reports of other tasks to be left alone, got %v", err)
+	}
+}
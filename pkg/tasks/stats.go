@@ -0,0 +1,148 @@
+package tasks
+
+import (
+	"sort"
+	"time"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+type StorageStatBucket struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+type StorageStatFile struct {
+	FileID     uint       `json:"file_id"`
+	SceneID    uint       `json:"scene_id"`
+	SceneTitle string     `json:"scene_title"`
+	VolumePath string     `json:"volume_path"`
+	Path       string     `json:"path"`
+	Filename   string     `json:"filename"`
+	Size       int64      `json:"size"`
+	WatchTime  int        `json:"watch_time"`
+	LastOpened *time.Time `json:"last_opened"`
+}
+
+type StorageStats struct {
+	Files        int                 `json:"files"`
+	Videos       int                 `json:"videos"`
+	TotalSize    int64               `json:"total_size"`
+	ByVolume     []StorageStatBucket `json:"by_volume"`
+	BySite       []StorageStatBucket `json:"by_site"`
+	ByStudio     []StorageStatBucket `json:"by_studio"`
+	ByResolution []StorageStatBucket `json:"by_resolution"`
+	ByCodec      []StorageStatBucket `json:"by_codec"`
+	Largest      []StorageStatFile   `json:"largest"`
+	LeastWatched []StorageStatFile   `json:"least_watched"`
+}
+
+// resolutionBucket uses the same ranges as the resolution filter of the file list
+func resolutionBucket(height int) string {
+	switch {
+	case height <= 0:
+		return "unknown"
+	case height < 1900:
+		return "below4k"
+	case height < 2450:
+		return "4k"
+	case height < 2900:
+		return "5k"
+	case height < 3300:
+		return "6k"
+	default:
+		return "above6k"
+	}
+}
+
+func sortStatBuckets(buckets []StorageStatBucket) []StorageStatBucket {
+	if buckets == nil {
+		buckets = []StorageStatBucket{}
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Size != buckets[j].Size {
+			return buckets[i].Size > buckets[j].Size
+		}
+		return buckets[i].Name < buckets[j].Name
+	})
+	return buckets
+}
+
+// GetStorageStats breaks down the size of the library and lists the files that free the most space. Breakdowns
+// other than by volume only count videos, least watched only lists videos matched to a scene.
+func GetStorageStats(limit int) (*StorageStats, int, error) {
+	if limit <= 0 {
+		limit = 25
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	out := &StorageStats{}
+
+	var totals struct {
+		Files  int
+		Videos int
+		Size   int64
+	}
+	if err := db.Raw(`select count(*) as files,
+		coalesce(sum(case when type = 'video' then 1 else 0 end), 0) as videos,
+		coalesce(sum(size), 0) as size
+		from files`).Scan(&totals).Error; err != nil {
+		return nil, 500, err
+	}
+	out.Files = totals.Files
+	out.Videos = totals.Videos
+	out.TotalSize = totals.Size
+
+	group := func(column string, join string, where string) []StorageStatBucket {
+		var buckets []StorageStatBucket
+		db.Raw(`select coalesce(` + column + `, '') as name, count(*) as files, coalesce(sum(files.size), 0) as size
+			from files ` + join + ` ` + where + ` group by coalesce(` + column + `, '')`).Scan(&buckets)
+		return sortStatBuckets(buckets)
+	}
+	out.ByVolume = group("volumes.path", "left join volumes on volumes.id = files.volume_id", "")
+	out.BySite = group("scenes.site", "left join scenes on scenes.id = files.scene_id", "where files.type = 'video'")
+	out.ByStudio = group("scenes.studio", "left join scenes on scenes.id = files.scene_id", "where files.type = 'video'")
+	out.ByCodec = group("files.video_codec_name", "", "where files.type = 'video'")
+
+	var heights []struct {
+		VideoHeight int
+		Files       int
+		Size        int64
+	}
+	db.Raw(`select video_height, count(*) as files, coalesce(sum(size), 0) as size from files where type = 'video' group by video_height`).Scan(&heights)
+	byResolution := map[string]*StorageStatBucket{}
+	for _, h := range heights {
+		name := resolutionBucket(h.VideoHeight)
+		if byResolution[name] == nil {
+			byResolution[name] = &StorageStatBucket{Name: name}
+		}
+		byResolution[name].Files += h.Files
+		byResolution[name].Size += h.Size
+	}
+	for _, b := range byResolution {
+		out.ByResolution = append(out.ByResolution, *b)
+	}
+	out.ByResolution = sortStatBuckets(out.ByResolution)
+
+	fileColumns := `files.id as file_id, files.scene_id, coalesce(scenes.title, '') as scene_title, coalesce(volumes.path, '') as volume_path,
+		files.path, files.filename, files.size, coalesce(scenes.total_watch_time, 0) as watch_time, scenes.last_opened`
+	out.Largest = []StorageStatFile{}
+	db.Raw(`select `+fileColumns+` from files
+		left join scenes on scenes.id = files.scene_id
+		left join volumes on volumes.id = files.volume_id
+		where files.type = 'video' order by files.size desc limit ?`, limit).Scan(&out.Largest)
+
+	out.LeastWatched = []StorageStatFile{}
+	db.Raw(`select `+fileColumns+` from files
+		join scenes on scenes.id = files.scene_id
+		left join volumes on volumes.id = files.volume_id
+		where files.type = 'video' order by scenes.total_watch_time asc, files.size desc limit ?`, limit).Scan(&out.LeastWatched)
+
+	return out, 200, nil
+}
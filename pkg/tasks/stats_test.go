@@ -0,0 +1,22 @@
+package tasks
+
+import "testing"
+
+func TestResolutionBucket(t *testing.T) {
+	cases := map[int]string{0: "unknown", 1080: "below4k", 1920: "4k", 2880: "5k", 3072: "6k", 4096: "above6k"}
+	for height, want := range cases {
+		if got := resolutionBucket(height); got != want {
+			t.Fatalf("%d: expected %s, got %s", height, want, got)
+		}
+	}
+}
+
+func TestSortStatBuckets(t *testing.T) {
+	buckets := sortStatBuckets([]StorageStatBucket{{Name: "b", Size: 10}, {Name: "c", Size: 30}, {Name: "a", Size: 10}})
+	if buckets[0].Name != "c" || buckets[1].Name != "a" || buckets[2].Name != "b" {
+		t.Fatalf("expected buckets by size then name, got %+v", buckets)
+	}
+	if empty := sortStatBuckets(nil); empty == nil || len(empty) != 0 {
+		t.Fatalf("expected an empty list instead of nil")
+	}
+}
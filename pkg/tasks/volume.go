@@ -3,6 +3,7 @@ package tasks
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/djherbis/times"
@@ -23,6 +25,7 @@ import (
 	"github.com/xbapps/xbvr/pkg/ffprobe"
 	"github.com/xbapps/xbvr/pkg/models"
 	"github.com/xbapps/xbvr/pkg/scrape"
+	"github.com/xbapps/xbvr/pkg/storage"
 )
 
 // The allowed video extensions are set in the config.go file as they now are user configurable
@@ -41,7 +44,9 @@ func RescanVolumes(id int) {
 		tlog := log.WithFields(logrus.Fields{"task": "rescan"})
 		tlog.Infof("Start scanning volumes")
 
-		models.CheckVolumes()
+		if models.CheckVolumes() {
+			go RefreshVolumeWatchers()
+		}
 
 		db, _ := models.GetDB()
 		defer db.Close()
@@ -61,113 +66,23 @@ func RescanVolumes(id int) {
 				scanLocalVolume(vol[i], db, tlog)
 			case "putio":
 				scanPutIO(vol[i], db, tlog)
+			case storage.TypeSFTP, storage.TypeWebDAV, storage.TypeS3:
+				scanRemoteVolume(vol[i], db, tlog)
+			}
+		}
+
+		// Check if files are still present at the location
+		for i := range vol {
+			if vol[i].Type == "local" {
+				removeMissingLocalVolumeFiles(vol[i], db)
 			}
 		}
 
 		// Match Scene to File
 		var files []models.File
-		var scenes []models.Scene
-		var extrefs []models.ExternalReference
-
 		tlog.Infof("Matching Scenes to known filenames")
 		db.Model(&models.File{}).Where("files.scene_id = 0").Find(&files)
-
-		escape := func(s string) string {
-			var buffer bytes.Buffer
-			json.HTMLEscape(&buffer, []byte(s))
-			return buffer.String()
-		}
-
-		for i := range files {
-			unescapedFilename := path.Base(files[i].Filename)
-			filename := escape(unescapedFilename)
-			filename2 := strings.Replace(filename, ".funscript", ".mp4", -1)
-			filename3 := strings.Replace(filename, ".hsp", ".mp4", -1)
-			filename4 := strings.Replace(filename, ".srt", ".mp4", -1)
-			filename5 := strings.Replace(filename, ".cmscript", ".mp4", -1)
-			err := db.Where("filenames_arr LIKE ? OR filenames_arr LIKE ? OR filenames_arr LIKE ? OR filenames_arr LIKE ? OR filenames_arr LIKE ?", `%"`+filename+`"%`, `%"`+filename2+`"%`, `%"`+filename3+`"%`, `%"`+filename4+`"%`, `%"`+filename5+`"%`).Find(&scenes).Error
-			if err != nil {
-				log.Error(err, " when matching "+unescapedFilename)
-			}
-			if len(scenes) == 0 && config.Config.Advanced.UseAltSrcInFileMatching {
-				// check if the filename matches in external_reference record
-
-				db.Preload("XbvrLinks").Where("external_source like 'alternate scene %' and external_data LIKE ? OR external_data LIKE ? OR external_data LIKE ? OR external_data LIKE ? OR external_data LIKE ?", `%"`+filename+`%`, `%"`+filename2+`%`, `%"`+filename3+`%`, `%"`+filename4+`%`, `%"`+filename5+`%`).Find(&extrefs)
-				if len(extrefs) == 1 {
-					if len(extrefs[0].XbvrLinks) == 1 {
-						// the scene id will be the Internal DB Id from the associated link
-						var scene models.Scene
-						scene.GetIfExistByPK(extrefs[0].XbvrLinks[0].InternalDbId)
-						// Add File to the list of Scene filenames
-						var pfTxt []string
-						err = json.Unmarshal([]byte(scene.FilenamesArr), &pfTxt)
-						if err != nil {
-							continue
-						}
-						pfTxt = append(pfTxt, files[i].Filename)
-						tmp, err := json.Marshal(pfTxt)
-						if err == nil {
-							scene.FilenamesArr = string(tmp)
-						}
-						scene.Save()
-						scenes = append(scenes, scene)
-					}
-				}
-			}
-			if len(scenes) == 1 {
-				files[i].SceneID = scenes[0].ID
-				files[i].Save()
-				scenes[0].UpdateStatus()
-			} else {
-				if config.Config.Storage.MatchOhash && config.Config.Advanced.StashApiKey != "" {
-					hash := files[i].OsHash
-					if len(hash) < 16 {
-						// the has in xbvr is sometiomes < 16 pad with zeros
-						paddingLength := 16 - len(hash)
-						hash = strings.Repeat("0", paddingLength) + hash
-					}
-					queryVariable := `
-				{"input":{
-					"fingerprints": {					
-						"value": "` + hash + `",
-						"modifier": "INCLUDES"
-					},				
-					"page": 1
-				}
-				}`
-					// call Stashdb graphql searching for os_hash
-					stashMatches := scrape.GetScenePage(queryVariable)
-					for _, match := range stashMatches.Data.QueryScenes.Scenes {
-						if match.ID != "" {
-							var externalRefLink models.ExternalReferenceLink
-							db.Where(&models.ExternalReferenceLink{ExternalSource: "stashdb scene", ExternalId: match.ID}).First(&externalRefLink)
-							if externalRefLink.ID != 0 {
-								files[i].SceneID = externalRefLink.InternalDbId
-								files[i].Save()
-								var scene models.Scene
-								scene.GetIfExistByPK(externalRefLink.InternalDbId)
-
-								// add filename tyo the array
-								var pfTxt []string
-								json.Unmarshal([]byte(scene.FilenamesArr), &pfTxt)
-								pfTxt = append(pfTxt, files[i].Filename)
-								tmp, _ := json.Marshal(pfTxt)
-								scene.FilenamesArr = string(tmp)
-								scene.Save()
-								models.AddAction(scene.SceneID, "match", "filenames_arr", scene.FilenamesArr)
-
-								scene.UpdateStatus()
-								log.Infof("File %s matched to Scene %s matched using stashdb hash %s", path.Base(files[i].Filename), scene.SceneID, hash)
-							}
-						}
-					}
-				}
-			}
-
-			if (i % 50) == 0 {
-				tlog.Infof("Matching Scenes to known filenames (%v/%v)", i+1, len(files))
-			}
-		}
+		matchFilesToScenes(db, tlog, files)
 
 		tlog.Infof("Generating heatmaps")
 
@@ -208,12 +123,130 @@ func RescanVolumes(id int) {
 
 		r = models.RequestSceneList{IsWatched: optional.NewBool(false), IsAvailable: optional.NewBool(true)}
 		common.AddMetricPoint("scenes_downloaded_unwatched", float64(models.QueryScenes(r, false).Results))
+
+		// Volumes may have been added, removed or come back online
+		RefreshVolumeWatchers()
+	}
+}
+
+// matchFilesToScenes links unmatched files to scenes by their filename, alternate sources or stashdb hash
+func matchFilesToScenes(db *gorm.DB, tlog *logrus.Entry, files []models.File) {
+	var scenes []models.Scene
+	var extrefs []models.ExternalReference
+
+	escape := func(s string) string {
+		var buffer bytes.Buffer
+		json.HTMLEscape(&buffer, []byte(s))
+		return buffer.String()
+	}
+
+	// PMV volumes hold no studio scenes, their files are left to the PMV matcher
+	var pmvVolumes []uint
+	db.Model(&models.Volume{}).Where("content_type = ?", models.VolumeContentPMV).Pluck("id", &pmvVolumes)
+	isPMV := map[uint]bool{}
+	for _, id := range pmvVolumes {
+		isPMV[id] = true
+	}
+
+	for i := range files {
+		if isPMV[files[i].VolumeID] {
+			continue
+		}
+		unescapedFilename := path.Base(files[i].Filename)
+		filename := escape(unescapedFilename)
+		filename2 := strings.Replace(filename, ".funscript", ".mp4", -1)
+		filename3 := strings.Replace(filename, ".hsp", ".mp4", -1)
+		filename4 := strings.Replace(filename, ".srt", ".mp4", -1)
+		filename5 := strings.Replace(filename, ".cmscript", ".mp4", -1)
+		err := db.Where("filenames_arr LIKE ? OR filenames_arr LIKE ? OR filenames_arr LIKE ? OR filenames_arr LIKE ? OR filenames_arr LIKE ?", `%"`+filename+`"%`, `%"`+filename2+`"%`, `%"`+filename3+`"%`, `%"`+filename4+`"%`, `%"`+filename5+`"%`).Find(&scenes).Error
+		if err != nil {
+			log.Error(err, " when matching "+unescapedFilename)
+		}
+		if len(scenes) == 0 && config.Config.Advanced.UseAltSrcInFileMatching {
+			// check if the filename matches in external_reference record
+
+			db.Preload("XbvrLinks").Where("external_source like 'alternate scene %' and external_data LIKE ? OR external_data LIKE ? OR external_data LIKE ? OR external_data LIKE ? OR external_data LIKE ?", `%"`+filename+`%`, `%"`+filename2+`%`, `%"`+filename3+`%`, `%"`+filename4+`%`, `%"`+filename5+`%`).Find(&extrefs)
+			if len(extrefs) == 1 {
+				if len(extrefs[0].XbvrLinks) == 1 {
+					// the scene id will be the Internal DB Id from the associated link
+					var scene models.Scene
+					scene.GetIfExistByPK(extrefs[0].XbvrLinks[0].InternalDbId)
+					// Add File to the list of Scene filenames
+					var pfTxt []string
+					err = json.Unmarshal([]byte(scene.FilenamesArr), &pfTxt)
+					if err != nil {
+						continue
+					}
+					pfTxt = append(pfTxt, files[i].Filename)
+					tmp, err := json.Marshal(pfTxt)
+					if err == nil {
+						scene.FilenamesArr = string(tmp)
+					}
+					scene.Save()
+					scenes = append(scenes, scene)
+				}
+			}
+		}
+		if len(scenes) == 1 {
+			files[i].SceneID = scenes[0].ID
+			files[i].Save()
+			scenes[0].UpdateStatus()
+		} else {
+			if config.Config.Storage.MatchOhash && config.Config.Advanced.StashApiKey != "" {
+				hash := files[i].OsHash
+				if len(hash) < 16 {
+					// the has in xbvr is sometiomes < 16 pad with zeros
+					paddingLength := 16 - len(hash)
+					hash = strings.Repeat("0", paddingLength) + hash
+				}
+				queryVariable := `
+			{"input":{
+				"fingerprints": {					
+					"value": "` + hash + `",
+					"modifier": "INCLUDES"
+				},				
+				"page": 1
+			}
+			}`
+				// call Stashdb graphql searching for os_hash
+				stashMatches := scrape.GetScenePage(queryVariable)
+				for _, match := range stashMatches.Data.QueryScenes.Scenes {
+					if match.ID != "" {
+						var externalRefLink models.ExternalReferenceLink
+						db.Where(&models.ExternalReferenceLink{ExternalSource: "stashdb scene", ExternalId: match.ID}).First(&externalRefLink)
+						if externalRefLink.ID != 0 {
+							files[i].SceneID = externalRefLink.InternalDbId
+							files[i].Save()
+							var scene models.Scene
+							scene.GetIfExistByPK(externalRefLink.InternalDbId)
+
+							// add filename tyo the array
+							var pfTxt []string
+							json.Unmarshal([]byte(scene.FilenamesArr), &pfTxt)
+							pfTxt = append(pfTxt, files[i].Filename)
+							tmp, _ := json.Marshal(pfTxt)
+							scene.FilenamesArr = string(tmp)
+							scene.Save()
+							models.AddAction(scene.SceneID, "match", "filenames_arr", scene.FilenamesArr)
+
+							scene.UpdateStatus()
+							log.Infof("File %s matched to Scene %s matched using stashdb hash %s", path.Base(files[i].Filename), scene.SceneID, hash)
+						}
+					}
+				}
+			}
+		}
+
+		if (i % 50) == 0 {
+			tlog.Infof("Matching Scenes to known filenames (%v/%v)", i+1, len(files))
+		}
 	}
 }
 
 func scanLocalVolume(vol models.Volume, db *gorm.DB, tlog *logrus.Entry) {
-	allowedVideoExt := getAllowedVideoExt()
+	filter := newVolumeScanFilter(vol)
 	if vol.IsMounted() {
+		mounts := newMountedVolumes()
 
 		var videoProcList []string
 		var scriptProcList []string
@@ -223,185 +256,401 @@ func scanLocalVolume(vol models.Volume, db *gorm.DB, tlog *logrus.Entry) {
 			if err != nil {
 				return nil
 			}
+			if f.Mode().IsDir() && path != vol.Path && filter.skipDir(path) {
+				return filepath.SkipDir
+			}
 			if !f.Mode().IsDir() {
-				// Make sure the filename should be considered
-				if !strings.HasPrefix(filepath.Base(path), ".") && funk.Contains(allowedVideoExt, strings.ToLower(filepath.Ext(path))) {
-					var fl models.File
-					err = db.Where(&models.File{Path: filepath.Dir(path), Filename: filepath.Base(path)}).First(&fl).Error
-
-					if err == gorm.ErrRecordNotFound || fl.VolumeID == 0 || fl.VideoDuration == 0 || fl.VideoProjection == "" || fl.Size != f.Size() || fl.OsHash == "" {
+				switch filter.fileKind(path, f.Size()) {
+				case "video":
+					if videoNeedsScan(db, vol.ID, path, f.Size()) {
 						videoProcList = append(videoProcList, path)
 					}
-				}
-
-				if !strings.HasPrefix(filepath.Base(path), ".") && (filepath.Ext(path) == ".funscript" || strings.ToLower(filepath.Ext(path)) == ".cmscript") {
+				case "script":
 					scriptProcList = append(scriptProcList, path)
-				}
-				if !strings.HasPrefix(filepath.Base(path), ".") && filepath.Ext(path) == ".hsp" {
+				case "hsp":
 					hspProcList = append(hspProcList, path)
-				}
-				if !strings.HasPrefix(filepath.Base(path), ".") && (filepath.Ext(path) == ".srt" || filepath.Ext(path) == ".ssa" || filepath.Ext(path) == ".ass") {
+				case "subtitles":
 					subtitlesProcList = append(subtitlesProcList, path)
 				}
 			}
 			return nil
 		})
 
-		filenameSeparator := regexp.MustCompile("[ _.-]+")
+		scanVideoFiles(vol, db, mounts, videoProcList, func(path string) *videoProbe {
+			return hashLocalVideoFile(path, tlog)
+		}, tlog)
 
-		for j, path := range videoProcList {
-			fStat, _ := os.Stat(path)
-			fTimes, err := times.Stat(path)
-			if err != nil {
-				tlog.Errorf("Can't get the modification/creation times for %s, error: %s", path, err)
-			}
+		for _, path := range scriptProcList {
+			scanLocalScriptFile(vol, db, mounts, path)
+		}
 
-			var birthtime time.Time
-			if fTimes.HasBirthTime() {
-				birthtime = fTimes.BirthTime()
-			} else {
-				birthtime = fTimes.ModTime()
-			}
-			var fl models.File
-			db.Where(&models.File{
-				Path:     filepath.Dir(path),
-				Filename: filepath.Base(path),
-				Type:     "video",
-			}).FirstOrCreate(&fl)
-
-			fl.Size = fStat.Size()
-			fl.CreatedTime = birthtime
-			fl.UpdatedTime = fTimes.ModTime()
-			fl.VolumeID = vol.ID
-
-			hash, err := Hash(path)
-			if err == nil {
-				fl.OsHash = fmt.Sprintf("%x", hash)
-			}
+		for _, path := range hspProcList {
+			ScanLocalHspFile(path, vol.ID, 0)
+		}
 
-			ffdata, err := ffprobe.GetProbeData(path, time.Second*5)
-			if err != nil {
-				tlog.Error("Error running ffprobe", path, err)
-			} else {
-				vs := ffdata.GetFirstVideoStream()
-				if vs == nil {
-					tlog.Error("No video stream in file ", path)
-				} else {
-					if vs.BitRate != "" {
-						bitRate, _ := strconv.Atoi(vs.BitRate)
-						fl.VideoBitRate = bitRate
-					}
-					fl.VideoAvgFrameRate = vs.AvgFrameRate
-					fl.VideoCodecName = vs.CodecName
-					fl.VideoWidth = vs.Width
-					fl.VideoHeight = vs.Height
-					if dur, err := strconv.ParseFloat(vs.Duration, 64); err == nil {
-						fl.VideoDuration = dur
-					} else if ffdata.Format.DurationSeconds > 0.0 {
-						fl.VideoDuration = ffdata.Format.DurationSeconds
-					}
-					fl.HasAlpha = false
-
-					if vs.Height*2 == vs.Width || vs.Width > vs.Height {
-						fl.VideoProjection = "180_sbs"
-						nameparts := filenameSeparator.Split(strings.ToLower(filepath.Base(path)), -1)
-						for i, part := range nameparts {
-							if part == "mkx200" || part == "mkx220" || part == "rf52" || part == "fisheye190" || part == "vrca220" || part == "flat" {
-								fl.VideoProjection = part
-								break
-							} else if part == "fisheye" || part == "f180" || part == "180f" {
-								fl.VideoProjection = "fisheye"
-								break
-							} else if i < len(nameparts)-1 && (part+"_"+nameparts[i+1] == "mono_360" || part+"_"+nameparts[i+1] == "mono_180") {
-								fl.VideoProjection = nameparts[i+1] + "_mono"
-								break
-							} else if i < len(nameparts)-1 && (part+"_"+nameparts[i+1] == "360_mono" || part+"_"+nameparts[i+1] == "180_mono") {
-								fl.VideoProjection = part + "_mono"
-								break
-							}
-						}
-						if fl.VideoProjection == "mkx200" || fl.VideoProjection == "mkx220" || fl.VideoProjection == "rf52" || fl.VideoProjection == "fisheye190" || fl.VideoProjection == "vrca220" {
-							// alpha passthrough only works with fisheye projections
-							for _, part := range nameparts {
-								if part == "alpha" {
-									fl.HasAlpha = true
-									break
-								}
-							}
-						}
-					}
+		for _, path := range subtitlesProcList {
+			ScanLocalSubtitlesFile(path, vol.ID, 0)
+		}
 
-					if vs.Height == vs.Width {
-						fl.VideoProjection = "360_tb"
-					}
+		vol.LastScan = time.Now()
+		vol.Save()
+	}
+}
 
-					fl.CalculateFramerate()
-				}
-			}
+// removeMissingLocalVolumeFiles drops the files that are no longer present on a volume. It runs once every volume
+// was scanned so files moved to another volume are found there first.
+func removeMissingLocalVolumeFiles(vol models.Volume, db *gorm.DB) {
+	if !vol.IsMounted() {
+		return
+	}
 
-			err = fl.Save()
-			if err != nil {
-				tlog.Errorf("New file %s, but got error %s", path, err)
+	var scene models.Scene
+	allFiles := vol.Files()
+	for i := range allFiles {
+		if !allFiles[i].Exists() {
+			log.Info(allFiles[i].GetPath())
+			db.Delete(&allFiles[i])
+			if allFiles[i].SceneID != 0 {
+				scene.GetIfExistByPK(allFiles[i].SceneID)
+				scene.UpdateStatus()
 			}
-
-			tlog.Infof("Scanning %v (%v/%v)", vol.Path, j+1, len(videoProcList))
 		}
+	}
+}
 
-		for _, path := range scriptProcList {
-			var fl models.File
-			db.Where(&models.File{
-				Path:     filepath.Dir(path),
-				Filename: filepath.Base(path),
-				Type:     "script",
-			}).FirstOrCreate(&fl)
-
-			fStat, _ := os.Stat(path)
-			fTimes, _ := times.Stat(path)
-
-			if fStat.Size() != fl.Size {
-				fl.Size = fStat.Size()
-				fl.HasHeatmap = false
-				fl.VideoDuration = 0.0
-			}
+var filenameSeparator = regexp.MustCompile("[ _.-]+")
 
-			if fl.VideoDuration < 0.01 {
-				duration, err := getFunscriptDuration(path)
-				if err == nil {
-					fl.VideoDuration = duration
-				}
-			}
+// volumeFileKind tells which scan pipeline a file belongs to, hidden and unknown files are skipped
+func volumeFileKind(path string, allowedVideoExt []string) string {
+	if strings.HasPrefix(filepath.Base(path), ".") {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	switch {
+	case funk.Contains(allowedVideoExt, strings.ToLower(ext)):
+		return "video"
+	case ext == ".funscript" || strings.ToLower(ext) == ".cmscript":
+		return "script"
+	case ext == ".hsp":
+		return "hsp"
+	case ext == ".srt" || ext == ".ssa" || ext == ".ass":
+		return "subtitles"
+	}
+	return ""
+}
+
+// videoNeedsScan reports whether a video of the volume is new or changed since it was last probed and hashed
+func videoNeedsScan(db *gorm.DB, volID uint, path string, size int64) bool {
+	var fl models.File
+	err := db.Where(&models.File{VolumeID: volID, Path: filepath.Dir(path), Filename: filepath.Base(path)}).First(&fl).Error
+	return err == gorm.ErrRecordNotFound || fl.VideoDuration == 0 || fl.VideoProjection == "" || fl.Size != size || fl.OsHash == ""
+}
+
+// mountedVolumes remembers which volumes are mounted for the length of a scan, checking a remote volume
+// goes over the network
+type mountedVolumes struct {
+	mutex   sync.Mutex
+	mounted map[uint]bool
+}
+
+func newMountedVolumes() *mountedVolumes {
+	return &mountedVolumes{mounted: map[uint]bool{}}
+}
+
+func (m *mountedVolumes) isMounted(vol models.Volume) bool {
+	if m == nil {
+		return vol.IsMounted()
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if mounted, ok := m.mounted[vol.ID]; ok {
+		return mounted
+	}
+	m.mounted[vol.ID] = vol.IsMounted()
+	return m.mounted[vol.ID]
+}
 
-			fl.CreatedTime = fTimes.ModTime()
-			fl.UpdatedTime = fTimes.ModTime()
-			fl.VolumeID = vol.ID
-			fl.Save()
+// findMovedLocalFile looks for a file of the type with the same hash and size that is gone from where it was
+// last seen. Files on volumes that are offline are left alone, they are most likely copies.
+func findMovedLocalFile(db *gorm.DB, mounts *mountedVolumes, fileType string, osHash string, size int64) *models.File {
+	var files []models.File
+	db.Preload("Volume").Where("type = ? and os_hash = ? and size = ?", fileType, osHash, size).Find(&files)
+	for i := range files {
+		if files[i].Volume.ID != 0 && !mounts.isMounted(files[i].Volume) {
+			continue
+		}
+		if !files[i].Exists() {
+			return &files[i]
 		}
+	}
+	return nil
+}
 
-		for _, path := range hspProcList {
-			ScanLocalHspFile(path, vol.ID, 0)
+func recordFileMove(db *gorm.DB, fl *models.File, newPath string) {
+	var sceneID string
+	if fl.SceneID != 0 {
+		var scene models.Scene
+		if db.Where("id = ?", fl.SceneID).First(&scene).Error == nil {
+			sceneID = scene.SceneID
 		}
+	}
+	move, _ := json.Marshal(map[string]interface{}{
+		"file_id": fl.ID,
+		"from":    fl.GetPath(),
+		"to":      newPath,
+	})
+	models.SaveWithRetry(db, &models.Action{SceneID: sceneID, ActionType: "move", ChangedColumn: "file_path", NewValue: string(move)})
+}
 
-		for _, path := range subtitlesProcList {
-			ScanLocalSubtitlesFile(path, vol.ID, 0)
+// videoProbe holds everything read from a video on disk, gathering it is the slow part of a scan and
+// does not touch the database so it can run on several files at once
+type videoProbe struct {
+	path      string
+	size      int64
+	birthtime time.Time
+	modTime   time.Time
+	osHash    string
+	ffdata    *ffprobe.ProbeData
+	probeErr  error
+	// nil when the filename names the projection or the frames could not be analysed
+	projection *projectionAnalysis
+
+	// analyse runs ffprobe and the frame analysis, it is skipped for moved files that were probed before
+	analyse  func(p *videoProbe)
+	analysed bool
+}
+
+func (p *videoProbe) runAnalysis() {
+	if p.analysed || p.analyse == nil {
+		return
+	}
+	p.analyse(p)
+	p.analysed = true
+}
+
+// isProbedMove tells whether a hashed video is a moved file whose record already holds the probe results
+func isProbedMove(db *gorm.DB, mounts *mountedVolumes, p *videoProbe) bool {
+	if p.osHash == "" {
+		return false
+	}
+	old := findMovedLocalFile(db, mounts, "video", p.osHash, p.size)
+	return old != nil && old.VideoDuration > 0 && old.VideoProjection != ""
+}
+
+// duration of the first video stream, or of the container when the stream does not have one
+func (p *videoProbe) duration() float64 {
+	if p.probeErr != nil || p.ffdata == nil {
+		return 0
+	}
+	if vs := p.ffdata.GetFirstVideoStream(); vs != nil {
+		if dur, err := strconv.ParseFloat(vs.Duration, 64); err == nil {
+			return dur
 		}
+	}
+	return p.ffdata.Format.DurationSeconds
+}
 
-		vol.LastScan = time.Now()
-		vol.Save()
+// hashLocalVideoFile reads the times and hash of a video, the slower probing is left to runAnalysis
+// hashLocalVideoFile returns nil when the file is gone, it may have been removed since the volume was walked
+func hashLocalVideoFile(path string, tlog *logrus.Entry) *videoProbe {
+	fStat, err := os.Stat(path)
+	if err != nil {
+		tlog.Warnf("Skipping %s, error: %s", path, err)
+		return nil
+	}
+	fTimes, err := times.Stat(path)
+	if err != nil {
+		tlog.Warnf("Can't get the modification/creation times for %s, skipping it, error: %s", path, err)
+		return nil
+	}
 
-		var scene models.Scene
-		// Check if files are still present at the location
-		allFiles := vol.Files()
-		for i := range allFiles {
-			if !allFiles[i].Exists() {
-				log.Info(allFiles[i].GetPath())
-				db.Delete(&allFiles[i])
-				if allFiles[i].SceneID != 0 {
-					scene.GetIfExistByPK(allFiles[i].SceneID)
-					scene.UpdateStatus()
-				}
+	p := &videoProbe{path: path, size: fStat.Size(), modTime: fTimes.ModTime()}
+	if fTimes.HasBirthTime() {
+		p.birthtime = fTimes.BirthTime()
+	} else {
+		p.birthtime = fTimes.ModTime()
+	}
+
+	hash, err := Hash(path)
+	if err == nil {
+		p.osHash = fmt.Sprintf("%x", hash)
+	}
+
+	p.analyse = func(p *videoProbe) {
+		p.ffdata, p.probeErr = ffprobe.GetProbeData(path, time.Second*5)
+		analyseProbedVideo(p, path, tlog)
+	}
+	return p
+}
+
+func scanLocalVideoFile(vol models.Volume, db *gorm.DB, mounts *mountedVolumes, path string, tlog *logrus.Entry) {
+	if p := hashLocalVideoFile(path, tlog); p != nil {
+		saveVideoFile(vol, db, mounts, p, tlog)
+	}
+}
+
+// saveVideoFile stores a hashed video, probing it unless it is a moved file that was probed before. db may be
+// a transaction shared with other files of the same batch.
+func saveVideoFile(vol models.Volume, db *gorm.DB, mounts *mountedVolumes, p *videoProbe, tlog *logrus.Entry) {
+	path := p.path
+
+	var fl models.File
+	moved := false
+	err := db.Where(&models.File{
+		VolumeID: vol.ID,
+		Path:     filepath.Dir(path),
+		Filename: filepath.Base(path),
+		Type:     "video",
+	}).First(&fl).Error
+	var old *models.File
+	if err == gorm.ErrRecordNotFound && p.osHash != "" {
+		old = findMovedLocalFile(db, mounts, "video", p.osHash, p.size)
+	}
+	if old == nil || old.VideoDuration == 0 || old.VideoProjection == "" {
+		p.runAnalysis()
+	}
+	duration := p.duration()
+	if !p.analysed && old != nil {
+		duration = old.VideoDuration
+	}
+	if newVolumeScanFilter(vol).tooShort(duration) {
+		tlog.Debugf("Skipping %v, it is shorter than the minimum duration of %v", path, vol.Path)
+		return
+	}
+
+	if old != nil {
+		// Keep the record of a file that was moved or renamed, it holds the scene match and everything else linked to it
+		recordFileMove(db, old, path)
+		tlog.Infof("File %s moved to %s", old.GetPath(), path)
+		fl = *old
+		fl.Volume = models.Volume{}
+		fl.Path = filepath.Dir(path)
+		fl.Filename = filepath.Base(path)
+		moved = true
+	}
+
+	if fl.ID == 0 {
+		db.Where(&models.File{
+			VolumeID: vol.ID,
+			Path:     filepath.Dir(path),
+			Filename: filepath.Base(path),
+			Type:     "video",
+		}).FirstOrCreate(&fl)
+	}
+
+	fl.Size = p.size
+	fl.CreatedTime = p.birthtime
+	fl.UpdatedTime = p.modTime
+	fl.VolumeID = vol.ID
+
+	if p.osHash != "" {
+		fl.OsHash = p.osHash
+	}
+
+	if moved && fl.VideoDuration > 0 && fl.VideoProjection != "" {
+		if err := db.Save(&fl).Error; err != nil {
+			tlog.Errorf("Moved file %s, but got error %s", path, err)
+		}
+		return
+	}
+
+	ffdata := p.ffdata
+	if p.probeErr != nil {
+		tlog.Error("Error running ffprobe", path, p.probeErr)
+	} else {
+		vs := ffdata.GetFirstVideoStream()
+		if vs == nil {
+			tlog.Error("No video stream in file ", path)
+		} else {
+			if vs.BitRate != "" {
+				bitRate, _ := strconv.Atoi(vs.BitRate)
+				fl.VideoBitRate = bitRate
+			}
+			fl.VideoAvgFrameRate = vs.AvgFrameRate
+			fl.VideoCodecName = vs.CodecName
+			fl.VideoWidth = vs.Width
+			fl.VideoHeight = vs.Height
+			if dur, err := strconv.ParseFloat(vs.Duration, 64); err == nil {
+				fl.VideoDuration = dur
+			} else if ffdata.Format.DurationSeconds > 0.0 {
+				fl.VideoDuration = ffdata.Format.DurationSeconds
 			}
+			applyVideoProjection(&fl, p.projection, vol.ContentType)
+
+			fl.CalculateFramerate()
+		}
+	}
+
+	err = db.Save(&fl).Error
+	if err != nil {
+		tlog.Errorf("New file %s, but got error %s", path, err)
+	}
+}
+
+// scriptFileHash identifies a script by its content, scripts are mostly too small for an OSDB hash
+func scriptFileHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum(data))
+}
+
+func scanLocalScriptFile(vol models.Volume, db *gorm.DB, mounts *mountedVolumes, path string) {
+	fStat, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	fTimes, _ := times.Stat(path)
+	hash := scriptFileHash(path)
+
+	var fl models.File
+	err = db.Where(&models.File{
+		VolumeID: vol.ID,
+		Path:     filepath.Dir(path),
+		Filename: filepath.Base(path),
+		Type:     "script",
+	}).First(&fl).Error
+	if err == gorm.ErrRecordNotFound && hash != "" {
+		// Keep the record of a moved script, it holds the scene match, the selected script and the heatmap
+		if old := findMovedLocalFile(db, mounts, "script", hash, fStat.Size()); old != nil {
+			recordFileMove(db, old, path)
+			log.Infof("File %s moved to %s", old.GetPath(), path)
+			fl = *old
+			fl.Volume = models.Volume{}
+			fl.Path = filepath.Dir(path)
+			fl.Filename = filepath.Base(path)
 		}
 	}
+	if fl.ID == 0 {
+		db.Where(&models.File{
+			VolumeID: vol.ID,
+			Path:     filepath.Dir(path),
+			Filename: filepath.Base(path),
+			Type:     "script",
+		}).FirstOrCreate(&fl)
+	}
+	if hash != "" {
+		fl.OsHash = hash
+	}
+
+	if fStat.Size() != fl.Size {
+		fl.Size = fStat.Size()
+		fl.HasHeatmap = false
+		fl.VideoDuration = 0.0
+	}
+
+	if fl.VideoDuration < 0.01 {
+		duration, err := getFunscriptDuration(path)
+		if err == nil {
+			fl.VideoDuration = duration
+		}
+	}
+
+	fl.CreatedTime = fTimes.ModTime()
+	fl.UpdatedTime = fTimes.ModTime()
+	fl.VolumeID = vol.ID
+	models.SaveWithRetry(db, &fl)
 }
 
 func scanPutIO(vol models.Volume, db *gorm.DB, tlog *logrus.Entry) {
@@ -0,0 +1,143 @@
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func newMoveTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.AutoMigrate(&models.Volume{}, &models.File{}, &models.Scene{}, &models.Action{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanLocalScriptFile_KeepsMovedScript(t *testing.T) {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	vol := models.Volume{Type: "local", Path: root}
+	db.Create(&vol)
+
+	oldPath := filepath.Join(root, "new", "Scene.funscript")
+	writeTestFile(t, oldPath, `{"actions":[{"at":0,"pos":0},{"at":60000,"pos":100}]}`)
+	scanLocalScriptFile(vol, db, newMountedVolumes(), oldPath)
+
+	var fl models.File
+	if err := db.Where("type = ?", "script").First(&fl).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&fl).UpdateColumns(map[string]interface{}{"scene_id": 7, "is_selected_script": true, "has_heatmap": true})
+
+	newPath := filepath.Join(root, "sorted", "Scene (renamed).funscript")
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	scanLocalScriptFile(vol, db, newMountedVolumes(), newPath)
+
+	var files []models.File
+	db.Where("type = ?", "script").Find(&files)
+	if len(files) != 1 {
+		t.Fatalf("expected the moved script to keep its record, got %d records", len(files))
+	}
+	got := files[0]
+	if got.ID != fl.ID || got.GetPath() != newPath {
+		t.Fatalf("expected record %d at %s, got %d at %s", fl.ID, newPath, got.ID, got.GetPath())
+	}
+	if got.SceneID != 7 || !got.IsSelectedScript || !got.HasHeatmap {
+		t.Fatalf("expected scene, selected script and heatmap to be kept, got %+v", got)
+	}
+
+	var moves int
+	db.Model(&models.Action{}).Where("action_type = ?", "move").Count(&moves)
+	if moves != 1 {
+		t.Fatalf("expected the move to be recorded, got %d actions", moves)
+	}
+}
+
+func TestScanLocalScriptFile_CopyGetsNewRecord(t *testing.T) {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	vol := models.Volume{Type: "local", Path: root}
+	db.Create(&vol)
+
+	script := `{"actions":[{"at":0,"pos":0},{"at":1000,"pos":100}]}`
+	original := filepath.Join(root, "a.funscript")
+	writeTestFile(t, original, script)
+	scanLocalScriptFile(vol, db, newMountedVolumes(), original)
+
+	copied := filepath.Join(root, "copy", "a.funscript")
+	writeTestFile(t, copied, script)
+	scanLocalScriptFile(vol, db, newMountedVolumes(), copied)
+
+	var count int
+	db.Model(&models.File{}).Where("type = ?", "script").Count(&count)
+	if count != 2 {
+		t.Fatalf("expected a copy to get its own record, got %d records", count)
+	}
+}
+
+func TestFindMovedLocalFile(t *testing.T) {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "other.mp4"), "x")
+	vol := models.Volume{Type: "local", Path: root}
+	db.Create(&vol)
+	offline := models.Volume{Type: "local", Path: filepath.Join(root, "unplugged")}
+	db.Create(&offline)
+
+	gone := models.File{Type: "video", VolumeID: vol.ID, Path: root, Filename: "gone.mp4", OsHash: "abc", Size: 10}
+	db.Create(&gone)
+	db.Create(&models.File{Type: "video", VolumeID: offline.ID, Path: offline.Path, Filename: "away.mp4", OsHash: "def", Size: 10})
+
+	if got := findMovedLocalFile(db, newMountedVolumes(), "video", "abc", 10); got == nil || got.ID != gone.ID {
+		t.Fatalf("expected the missing video to be found, got %+v", got)
+	}
+	if got := findMovedLocalFile(db, newMountedVolumes(), "script", "abc", 10); got != nil {
+		t.Fatalf("expected a file of another type not to be found, got %+v", got)
+	}
+	if got := findMovedLocalFile(db, newMountedVolumes(), "video", "abc", 11); got != nil {
+		t.Fatalf("expected a file of another size not to be found, got %+v", got)
+	}
+	if got := findMovedLocalFile(db, newMountedVolumes(), "video", "def", 10); got != nil {
+		t.Fatalf("expected files of offline volumes to be left alone, got %+v", got)
+	}
+}
+
+func TestVideoNeedsScan_LooksUpTheVolume(t *testing.T) {
+	db := newMoveTestDB(t)
+	first := models.Volume{Type: "sftp", Path: "sftp://one/"}
+	db.Create(&first)
+	second := models.Volume{Type: "sftp", Path: "sftp://two/"}
+	db.Create(&second)
+
+	db.Create(&models.File{Type: "video", VolumeID: first.ID, Path: "/videos", Filename: "scene.mp4", Size: 10,
+		OsHash: "abc", VideoDuration: 60, VideoProjection: "180_sbs"})
+
+	if videoNeedsScan(db, first.ID, "/videos/scene.mp4", 10) {
+		t.Fatal("expected the probed video not to be scanned again")
+	}
+	if !videoNeedsScan(db, second.ID, "/videos/scene.mp4", 10) {
+		t.Fatal("expected the same path on another volume to be scanned")
+	}
+}
@@ -0,0 +1,121 @@
+package tasks
+
+import (
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// Number of probed videos written to the database in one transaction
+const videoBatchSize = 50
+
+type indexedVideoProbe struct {
+	index int
+	probe *videoProbe
+}
+
+// volumeScanConcurrency is the number of videos probed at once on a volume. Spinning disks do best with one,
+// SSDs and NAS mounts with more, so volumes can override the global setting.
+func volumeScanConcurrency(vol models.Volume) int {
+	n := vol.ScanConcurrency
+	if n <= 0 {
+		n = config.Config.Storage.ScanConcurrency
+	}
+	if n <= 0 {
+		n = 1
+	}
+	return n
+}
+
+// scanVideoFiles hashes and probes videos with a pool of workers. Moved files are looked up by their hash first,
+// so they aren't probed again. Results are saved in the order of the list, so the database ends up the same as
+// after probing the files one by one. Files that are gone by the time they are hashed are skipped.
+func scanVideoFiles(vol models.Volume, db *gorm.DB, mounts *mountedVolumes, paths []string, hash func(path string) *videoProbe, tlog *logrus.Entry) {
+	if len(paths) == 0 {
+		return
+	}
+
+	workers := volumeScanConcurrency(vol)
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+
+	jobs := make(chan int)
+	results := make(chan indexedVideoProbe, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				p := hash(paths[i])
+				if p != nil && !isProbedMove(db, mounts, p) {
+					p.runAnalysis()
+				}
+				results <- indexedVideoProbe{index: i, probe: p}
+			}
+		}()
+	}
+	go func() {
+		for i := range paths {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	probed := map[int]*videoProbe{}
+	var batch []*videoProbe
+	next := 0
+	for r := range results {
+		probed[r.index] = r.probe
+		for {
+			p, ok := probed[next]
+			if !ok {
+				break
+			}
+			delete(probed, next)
+			if p != nil {
+				batch = append(batch, p)
+			}
+			next++
+
+			tlog.Infof("Scanning %v (%v/%v)", vol.Path, next, len(paths))
+			if len(batch) >= videoBatchSize {
+				saveVideoBatch(vol, db, mounts, batch, tlog)
+				batch = nil
+			}
+		}
+		publishScanProgress(vol, next, len(paths))
+	}
+	saveVideoBatch(vol, db, mounts, batch, tlog)
+	publishScanProgress(vol, len(paths), len(paths))
+}
+
+func saveVideoBatch(vol models.Volume, db *gorm.DB, mounts *mountedVolumes, batch []*videoProbe, tlog *logrus.Entry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	tx := db.Begin()
+	for _, p := range batch {
+		saveVideoFile(vol, tx, mounts, p, tlog)
+	}
+	if err := tx.Commit().Error; err != nil {
+		tlog.Errorf("Failed to save %v scanned files of %v: %v", len(batch), vol.Path, err)
+	}
+}
+
+func publishScanProgress(vol models.Volume, processed int, total int) {
+	common.PublishWS("rescan.progress", map[string]interface{}{
+		"volume_id": vol.ID,
+		"path":      vol.Path,
+		"processed": processed,
+		"total":     total,
+	})
+}
@@ -0,0 +1,121 @@
+package tasks
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/ffprobe"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// fakeVideoHasher hashes made up videos, probing takes a random while so workers finish out of order
+func fakeVideoHasher(probes *int32) func(path string) *videoProbe {
+	return func(path string) *videoProbe {
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "video"), ".mp4"))
+		p := &videoProbe{path: path, size: int64(1000 + n), osHash: fmt.Sprintf("%016x", n)}
+		p.analyse = func(p *videoProbe) {
+			atomic.AddInt32(probes, 1)
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			p.ffdata = &ffprobe.ProbeData{
+				Format:  &ffprobe.Format{},
+				Streams: []*ffprobe.Stream{{CodecType: "video", CodecName: "h264", Width: 3840 + n, Height: 1920, Duration: strconv.Itoa(60 + n)}},
+			}
+		}
+		return p
+	}
+}
+
+func scannedVideos(t *testing.T, concurrency int, paths []string) []models.File {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "marker"), "x")
+	vol := models.Volume{Type: "local", Path: root, ScanConcurrency: concurrency}
+	db.Create(&vol)
+
+	var probes int32
+	scanVideoFiles(vol, db, newMountedVolumes(), paths, fakeVideoHasher(&probes), logrus.NewEntry(logrus.New()))
+
+	var files []models.File
+	db.Order("id").Find(&files)
+	return files
+}
+
+func TestScanVideoFiles_SameResultAsSequential(t *testing.T) {
+	var paths []string
+	for i := 0; i < 2*videoBatchSize+7; i++ {
+		paths = append(paths, fmt.Sprintf("/vr/video%d.mp4", i))
+	}
+
+	sequential := scannedVideos(t, 1, paths)
+	parallel := scannedVideos(t, 8, paths)
+	if len(sequential) != len(paths) || len(parallel) != len(paths) {
+		t.Fatalf("expected %d files, got %d and %d", len(paths), len(sequential), len(parallel))
+	}
+	for i := range sequential {
+		s, p := sequential[i], parallel[i]
+		if s.ID != p.ID || s.GetPath() != p.GetPath() || s.OsHash != p.OsHash || s.VideoWidth != p.VideoWidth || s.VideoDuration != p.VideoDuration || s.VideoProjection != p.VideoProjection {
+			t.Fatalf("file %d differs: sequential %+v, parallel %+v", i, s, p)
+		}
+	}
+}
+
+func TestScanVideoFiles_MovedFileIsNotProbed(t *testing.T) {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "marker"), "x")
+	vol := models.Volume{Type: "local", Path: root, ScanConcurrency: 2}
+	db.Create(&vol)
+
+	old := models.File{Type: "video", VolumeID: vol.ID, Path: filepath.Join(root, "old"), Filename: "video3.mp4",
+		OsHash: fmt.Sprintf("%016x", 3), Size: 1003, VideoDuration: 63, VideoProjection: "180_sbs", SceneID: 9}
+	db.Create(&old)
+
+	var probes int32
+	paths := []string{filepath.Join(root, "new", "video3.mp4"), filepath.Join(root, "new", "video4.mp4")}
+	scanVideoFiles(vol, db, newMountedVolumes(), paths, fakeVideoHasher(&probes), logrus.NewEntry(logrus.New()))
+
+	if probes != 1 {
+		t.Fatalf("expected only the new video to be probed, got %d probes", probes)
+	}
+	var moved models.File
+	db.First(&moved, old.ID)
+	if moved.GetPath() != paths[0] || moved.SceneID != 9 {
+		t.Fatalf("expected the moved record to be kept, got %+v", moved)
+	}
+}
+
+func TestScanVideoFiles_SkipsVanishedFiles(t *testing.T) {
+	db := newMoveTestDB(t)
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "marker"), "x")
+	vol := models.Volume{Type: "local", Path: root, ScanConcurrency: 2}
+	db.Create(&vol)
+
+	tlog := logrus.NewEntry(logrus.New())
+	if p := hashLocalVideoFile(filepath.Join(root, "gone.mp4"), tlog); p != nil {
+		t.Fatalf("expected no probe for a missing file, got %+v", p)
+	}
+
+	var probes int32
+	hash := fakeVideoHasher(&probes)
+	paths := []string{"/vr/video1.mp4", "/vr/video2.mp4", "/vr/video3.mp4"}
+	scanVideoFiles(vol, db, newMountedVolumes(), paths, func(path string) *videoProbe {
+		if path == "/vr/video2.mp4" {
+			return nil
+		}
+		return hash(path)
+	}, tlog)
+
+	var files []models.File
+	db.Order("id").Find(&files)
+	if len(files) != 2 || files[0].Filename != "video1.mp4" || files[1].Filename != "video3.mp4" {
+		t.Fatalf("expected the vanished file to be skipped, got %+v", files)
+	}
+}
@@ -0,0 +1,120 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/ffprobe"
+	"github.com/xbapps/xbvr/pkg/models"
+	"github.com/xbapps/xbvr/pkg/storage"
+)
+
+// scanRemoteVolume indexes a SFTP, WebDAV or S3 volume. Videos are hashed with ranged reads and probed through
+// a loopback URL, so nothing is downloaded as a whole.
+func scanRemoteVolume(vol models.Volume, db *gorm.DB, tlog *logrus.Entry) {
+	if !vol.IsMounted() {
+		tlog.Warnf("%v is not reachable", vol.Path)
+		return
+	}
+	backend, err := vol.GetBackend()
+	if err != nil {
+		tlog.Errorf("Can't connect to %v: %v", vol.Path, err)
+		return
+	}
+
+	entries, err := backend.List(context.Background())
+	if err != nil {
+		tlog.Errorf("Can't list %v: %v", vol.Path, err)
+		return
+	}
+
+	filter := newVolumeScanFilter(vol)
+	entryByPath := map[string]storage.Entry{}
+	listed := map[string]bool{}
+	var videoProcList []string
+	for _, e := range entries {
+		listed[e.Path] = true
+		kind := filter.fileKind(e.Path, e.Size)
+		if kind == "" {
+			continue
+		}
+		entryByPath[e.Path] = e
+
+		if kind == "video" {
+			if videoNeedsScan(db, vol.ID, e.Path, e.Size) {
+				videoProcList = append(videoProcList, e.Path)
+			}
+			continue
+		}
+		saveRemoteFile(vol, db, e, kind)
+	}
+
+	scanVideoFiles(vol, db, newMountedVolumes(), videoProcList, func(p string) *videoProbe {
+		return hashRemoteVideoFile(backend, entryByPath[p], tlog)
+	}, tlog)
+
+	vol.LastScan = time.Now()
+	vol.Save()
+
+	// Files that were not listed are gone. Scan rules only limit what is scanned, files already in the library
+	// that no longer pass them are kept.
+	var scene models.Scene
+	for _, fl := range vol.Files() {
+		if listed[fl.GetPath()] {
+			continue
+		}
+		tlog.Info("Removed ", fl.GetPath())
+		db.Delete(&fl)
+		if fl.SceneID != 0 {
+			scene.GetIfExistByPK(fl.SceneID)
+			scene.UpdateStatus()
+		}
+	}
+}
+
+func hashRemoteVideoFile(backend storage.Backend, e storage.Entry, tlog *logrus.Entry) *videoProbe {
+	p := &videoProbe{path: e.Path, size: e.Size, birthtime: e.ModTime, modTime: e.ModTime}
+
+	reader := storage.NewFileReader(context.Background(), backend, e.Path, e.Size)
+	if hash, err := HashReaderAt(reader, e.Size); err == nil {
+		p.osHash = fmt.Sprintf("%x", hash)
+	} else {
+		tlog.Warnf("Can't hash %v: %v", e.Path, err)
+	}
+
+	p.analyse = func(p *videoProbe) {
+		url, stop, err := storage.LoopbackURL(backend, e)
+		if err != nil {
+			p.probeErr = err
+			return
+		}
+		defer stop()
+		p.ffdata, p.probeErr = ffprobe.GetProbeData(url, time.Second*30)
+		analyseProbedVideo(p, url, tlog)
+	}
+	return p
+}
+
+// saveRemoteFile stores scripts, HereSphere and subtitle files, their contents are read when they are served
+func saveRemoteFile(vol models.Volume, db *gorm.DB, e storage.Entry, kind string) {
+	var fl models.File
+	db.Where(&models.File{
+		VolumeID: vol.ID,
+		Path:     path.Dir(e.Path),
+		Filename: path.Base(e.Path),
+		Type:     kind,
+	}).FirstOrCreate(&fl)
+
+	if fl.Size != e.Size {
+		fl.HasHeatmap = false
+	}
+	fl.Size = e.Size
+	fl.CreatedTime = e.ModTime
+	fl.UpdatedTime = e.ModTime
+	fl.VolumeID = vol.ID
+	fl.Save()
+}
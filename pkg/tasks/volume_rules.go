@@ -0,0 +1,180 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/xbapps/xbvr/pkg/models"
+	"github.com/xbapps/xbvr/pkg/storage"
+)
+
+type scanGlob struct {
+	re *regexp.Regexp
+	// Globs without a slash match the name of the file or of any folder it is in
+	anyName bool
+}
+
+// volumeScanFilter applies the scan rules of a volume on top of the global video extensions and hidden file check
+type volumeScanFilter struct {
+	root            string
+	include         []scanGlob
+	exclude         []scanGlob
+	allowedVideoExt []string
+	minSize         int64
+	minDuration     float64
+}
+
+func newVolumeScanFilter(vol models.Volume) *volumeScanFilter {
+	rules := vol.GetScanRules()
+
+	f := &volumeScanFilter{
+		root:            vol.Path,
+		allowedVideoExt: getAllowedVideoExt(),
+		minSize:         rules.MinSize,
+		minDuration:     rules.MinDuration,
+	}
+	if vol.IsRemote() {
+		cfg, _ := storage.ParseConfig(vol.Metadata)
+		f.root = storage.RootPath(cfg)
+	}
+	f.include = compileScanGlobs(vol, rules.Include)
+	f.exclude = compileScanGlobs(vol, rules.Exclude)
+	if len(rules.VideoExt) > 0 {
+		f.allowedVideoExt = nil
+		for _, ext := range rules.VideoExt {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext == "" {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			f.allowedVideoExt = append(f.allowedVideoExt, ext)
+		}
+	}
+	return f
+}
+
+func compileScanGlobs(vol models.Volume, globs []string) []scanGlob {
+	var out []scanGlob
+	for _, g := range globs {
+		re, err := globToRegexp(g)
+		if err != nil {
+			log.Warnf("Ignoring scan rule %q of %v: %v", g, vol.Path, err)
+			continue
+		}
+		out = append(out, scanGlob{re: re, anyName: !strings.Contains(strings.Trim(filepath.ToSlash(g), "/"), "/")})
+	}
+	return out
+}
+
+// CheckScanRules rejects globs a scan could not use, so they are not saved on a volume
+func CheckScanRules(rules models.VolumeScanRules) error {
+	for _, g := range append(append([]string{}, rules.Include...), rules.Exclude...) {
+		if _, err := globToRegexp(g); err != nil {
+			return fmt.Errorf("invalid scan rule %q: %v", g, err)
+		}
+	}
+	return nil
+}
+
+// globToRegexp turns a glob into a case-insensitive regexp, ** crosses folders while * and ? stay within one
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	glob = strings.Trim(strings.TrimSpace(filepath.ToSlash(glob)), "/")
+	if glob == "" {
+		return nil, errors.New("pattern is empty")
+	}
+	if strings.Contains(glob, "***") {
+		return nil, errors.New("use * or ** instead of ***")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// **/ also matches no folder at all
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
+
+func (f *volumeScanFilter) relative(p string) string {
+	p = filepath.ToSlash(p)
+	root := strings.TrimSuffix(filepath.ToSlash(f.root), "/")
+	if root != "" && strings.HasPrefix(p, root+"/") {
+		p = p[len(root)+1:]
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+func matchesAny(globs []scanGlob, rel string) bool {
+	if len(globs) == 0 {
+		return false
+	}
+	names := strings.Split(rel, "/")
+	for _, g := range globs {
+		if g.re.MatchString(rel) {
+			return true
+		}
+		if g.anyName {
+			for _, name := range names {
+				if g.re.MatchString(name) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// skipDir tells whether a whole folder is excluded, so a scan does not need to walk it
+func (f *volumeScanFilter) skipDir(p string) bool {
+	rel := f.relative(p)
+	return rel != "" && matchesAny(f.exclude, rel)
+}
+
+// fileKind is volumeFileKind with the rules of the volume applied, excluded files have no kind
+func (f *volumeScanFilter) fileKind(p string, size int64) string {
+	kind := volumeFileKind(p, f.allowedVideoExt)
+	if kind == "" {
+		return ""
+	}
+
+	rel := f.relative(p)
+	if matchesAny(f.exclude, rel) {
+		return ""
+	}
+	if len(f.include) > 0 && !matchesAny(f.include, rel) {
+		return ""
+	}
+	if kind == "video" && f.minSize > 0 && size < f.minSize {
+		return ""
+	}
+	return kind
+}
+
+// tooShort tells whether a probed video is below the minimum duration of the volume
+func (f *volumeScanFilter) tooShort(duration float64) bool {
+	return f.minDuration > 0 && duration > 0 && duration < f.minDuration
+}
@@ -0,0 +1,88 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func TestVolumeScanFilter(t *testing.T) {
+	vol := models.Volume{Path: "/media/vr", Type: "local"}
+	vol.SetScanRules(models.VolumeScanRules{
+		Include:     []string{"Scenes/**", "*.funscript"},
+		Exclude:     []string{"Trailers", "*sample*"},
+		VideoExt:    []string{"mp4", ".MKV"},
+		MinSize:     1000,
+		MinDuration: 60,
+	})
+	f := newVolumeScanFilter(vol)
+
+	cases := []struct {
+		path string
+		size int64
+		want string
+	}{
+		{"/media/vr/Scenes/Studio/scene.mp4", 5000, "video"},
+		{"/media/vr/Scenes/Studio/scene.MKV", 5000, "video"},
+		{"/media/vr/Scenes/Studio/scene.avi", 5000, ""},
+		{"/media/vr/Scenes/Studio/scene.mp4", 500, ""},
+		{"/media/vr/Scenes/Studio/scene_sample.mp4", 5000, ""},
+		{"/media/vr/Scenes/Trailers/scene.mp4", 5000, ""},
+		{"/media/vr/Other/scene.mp4", 5000, ""},
+		{"/media/vr/Other/scene.funscript", 10, "script"},
+	}
+	for _, c := range cases {
+		if got := f.fileKind(c.path, c.size); got != c.want {
+			t.Fatalf("%s (%d bytes): expected %q, got %q", c.path, c.size, c.want, got)
+		}
+	}
+
+	if !f.skipDir("/media/vr/Scenes/trailers") {
+		t.Fatalf("expected excluded folders to be skipped")
+	}
+	if f.skipDir("/media/vr") || f.skipDir("/media/vr/Scenes") {
+		t.Fatalf("expected other folders to be walked")
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	cases := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"**/*.mp4", "scene.mp4", true},
+		{"**/*.mp4", "a/b/scene.mp4", true},
+		{"*.mp4", "a/scene.mp4", false},
+		{"a/*/c", "a/b/c", true},
+		{"a/*/c", "a/b/x/c", false},
+		{"a/**", "a/b/x/c", true},
+		{"scene?.mp4", "scene1.mp4", true},
+		{"scene?.mp4", "scene12.mp4", false},
+		{"(PMV) [1080p]", "(pmv) [1080p]", true},
+	}
+	for _, c := range cases {
+		re, err := globToRegexp(c.glob)
+		if err != nil {
+			t.Fatalf("%s: %v", c.glob, err)
+		}
+		if got := re.MatchString(c.path); got != c.match {
+			t.Fatalf("%s on %s: expected %v, got %v", c.glob, c.path, c.match, got)
+		}
+	}
+	if _, err := globToRegexp(" / "); err == nil {
+		t.Fatalf("expected an empty glob to be refused")
+	}
+}
+
+func TestCheckScanRules(t *testing.T) {
+	if err := CheckScanRules(models.VolumeScanRules{Include: []string{"**/Scenes/**"}, Exclude: []string{"*sample*"}}); err != nil {
+		t.Fatalf("expected valid rules to pass, got %v", err)
+	}
+	if err := CheckScanRules(models.VolumeScanRules{Exclude: []string{"*sample*", "/"}}); err == nil {
+		t.Fatalf("expected an empty glob to be refused")
+	}
+	if err := CheckScanRules(models.VolumeScanRules{Include: []string{"a/***/b"}}); err == nil {
+		t.Fatalf("expected *** to be refused")
+	}
+}
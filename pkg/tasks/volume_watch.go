@@ -0,0 +1,376 @@
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/xbapps/xbvr/pkg/common"
+	"github.com/xbapps/xbvr/pkg/config"
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+// Volume watchers pick up files added, changed, moved or deleted on local volumes as they happen. Events are
+// collected until a path has been quiet for the debounce interval and then only the touched paths go through
+// the scan pipeline. Scheduled full rescans stay in place for anything the watcher misses.
+type volumeWatcher struct {
+	vol     models.Volume
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+var (
+	volumeWatchersMutex sync.Mutex
+	volumeWatchers      = map[uint]*volumeWatcher{}
+
+	watchPendingMutex sync.Mutex
+	watchPending      = map[string]watchedPath{}
+	watchTimer        *time.Timer
+)
+
+// watchMaxWait caps how long a path that keeps changing, like a download in progress, is held back
+const watchMaxWait = 5 * time.Minute
+
+// watchedPath is a path with changes not scanned yet
+type watchedPath struct {
+	vol   models.Volume
+	first time.Time
+	last  time.Time
+}
+
+// due is when the path gets scanned, once quiet for the debounce interval or when it waited too long
+func (p watchedPath) due(debounce time.Duration) time.Time {
+	quiet := p.last.Add(debounce)
+	if capped := p.first.Add(watchMaxWait); capped.Before(quiet) {
+		return capped
+	}
+	return quiet
+}
+
+// RefreshVolumeWatchers starts watchers for mounted local volumes and stops the ones that are no longer needed
+func RefreshVolumeWatchers() {
+	volumeWatchersMutex.Lock()
+	defer volumeWatchersMutex.Unlock()
+
+	tlog := log.WithField("task", "watch")
+
+	wanted := map[uint]models.Volume{}
+	if config.Config.Storage.WatchVolumes {
+		db, _ := models.GetDB()
+		var vols []models.Volume
+		db.Where("type = ?", "local").Find(&vols)
+		db.Close()
+
+		for _, vol := range vols {
+			if vol.IsMounted() {
+				wanted[vol.ID] = vol
+			}
+		}
+	}
+
+	for id, w := range volumeWatchers {
+		if vol, ok := wanted[id]; !ok || vol.Path != w.vol.Path {
+			w.stop()
+			delete(volumeWatchers, id)
+			tlog.Infof("Stopped watching %v", w.vol.Path)
+		}
+	}
+
+	for id, vol := range wanted {
+		if _, ok := volumeWatchers[id]; ok {
+			continue
+		}
+		w, err := newVolumeWatcher(vol)
+		if err != nil {
+			tlog.Warnf("Can't watch %v, changes will be picked up by rescans only: %v", vol.Path, err)
+			continue
+		}
+		volumeWatchers[id] = w
+		tlog.Infof("Watching %v for changes", vol.Path)
+	}
+}
+
+// CheckVolumeAvailability notices local volumes being plugged in, unplugged or mounted at another path between rescans
+func CheckVolumeAvailability() {
+	if models.CheckLock("rescan") {
+		return
+	}
+	if models.CheckLocalVolumes() {
+		RefreshVolumeWatchers()
+		common.PublishWS("state.change.optionsStorage", nil)
+	}
+}
+
+func newVolumeWatcher(vol models.Volume) (*volumeWatcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &volumeWatcher{
+		vol:     vol,
+		watcher: fw,
+		done:    make(chan struct{}),
+	}
+	if err := w.addTree(vol.Path); err != nil {
+		fw.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// addTree watches a folder and all folders below it, inotify does not watch recursively by itself
+func (w *volumeWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil || !f.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(f.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+func (w *volumeWatcher) run() {
+	tlog := log.WithField("task", "watch")
+	for {
+		select {
+		case <-w.done:
+			return
+		case ev, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := w.addTree(ev.Name); err != nil {
+						tlog.Warnf("Can't watch %v: %v", ev.Name, err)
+					}
+				}
+			}
+			w.queue(ev.Name)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			tlog.Warnf("Watcher error on %v: %v", w.vol.Path, err)
+		}
+	}
+}
+
+func (w *volumeWatcher) stop() {
+	close(w.done)
+	w.watcher.Close()
+}
+
+// queue collects changes of all volumes, paths that are due together are scanned in one batch. A file moved
+// between volumes then shows up as created before its old path is cleaned up.
+func (w *volumeWatcher) queue(path string) {
+	watchPendingMutex.Lock()
+	defer watchPendingMutex.Unlock()
+
+	now := time.Now()
+	p, ok := watchPending[path]
+	if !ok {
+		p.first = now
+	}
+	p.vol = w.vol
+	p.last = now
+	watchPending[path] = p
+	scheduleWatchFlush(nextWatchFlush(watchPending, watchDebounce()))
+}
+
+func watchDebounce() time.Duration {
+	debounce := time.Duration(config.Config.Storage.WatchDebounce) * time.Second
+	if debounce <= 0 {
+		debounce = 10 * time.Second
+	}
+	return debounce
+}
+
+// nextWatchFlush returns when the first pending path is due, zero when nothing is pending
+func nextWatchFlush(pending map[string]watchedPath, debounce time.Duration) time.Time {
+	var next time.Time
+	for _, p := range pending {
+		if due := p.due(debounce); next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next
+}
+
+// takeDueWatchedPaths removes the paths that are due from pending and returns them
+func takeDueWatchedPaths(pending map[string]watchedPath, now time.Time, debounce time.Duration) map[string]models.Volume {
+	out := map[string]models.Volume{}
+	for path, p := range pending {
+		if !p.due(debounce).After(now) {
+			out[path] = p.vol
+			delete(pending, path)
+		}
+	}
+	return out
+}
+
+// scheduleWatchFlush (re)starts the timer, the caller holds the mutex
+func scheduleWatchFlush(at time.Time) {
+	if watchTimer != nil {
+		watchTimer.Stop()
+		watchTimer = nil
+	}
+	if !at.IsZero() {
+		watchTimer = time.AfterFunc(time.Until(at), flushWatchedPaths)
+	}
+}
+
+func flushWatchedPaths() {
+	watchPendingMutex.Lock()
+	debounce := watchDebounce()
+	// Leave the changes for later while a full rescan is running, it may pick them up already
+	if models.CheckLock("rescan") {
+		scheduleWatchFlush(time.Now().Add(debounce))
+		watchPendingMutex.Unlock()
+		return
+	}
+	pending := takeDueWatchedPaths(watchPending, time.Now(), debounce)
+	scheduleWatchFlush(nextWatchFlush(watchPending, debounce))
+	watchPendingMutex.Unlock()
+
+	if len(pending) > 0 {
+		scanChangedLocalPaths(pending)
+	}
+}
+
+// scanChangedLocalPaths runs the paths reported by the watchers through the same steps as a full scan of a
+// local volume. Paths that still exist go first so moved files keep their record.
+func scanChangedLocalPaths(pending map[string]models.Volume) {
+	models.CreateLock("rescan")
+	defer models.RemoveLock("rescan")
+
+	tlog := log.WithFields(logrus.Fields{"task": "watch"})
+
+	db, _ := models.GetDB()
+	defer db.Close()
+
+	filters := map[uint]*volumeScanFilter{}
+	filterOf := func(vol models.Volume) *volumeScanFilter {
+		if _, ok := filters[vol.ID]; !ok {
+			filters[vol.ID] = newVolumeScanFilter(vol)
+		}
+		return filters[vol.ID]
+	}
+
+	var changed []string
+	var scripts bool
+	mounts := newMountedVolumes()
+
+	scanPath := func(vol models.Volume, path string, f os.FileInfo) {
+		kind := filterOf(vol).fileKind(path, f.Size())
+		switch kind {
+		case "video":
+			if !videoNeedsScan(db, vol.ID, path, f.Size()) {
+				return
+			}
+			scanLocalVideoFile(vol, db, mounts, path, tlog)
+		case "script":
+			scanLocalScriptFile(vol, db, mounts, path)
+			scripts = true
+		case "hsp":
+			ScanLocalHspFile(path, vol.ID, 0)
+		case "subtitles":
+			ScanLocalSubtitlesFile(path, vol.ID, 0)
+		default:
+			return
+		}
+		changed = append(changed, path)
+	}
+
+	var missing []string
+	for path, vol := range pending {
+		f, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			missing = append(missing, path)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if f.IsDir() {
+			_ = filepath.Walk(path, func(path string, f os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				if f.Mode().IsDir() {
+					if filterOf(vol).skipDir(path) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				scanPath(vol, path, f)
+				return nil
+			})
+		} else {
+			scanPath(vol, path, f)
+		}
+	}
+
+	for _, path := range missing {
+		vol := pending[path]
+		// An unmounted volume looks like everything was deleted, leave it to the rescan to mark it unavailable
+		if !mounts.isMounted(vol) {
+			tlog.Warnf("%v is not available, skipping removal of %v", vol.Path, path)
+			continue
+		}
+		removeMissingLocalFiles(db, vol, path, tlog)
+	}
+
+	if len(changed) > 0 {
+		tlog.Infof("Scanned %v changed files", len(changed))
+
+		var files []models.File
+		for _, path := range changed {
+			var fl models.File
+			err := db.Where(&models.File{Path: filepath.Dir(path), Filename: filepath.Base(path)}).First(&fl).Error
+			if err == nil && fl.SceneID == 0 {
+				files = append(files, fl)
+			}
+		}
+		matchFilesToScenes(db, tlog, files)
+
+		if scripts {
+			GenerateHeatmaps(tlog)
+		}
+	}
+
+	common.PublishWS("state.change.optionsStorage", nil)
+}
+
+// removeMissingLocalFiles drops the files at a path that no longer exists, or below it when a folder went away
+func removeMissingLocalFiles(db *gorm.DB, vol models.Volume, path string, tlog *logrus.Entry) {
+	var files []models.File
+	db.Preload("Volume").Where("volume_id = ? and ((path = ? and filename = ?) or path = ? or path like ?)",
+		vol.ID, filepath.Dir(path), filepath.Base(path), path, path+string(os.PathSeparator)+"%").Find(&files)
+
+	var scene models.Scene
+	for i := range files {
+		if files[i].Exists() {
+			continue
+		}
+		tlog.Info("Removed ", files[i].GetPath())
+		db.Delete(&files[i])
+		if files[i].SceneID != 0 {
+			scene.GetIfExistByPK(files[i].SceneID)
+			scene.UpdateStatus()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xbapps/xbvr/pkg/models"
+)
+
+func TestTakeDueWatchedPaths(t *testing.T) {
+	debounce := 10 * time.Second
+	now := time.Now()
+	pending := map[string]watchedPath{
+		// quiet for longer than the debounce interval
+		"/vr/done.mp4": {vol: models.Volume{ID: 1}, first: now.Add(-time.Minute), last: now.Add(-20 * time.Second)},
+		// still being written, but only for a short while
+		"/vr/writing.mp4": {vol: models.Volume{ID: 1}, first: now.Add(-time.Minute), last: now.Add(-time.Second)},
+		// still being written, for longer than the cap
+		"/vr/download.mp4": {vol: models.Volume{ID: 2}, first: now.Add(-watchMaxWait - time.Second), last: now},
+	}
+
+	due := takeDueWatchedPaths(pending, now, debounce)
+	if len(due) != 2 || due["/vr/done.mp4"].ID != 1 || due["/vr/download.mp4"].ID != 2 {
+		t.Fatalf("expected the quiet path and the one waiting too long, got %+v", due)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("expected the path being written to stay pending, got %+v", pending)
+	}
+
+	next := nextWatchFlush(pending, debounce)
+	if want := now.Add(9 * time.Second); !next.Equal(want) {
+		t.Fatalf("expected next flush at %v, got %v", want, next)
+	}
+	if !nextWatchFlush(map[string]watchedPath{}, debounce).IsZero() {
+		t.Fatalf("expected no flush without pending paths")
+	}
+}
+
+func TestWatchedPathDueIsCapped(t *testing.T) {
+	start := time.Now()
+	p := watchedPath{first: start, last: start}
+	// A file written to every few seconds never goes quiet
+	for i := 0; i < 1000; i++ {
+		p.last = start.Add(time.Duration(i) * 5 * time.Second)
+		if p.due(10 * time.Second).After(start.Add(watchMaxWait)) {
+			t.Fatalf("expected the path to be due within %v, got %v", watchMaxWait, p.due(10*time.Second).Sub(start))
+		}
+	}
+}
@@ -7,6 +7,7 @@ import messages from './messages'
 import overlay from './overlay'
 import files from './files'
 import remote from './remote'
+import users from './users'
 import optionsStorage from './optionsStorage'
 import optionsWeb from './optionsWeb'
 import optionsDLNA from './optionsDLNA'
@@ -28,6 +29,7 @@ export default new Vuex.Store({
     overlay,
     files,
     remote,
+    users,
     optionsStorage,
     optionsDLNA,
     optionsDeoVR,
@@ -3,6 +3,7 @@ const state = {
   lastScrapeMessage: '',
   lockRescan: false,
   lastRescanMessage: '',
+  rescanProgress: {},
   lastProgressMessage: '',
   runningScrapers: [],
   serviceLogs: []
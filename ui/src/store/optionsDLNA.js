@@ -7,6 +7,7 @@ const state = {
     name: '',
     image: '',
     allowedIp: [],
+    user: '',
     recentIp: [],
     availableImages: []
   }
@@ -26,6 +27,7 @@ const actions = {
         state.dlna.name = data.config.interfaces.dlna.serviceName
         state.dlna.image = data.config.interfaces.dlna.serviceImage
         state.dlna.allowedIp = data.config.interfaces.dlna.allowedIp
+        state.dlna.user = data.config.interfaces.dlna.user
         state.loading = false
       })
   },
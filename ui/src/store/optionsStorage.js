@@ -4,6 +4,10 @@ const state = {
   items: [],
   options: {
     match_ohash: false,
+    watch_volumes: true,
+    watch_debounce: 10,
+    allow_duplicate_deletes: false,
+    analyse_projection: false,
     forbidden_video_ext: [],
     video_ext: [],
     default_video_ext: [],
@@ -19,6 +23,10 @@ const actions = {
     .then(data => {
       state.items = data.volumes
       state.options.match_ohash = data.match_ohash
+      state.options.watch_volumes = data.watch_volumes
+      state.options.watch_debounce = data.watch_debounce
+      state.options.allow_duplicate_deletes = data.allow_duplicate_deletes
+      state.options.analyse_projection = data.analyse_projection
       state.options.forbidden_video_ext = data.forbidden_video_ext
       state.options.video_ext = data.video_ext
       state.options.default_video_ext = data.default_video_ext
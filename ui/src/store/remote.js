@@ -42,6 +42,21 @@ const actions = {
     }
 
     commit('setState', payload)
+  },
+  async play ({ state }, { sceneId, fileId }) {
+    await ky.post('/api/remote/play', { json: { scene_id: sceneId, file_id: fileId } })
+  },
+  async seek ({ state }, position) {
+    await ky.post('/api/remote/seek', { json: { position } })
+  },
+  async pause ({ state }) {
+    await ky.post('/api/remote/pause')
+  },
+  async resume ({ state }) {
+    await ky.post('/api/remote/resume')
+  },
+  async playPlaylist ({ state }, { playlistId, sceneIds }) {
+    await ky.post('/api/remote/playlist', { json: { playlist_id: playlistId, scene_ids: sceneIds } })
   }
 }
 
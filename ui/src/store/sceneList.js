@@ -16,7 +16,7 @@ const defaultFilterState = {
   isAvailable: true,
   isAccessible: true,
   isHidden: false,
-  isWatched: null,
+  watchState: null,
   releaseMonth: '',
   cast: [],
   sites: [],
@@ -0,0 +1,46 @@
+import ky from 'ky'
+
+const state = {
+  loading: false,
+  current: {},
+  users: []
+}
+
+const mutations = {}
+
+const actions = {
+  async load ({ state }) {
+    state.loading = true
+    state.current = await ky.get('/api/users/me').json()
+    // Only the default user can list users
+    state.users = state.current.is_admin ? await ky.get('/api/users').json() : []
+    state.loading = false
+  },
+  async login ({ dispatch }, { username, password }) {
+    await ky.post('/api/users/login', { json: { username, password } })
+    await dispatch('load')
+  },
+  async logout ({ dispatch }) {
+    await ky.post('/api/users/logout')
+    await dispatch('load')
+  },
+  async create ({ dispatch }, { username, password }) {
+    await ky.post('/api/users', { json: { username, password } })
+    await dispatch('load')
+  },
+  async update ({ dispatch }, { id, username, password }) {
+    await ky.put(`/api/users/${id}`, { json: { username, password } })
+    await dispatch('load')
+  },
+  async remove ({ dispatch }, id) {
+    await ky.delete(`/api/users/${id}`)
+    await dispatch('load')
+  }
+}
+
+export default {
+  namespaced: true,
+  state,
+  mutations,
+  actions
+}